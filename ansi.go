@@ -0,0 +1,67 @@
+package winrm
+
+const (
+	ansiGround = iota
+	ansiEscape
+	ansiEscapeIntermediate
+	ansiCSI
+	ansiOSC
+	ansiOSCEscape
+)
+
+// ansiStripper removes ANSI/VT escape sequences from a byte stream.
+// It keeps its state between calls so sequences split across
+// several Receive responses are still removed.
+type ansiStripper struct {
+	state int
+}
+
+// strip returns data without the escape sequences it contains
+func (a *ansiStripper) strip(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch a.state {
+		case ansiGround:
+			if b == 0x1b {
+				a.state = ansiEscape
+				continue
+			}
+			out = append(out, b)
+		case ansiEscape:
+			switch {
+			case b == '[':
+				a.state = ansiCSI
+			case b == ']':
+				a.state = ansiOSC
+			case b >= 0x20 && b <= 0x2f:
+				a.state = ansiEscapeIntermediate
+			default:
+				// two bytes sequence like ESC 7 or ESC c
+				a.state = ansiGround
+			}
+		case ansiEscapeIntermediate:
+			if b < 0x20 || b > 0x2f {
+				a.state = ansiGround
+			}
+		case ansiCSI:
+			// parameters and intermediate bytes until the final byte
+			if b >= 0x40 && b <= 0x7e {
+				a.state = ansiGround
+			}
+		case ansiOSC:
+			switch b {
+			case 0x07:
+				a.state = ansiGround
+			case 0x1b:
+				a.state = ansiOSCEscape
+			}
+		case ansiOSCEscape:
+			if b == '\\' {
+				a.state = ansiGround
+			} else {
+				a.state = ansiOSC
+			}
+		}
+	}
+	return out
+}
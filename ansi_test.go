@@ -0,0 +1,20 @@
+package winrm
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestANSIStripper(c *C) {
+	a := &ansiStripper{}
+	c.Assert(string(a.strip([]byte("\x1b[1;32mgreen\x1b[0m text"))), Equals, "green text")
+	c.Assert(string(a.strip([]byte("\x1b]0;title\x07after"))), Equals, "after")
+	c.Assert(string(a.strip([]byte("\x1b]0;title\x1b\\after"))), Equals, "after")
+	c.Assert(string(a.strip([]byte("\x1b(Bplain\x1b7"))), Equals, "plain")
+}
+
+func (s *WinRMSuite) TestANSIStripperSplitSequence(c *C) {
+	a := &ansiStripper{}
+	c.Assert(string(a.strip([]byte("before\x1b[3"))), Equals, "before")
+	c.Assert(string(a.strip([]byte("1mred\x1b"))), Equals, "red")
+	c.Assert(string(a.strip([]byte("[0mafter"))), Equals, "after")
+}
@@ -1,13 +1,13 @@
 package winrm
 
 import (
+	"context"
 	"crypto/tls"
-	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"strings"
-	"time"
+	"sync/atomic"
 
 	"github.com/satendraraj/winrm/soap"
 )
@@ -16,104 +16,136 @@ import (
 type ClientAuthRequest struct {
 	transport http.RoundTripper
 	dial      func(network, addr string) (net.Conn, error)
+	// dialContext opens the connections instead of dial
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	client      *http.Client
+
+	// renegotiating sends the requests with TLS 1.2 and renegotiation, for the servers
+	// asking for the client certificate after the handshake, which TLS 1.3 can't provide.
+	// It is nil when the endpoint sets the maximum TLS version.
+	renegotiating *http.Client
+	// renegotiate is set once the server asked for the client certificate with renegotiation
+	renegotiate *atomic.Bool
 }
 
 // Transport Transport
 func (c *ClientAuthRequest) Transport(endpoint *Endpoint) error {
-	cert, err := tls.X509KeyPair(endpoint.Cert, endpoint.Key)
+	cert, err := endpoint.clientCertificate()
 	if err != nil {
 		return err
 	}
-
-	dial := (&net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
-	}).Dial
-
-	if c.dial != nil {
-		dial = c.dial
+	if cert == nil {
+		return errNoClientCertificate
 	}
 
+	dialContext := endpoint.dialContext(c.dialContext, c.dial)
+
 	//nolint:gosec
 	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		Proxy: endpoint.proxy(http.ProxyFromEnvironment),
 		TLSClientConfig: &tls.Config{
 			Renegotiation:      tls.RenegotiateOnceAsClient,
 			InsecureSkipVerify: endpoint.Insecure,
-			Certificates:       []tls.Certificate{cert},
-			MaxVersion:         tls.VersionTLS12,
+			ServerName:         endpoint.tlsServerName(),
+			Certificates:       []tls.Certificate{*cert},
 		},
-		Dial:                  dial,
+		DialContext:           dialContext,
 		ResponseHeaderTimeout: endpoint.Timeout,
+		TLSHandshakeTimeout:   endpoint.tlsHandshakeTimeout(),
+		MaxIdleConnsPerHost:   endpoint.MaxIdleConnsPerHost,
+		IdleConnTimeout:       endpoint.idleConnTimeout(),
+		ProxyConnectHeader:    endpoint.ProxyConnectHeader,
+		GetProxyConnectHeader: endpoint.GetProxyConnectHeader,
 	}
 
-	if endpoint.CACert != nil && len(endpoint.CACert) > 0 {
-		certPool, err := readCACerts(endpoint.CACert)
-		if err != nil {
-			return err
-		}
+	if err := endpoint.applyTLSSettings(transport.TLSClientConfig); err != nil {
+		return err
+	}
 
-		transport.TLSClientConfig.RootCAs = certPool
+	certPool, err := endpoint.rootCAs()
+	if err != nil {
+		return err
 	}
+	transport.TLSClientConfig.RootCAs = certPool
 
 	c.transport = transport
+	c.client = &http.Client{Transport: transport, CheckRedirect: noRedirect}
+	c.renegotiating, c.renegotiate = nil, nil
+
+	// TLS 1.3 is tried first, falling back to TLS 1.2 with renegotiation when needed
+	if endpoint.TLSMaxVersion == 0 && transport.TLSClientConfig.MinVersion <= tls.VersionTLS12 {
+		renegotiating := transport.Clone()
+		renegotiating.TLSClientConfig.MaxVersion = tls.VersionTLS12
+		c.renegotiating = &http.Client{Transport: renegotiating, CheckRedirect: noRedirect}
+		c.renegotiate = &atomic.Bool{}
+	}
 
 	return nil
 }
 
-// parse func reads the response body and return it as a string
-func parse(response *http.Response) (string, error) {
-	// if we received the content we expected
-	if strings.Contains(response.Header.Get("Content-Type"), "application/soap+xml") {
-		body, err := io.ReadAll(response.Body)
-		defer func() {
-			// defer can modify the returned value before
-			// it is actually passed to the calling statement
-			if errClose := response.Body.Close(); errClose != nil && err == nil {
-				err = errClose
-			}
-		}()
-		if err != nil {
-			return "", fmt.Errorf("error while reading request body %w", err)
-		}
-
-		return string(body), nil
+// requiresRenegotiation tells if the TLS 1.3 request failed because the server
+// asks for the client certificate after the handshake: Go rejects the certificate
+// request, or the server answers 403 (IIS 403.7, client certificate required)
+func requiresRenegotiation(resp *http.Response, err error) bool {
+	if err != nil {
+		return strings.Contains(err.Error(), "tls: received unexpected handshake message")
 	}
+	return resp.StatusCode == http.StatusForbidden && resp.TLS != nil && resp.TLS.Version == tls.VersionTLS13
+}
 
-	return "", fmt.Errorf("invalid content type")
+// CloseIdleConnections closes the connections kept open for the next requests
+func (c *ClientAuthRequest) CloseIdleConnections() {
+	closeIdleConnections(c.transport)
+	if c.renegotiating != nil {
+		c.renegotiating.CloseIdleConnections()
+	}
 }
 
 // Post Post
-func (c ClientAuthRequest) Post(client *Client, request *soap.SoapMessage) (string, error) {
-	httpClient := &http.Client{Transport: c.transport}
-
-	req, err := http.NewRequest("POST", client.url, strings.NewReader(request.String()))
-	if err != nil {
-		return "", fmt.Errorf("impossible to create http request %w", err)
+func (c ClientAuthRequest) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	httpClient := c.client
+	if httpClient == nil {
+		httpClient = &http.Client{Transport: c.transport, CheckRedirect: noRedirect}
 	}
 
-	req.Header.Set("Content-Type", soapXML+";charset=UTF-8")
-	req.Header.Set("Authorization", "http://schemas.dmtf.org/wbem/wsman/1/wsman/secprofile/https/mutual")
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("unknown error %w", err)
-	}
+	return post(ctx, client, request, func(req *http.Request) (*http.Response, error) {
+		req.Header.Set("Authorization", "http://schemas.dmtf.org/wbem/wsman/1/wsman/secprofile/https/mutual")
+		if c.renegotiate == nil {
+			return httpClient.Do(req)
+		}
+		if c.renegotiate.Load() {
+			return c.renegotiating.Do(req)
+		}
 
-	body, err := parse(resp)
-	if err != nil {
-		return "", fmt.Errorf("http response error: %d - %w", resp.StatusCode, err)
-	}
+		resp, err := httpClient.Do(req)
+		if !requiresRenegotiation(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
 
-	// if we have different 200 http status code
-	// we must replace the error
-	defer func() {
-		if resp.StatusCode != 200 {
-			body, err = "", fmt.Errorf("http error %d: %s", resp.StatusCode, body)
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = c.renegotiating.Do(retry)
+		if err == nil && resp.StatusCode != http.StatusForbidden {
+			c.renegotiate.Store(true)
 		}
-	}()
+		return resp, err
+	})
+}
 
-	return body, err
+// NewClientAuthRequestWithDialContext creates a client certificate transport
+// opening its connections with dialContext
+func NewClientAuthRequestWithDialContext(dialContext func(ctx context.Context, network, addr string) (net.Conn, error)) *ClientAuthRequest {
+	return &ClientAuthRequest{
+		dialContext: dialContext,
+	}
 }
 
 // NewClientAuthRequestWithDial NewClientAuthRequestWithDial
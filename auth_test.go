@@ -0,0 +1,59 @@
+package winrm
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestClientAuthRequestRenegotiationFallback(c *C) {
+	var versions []uint16
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions = append(versions, r.TLS.Version)
+		// the client certificate is only obtained with TLS 1.2 renegotiation
+		if r.TLS.Version == tls.VersionTLS13 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return &ClientAuthRequest{} }).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, true, true, nil, []byte(cert), []byte(key), 0), "", "", params)
+	c.Assert(err, IsNil)
+	for i := 0; i < 2; i++ {
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil)
+	}
+	c.Assert(versions, DeepEquals, []uint16{tls.VersionTLS13, tls.VersionTLS12, tls.VersionTLS12})
+
+	// TLS 1.3 is kept with the servers accepting it
+	versions = nil
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions = append(versions, r.TLS.Version)
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	})
+	client, err = NewClientWithParameters(NewEndpoint(host, port, true, true, nil, []byte(cert), []byte(key), 0), "", "", params)
+	c.Assert(err, IsNil)
+	for i := 0; i < 2; i++ {
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil)
+	}
+	c.Assert(versions, DeepEquals, []uint16{tls.VersionTLS13, tls.VersionTLS13})
+
+	// without fallback when the endpoint pins the version
+	endpoint := NewEndpoint(host, port, true, true, nil, []byte(cert), []byte(key), 0)
+	endpoint.TLSMaxVersion = tls.VersionTLS12
+	transport := &ClientAuthRequest{}
+	c.Assert(transport.Transport(endpoint), IsNil)
+	c.Assert(transport.renegotiating, IsNil)
+}
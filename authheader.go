@@ -0,0 +1,46 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/satendraraj/winrm/soap"
+)
+
+// ClientAuthHeader provides a transport setting the Authorization header of each request
+// to the value of a callback, for endpoints behind a reverse proxy doing its own
+// authentication (e.g. with JWT bearer tokens). The client username and password are not sent.
+type ClientAuthHeader struct {
+	clientRequest
+	// Authorization returns the header value, like "Bearer <token>". It is called
+	// for every request so the value can be refreshed when it expires.
+	Authorization func(ctx context.Context) (string, error)
+}
+
+// NewClientBearer returns a ClientAuthHeader sending the tokens returned by token as bearer tokens
+func NewClientBearer(token func(ctx context.Context) (string, error)) *ClientAuthHeader {
+	return &ClientAuthHeader{
+		Authorization: func(ctx context.Context) (string, error) {
+			t, err := token(ctx)
+			if err != nil {
+				return "", err
+			}
+			return "Bearer " + t, nil
+		},
+	}
+}
+
+// Post make post to the winrm soap service with the Authorization header of the callback
+func (c *ClientAuthHeader) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	httpClient := c.httpClient()
+
+	return post(ctx, client, request, func(req *http.Request) (*http.Response, error) {
+		authorization, err := c.Authorization(req.Context())
+		if err != nil {
+			return nil, fmt.Errorf("getting the authorization header: %w", err)
+		}
+		req.Header.Set("Authorization", authorization)
+		return httpClient.Do(req)
+	})
+}
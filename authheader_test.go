@@ -0,0 +1,41 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestClientBearer(c *C) {
+	var authorizations []string
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	calls := 0
+	transport := NewClientBearer(func(ctx context.Context) (string, error) {
+		calls++
+		if calls == 3 {
+			return "", errors.New("token endpoint unavailable")
+		}
+		return "token" + strconv.Itoa(calls), nil
+	})
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return transport }).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "", "", params)
+	c.Assert(err, IsNil)
+
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, ".*token endpoint unavailable")
+	c.Assert(authorizations, DeepEquals, []string{"Bearer token1", "Bearer token2"})
+}
@@ -0,0 +1,100 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerCooldown         = 30 * time.Second
+)
+
+// CircuitBreaker configures the circuit breaker of a client, which stops sending requests
+// to its endpoint after consecutive failures, failing them right away with ErrCircuitOpen.
+// Once the cooldown elapsed, a single request is let through to probe the endpoint:
+// its success closes the circuit, its failure opens it again for another cooldown.
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit, 5 by default
+	FailureThreshold int
+	// Cooldown is the time the circuit stays open before a request probes the endpoint,
+	// 30 seconds by default
+	Cooldown time.Duration
+	// IsFailure tells if the error of a request counts as a failure of the endpoint,
+	// IsTransient by default, so that SOAP faults and rejected credentials don't open the circuit.
+	// An expired Parameters.RequestTimeout always counts as a failure, while a request
+	// abandoned by its caller, canceled or past the deadline of its context, is ignored.
+	IsFailure func(err error) bool
+}
+
+type circuitBreaker struct {
+	CircuitBreaker
+
+	mutex    sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(config *CircuitBreaker) *circuitBreaker {
+	breaker := &circuitBreaker{CircuitBreaker: *config}
+	if breaker.FailureThreshold <= 0 {
+		breaker.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if breaker.Cooldown <= 0 {
+		breaker.Cooldown = defaultBreakerCooldown
+	}
+	if breaker.IsFailure == nil {
+		breaker.IsFailure = IsTransient
+	}
+	return breaker
+}
+
+// allow returns ErrCircuitOpen when a request can't be sent, and otherwise
+// whether it is the probe of a half open circuit
+func (b *circuitBreaker) allow() (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.failures < b.FailureThreshold {
+		return false, nil
+	}
+	if remaining := b.Cooldown - time.Since(b.openedAt); remaining > 0 || b.probing {
+		if remaining < 0 {
+			remaining = 0
+		}
+		return false, fmt.Errorf("%w after %d consecutive failures, retrying in %s",
+			ErrCircuitOpen, b.failures, remaining.Round(time.Millisecond))
+	}
+	b.probing = true
+	return true, nil
+}
+
+// record updates the circuit with the outcome of a request, probe telling if it was
+// the one probing a half open circuit and abandoned if its caller gave up on it
+func (b *circuitBreaker) record(probe bool, err error, abandoned bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case abandoned, errors.Is(err, context.Canceled):
+		// the request was abandoned, telling nothing about the endpoint
+	case err == nil:
+		b.failures = 0
+	case !errors.Is(err, context.DeadlineExceeded) && !b.IsFailure(err):
+		// only the RequestTimeout can expire when the caller didn't give up
+		b.failures = 0
+	case probe:
+		b.openedAt = time.Now()
+	default:
+		if b.failures++; b.failures == b.FailureThreshold {
+			b.openedAt = time.Now()
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestCircuitBreaker(c *C) {
+	requests, down := 0, true
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	params := NewParametersBuilder().CircuitBreaker(&CircuitBreaker{FailureThreshold: 2, Cooldown: 50 * time.Millisecond}).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 2; i++ {
+		_, err = client.CreateShell()
+		c.Assert(errors.Is(err, ErrCircuitOpen), Equals, false)
+	}
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrCircuitOpen), Equals, true)
+	c.Assert(requests, Equals, 2)
+
+	// the probe fails, opening the circuit for another cooldown
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrCircuitOpen), Equals, false)
+	c.Assert(requests, Equals, 3)
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrCircuitOpen), Equals, true)
+
+	// the probe succeeds, closing the circuit
+	down = false
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(requests, Equals, 5)
+}
+
+func (s *WinRMSuite) TestCircuitBreakerTimeouts(c *C) {
+	release := make(chan struct{})
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a hung server
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	defer close(release)
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	breaker := &CircuitBreaker{FailureThreshold: 2, Cooldown: time.Minute}
+
+	// the deadline of the caller tells nothing about the endpoint
+	client, err := NewClientWithParameters(endpoint, "test", "test", NewParametersBuilder().CircuitBreaker(breaker).Build())
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err = client.CreateShellWithContext(ctx)
+		cancel()
+		c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+	}
+
+	// the expired RequestTimeout of a hung server opens the circuit
+	params := NewParametersBuilder().CircuitBreaker(breaker).RequestTimeout(20 * time.Millisecond).Build()
+	client, err = NewClientWithParameters(endpoint, "test", "test", params)
+	c.Assert(err, IsNil)
+	for i := 0; i < 2; i++ {
+		_, err = client.CreateShell()
+		c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+	}
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrCircuitOpen), Equals, true)
+}
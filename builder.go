@@ -0,0 +1,107 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// CommandBuilder configures a command to run on the remote host step by step,
+// as returned by Client.Command, then runs it with Run
+type CommandBuilder struct {
+	client  *Client
+	command string
+	options ExecuteOptions
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// Command returns a CommandBuilder running command in a new shell, like
+//
+//	result, err := client.Command("ipconfig").WithArgs("/all").WithTimeout(30 * time.Second).Run(ctx)
+func (c *Client) Command(command string) *CommandBuilder {
+	return &CommandBuilder{client: c, command: command}
+}
+
+// WithArgs appends args to the command line as they are, separated by spaces: they aren't quoted,
+// cmd.exe interpreting their spaces, quotes and special characters like &, | or %VAR%.
+// Use Shell.ExecuteWithArgs to pass arbitrary arguments.
+func (b *CommandBuilder) WithArgs(args ...string) *CommandBuilder {
+	b.options.Args = append(b.options.Args, args...)
+	return b
+}
+
+// WithEnv sets environment variables for the command, see ExecuteOptions.Env
+func (b *CommandBuilder) WithEnv(env map[string]string) *CommandBuilder {
+	if b.options.Env == nil {
+		b.options.Env = make(map[string]string, len(env))
+	}
+	for name, value := range env {
+		b.options.Env[name] = value
+	}
+	return b
+}
+
+// WithDir sets the working directory of the command, see ExecuteOptions.Dir
+func (b *CommandBuilder) WithDir(dir string) *CommandBuilder {
+	b.options.Dir = dir
+	return b
+}
+
+// WithTimeout terminates the command if it's still running after timeout,
+// Parameters.CommandTimeout applying otherwise
+func (b *CommandBuilder) WithTimeout(timeout time.Duration) *CommandBuilder {
+	b.options.Timeout = timeout
+	return b
+}
+
+// WithStdin feeds stdin to the command
+func (b *CommandBuilder) WithStdin(stdin io.Reader) *CommandBuilder {
+	b.stdin = stdin
+	return b
+}
+
+// WithOutput writes the command stdout and stderr to these writers as it comes,
+// besides collecting it in the CommandResult
+func (b *CommandBuilder) WithOutput(stdout, stderr io.Writer) *CommandBuilder {
+	b.stdout, b.stderr = stdout, stderr
+	return b
+}
+
+// WithOutputCallback calls callback with each stdout and stderr chunk, see ExecuteOptions.OnOutput
+func (b *CommandBuilder) WithOutputCallback(callback func(stream string, seq int, chunk []byte)) *CommandBuilder {
+	b.options.OnOutput = callback
+	return b
+}
+
+// WithMaxOutput terminates the command when its output goes over limit bytes,
+// see ExecuteOptions.MaxOutput
+func (b *CommandBuilder) WithMaxOutput(limit int) *CommandBuilder {
+	b.options.MaxOutput = limit
+	return b
+}
+
+// Run runs the command in a new shell, or one of the pool, and waits for its termination. The result
+// holds the output received until then even when an error, like a *CommandTimeoutError, is returned.
+// If the context is canceled, the remote command is canceled.
+func (b *CommandBuilder) Run(ctx context.Context) (*CommandResult, error) {
+	options := b.options
+	options.ConsoleModeStdin = true
+
+	var outWriter, errWriter bytes.Buffer
+	stdout, stderr := io.Writer(&outWriter), io.Writer(&errWriter)
+	if b.stdout != nil {
+		stdout = io.MultiWriter(&outWriter, b.stdout)
+	}
+	if b.stderr != nil {
+		stderr = io.MultiWriter(&errWriter, b.stderr)
+	}
+	cmd, err := b.client.runWithContextWithInput(ctx, b.command, &options, stdout, stderr, b.stdin)
+	if cmd == nil {
+		return nil, err
+	}
+
+	return newCommandResult(cmd, &outWriter, &errWriter), withPartialOutput(err, outWriter.String(), errWriter.String())
+}
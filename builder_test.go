@@ -0,0 +1,81 @@
+package winrm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestCommandBuilder(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	var deleted bool
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionDelete):
+			deleted = true
+			return "", nil
+		case strings.Contains(body, ActionCommand):
+			c.Check(body, Contains, `<![CDATA[cd /d ^"C:\^" && set ^"STAGE=deploy^" && ipconfig]]>`)
+			c.Check(body, Contains, "<rsp:Arguments><![CDATA[/all]]></rsp:Arguments>")
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return outputResponse, nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	var chunks int
+	var stdout strings.Builder
+	result, err := client.Command("ipconfig").
+		WithArgs("/all").
+		WithEnv(map[string]string{"STAGE": "deploy"}).
+		WithDir(`C:\`).
+		WithOutput(&stdout, nil).
+		WithOutputCallback(func(stream string, seq int, chunk []byte) { chunks++ }).
+		WithTimeout(100 * time.Millisecond).
+		Run(context.Background())
+	c.Assert(err, FitsTypeOf, &CommandTimeoutError{})
+	c.Assert(result.Stdout, Matches, "(That's all folks!!!)+")
+	c.Assert(result.Stderr, Matches, "(This is stderr, I'm pretty sure!)+")
+	c.Assert(stdout.String(), Equals, result.Stdout)
+	c.Assert(err.(*CommandTimeoutError).Stdout, Equals, result.Stdout)
+	c.Assert(chunks > 0, Equals, true)
+	c.Assert(deleted, Equals, true)
+}
+
+func (s *WinRMSuite) TestCommandBuilderExitError(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	params := NewParametersBuilder().ExitError(true).Build()
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return doneOutputResponse("deploying\r\n", 2), nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	// the builder runs the command like the Run helpers
+	result, err := client.Command("deploy.cmd").Run(context.Background())
+	c.Assert(err, FitsTypeOf, &ExitError{})
+	c.Assert(result.ExitCode, Equals, 2)
+	c.Assert(result.Stdout, Equals, "deploying\r\n")
+}
@@ -0,0 +1,41 @@
+package winrm
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/x509"
+	"encoding/binary"
+
+	// the hashes of the certificate signatures
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// tlsServerEndPoint is the prefix of the application data of the channel bindings (RFC 5929)
+const tlsServerEndPoint = "tls-server-end-point:"
+
+// certificateHash returns the hash of cert for the tls-server-end-point channel binding:
+// the one of its signature algorithm, SHA-256 for MD5 and SHA-1 ones
+func certificateHash(cert *x509.Certificate) []byte {
+	hash := crypto.SHA256
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		hash = crypto.SHA512
+	}
+	h := hash.New()
+	h.Write(cert.Raw)
+	return h.Sum(nil)
+}
+
+// channelBindingsHash returns the MD5 of the gss_channel_bindings_struct (RFC 2744) binding
+// the authentication to the TLS channel whose server certificate is cert, without addresses
+func channelBindingsHash(cert *x509.Certificate) []byte {
+	applicationData := append([]byte(tlsServerEndPoint), certificateHash(cert)...)
+	bindings := make([]byte, 20, 20+len(applicationData))
+	binary.LittleEndian.PutUint32(bindings[16:], uint32(len(applicationData)))
+	bindings = append(bindings, applicationData...)
+	sum := md5.Sum(bindings)
+	return sum[:]
+}
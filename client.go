@@ -8,7 +8,8 @@ import (
 	"fmt"
 	"io"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/satendraraj/winrm/soap"
 )
@@ -18,42 +19,149 @@ type Client struct {
 	Parameters
 	username string
 	password string
+	domain   string
 	useHTTPS bool
 	url      string
 	http     Transporter
+
+	identity    *serverIdentity
+	pool        *runPool
+	quota       *quotaQueue
+	breaker     *circuitBreaker
+	stats       *clientStats
+	compression *requestCompression
 }
 
 // Transporter does different transporters
 // and init a Post request based on them
 type Transporter interface {
-	// init request baset on the transport configurations
+	// init request baset on the transport configurations,
+	// the request must be aborted when the context is canceled
+	Post(context.Context, *Client, *soap.SoapMessage) (string, error)
+	Transport(*Endpoint) error
+}
+
+// Reauthenticator is implemented by the transports keeping authentication state between
+// requests, such as NTLM authenticated connections: Reauthenticate drops that state,
+// so that the next request runs the authentication handshake again.
+type Reauthenticator interface {
+	Reauthenticate()
+}
+
+// LegacyTransporter is the Transporter interface of the previous versions,
+// whose Post didn't take a context. Use AdaptTransporter to keep using such implementations.
+type LegacyTransporter interface {
 	Post(*Client, *soap.SoapMessage) (string, error)
 	Transport(*Endpoint) error
 }
 
+// AdaptTransporter turns a LegacyTransporter into a Transporter.
+// As the legacy Post can't be interrupted, a canceled request returns
+// the context error right away and is left to complete in the background.
+func AdaptTransporter(transporter LegacyTransporter) Transporter {
+	return &legacyTransporter{legacy: transporter}
+}
+
+type legacyTransporter struct {
+	legacy LegacyTransporter
+}
+
+func (t *legacyTransporter) Transport(endpoint *Endpoint) error {
+	return t.legacy.Transport(endpoint)
+}
+
+func (t *legacyTransporter) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	type result struct {
+		response string
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		response, err := t.legacy.Post(client, request)
+		done <- result{response, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.response, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Executor runs commands on a remote host
+type Executor interface {
+	Exec(ctx context.Context, command string, options ...RunOption) (*CommandResult, error)
+}
+
+// ShellCreator opens shells on a remote host
+type ShellCreator interface {
+	CreateShellWithContext(ctx context.Context) (*Shell, error)
+}
+
+// RemoteClient is the subset of Client applications usually depend on,
+// so it can be injected and replaced by a fake in tests
+type RemoteClient interface {
+	Executor
+	ShellCreator
+	IdentifyWithContext(ctx context.Context) (*ServerInfo, error)
+}
+
+var _ RemoteClient = (*Client)(nil)
+
+// NewRemoteClient is NewClientWithParameters returning the RemoteClient interface,
+// the concrete value being a *Client
+func NewRemoteClient(endpoint *Endpoint, user, password string, params *Parameters) (RemoteClient, error) {
+	client, err := NewClientWithParameters(endpoint, user, password, params)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
 // NewClient will create a new remote client on url, connecting with user and password
 // This function doesn't connect (connection happens only when CreateShell is called)
 func NewClient(endpoint *Endpoint, user, password string) (*Client, error) {
 	return NewClientWithParameters(endpoint, user, password, DefaultParameters)
 }
 
+// NewClientWithDomain creates a client for an account of domain, which each transport
+// combines with user in the form it expects, overriding the Domain of endpoint
+func NewClientWithDomain(endpoint *Endpoint, domain, user, password string, params *Parameters) (*Client, error) {
+	if params == nil {
+		params = DefaultParameters
+	}
+	client, err := NewClientWithParameters(endpoint, user, password, params)
+	if err != nil {
+		return nil, err
+	}
+	client.domain = domain
+
+	return client, nil
+}
+
 // NewClientWithParameters will create a new remote client on url, connecting with user and password
 // This function doesn't connect (connection happens only when CreateShell is called)
+// The client keeps its own copy of params, which can then be shared or modified freely.
 func NewClientWithParameters(endpoint *Endpoint, user, password string, params *Parameters) (*Client, error) {
 	// alloc a new client
 	client := &Client{
-		Parameters: *params,
+		Parameters: *params.clone(),
 		username:   user,
 		password:   password,
+		domain:     endpoint.Domain,
 		url:        endpoint.url(),
 		useHTTPS:   endpoint.HTTPS,
 		// default transport
-		http: &clientRequest{dial: params.Dial},
+		http:        &clientRequest{dial: params.Dial, dialContext: params.DialContext},
+		identity:    &serverIdentity{},
+		stats:       &clientStats{},
+		compression: &requestCompression{},
 	}
 
 	// switch to other transport if provided
-	if params.TransportDecorator != nil {
-		client.http = params.TransportDecorator()
+	if client.TransportDecorator != nil {
+		client.http = client.TransportDecorator()
 	}
 
 	// set the transport to some endpoint configuration
@@ -61,6 +169,18 @@ func NewClientWithParameters(endpoint *Endpoint, user, password string, params *
 		return nil, fmt.Errorf("can't parse this key and certs: %w", err)
 	}
 
+	if client.QuotaQueue != nil {
+		client.quota = newQuotaQueue(client.QuotaQueue)
+	}
+
+	if client.CircuitBreaker != nil {
+		client.breaker = newCircuitBreaker(client.CircuitBreaker)
+	}
+
+	if client.ShellPoolSize > 0 {
+		client.pool = newRunPool(client, client.ShellPoolSize, client.ShellPoolIdleTimeout)
+	}
+
 	return client, nil
 }
 
@@ -76,11 +196,25 @@ func readCACerts(certs []byte) (*x509.CertPool, error) {
 
 // CreateShell will create a WinRM Shell,
 // which is the prealable for running commands.
+//
+// Deprecated: use CreateShellWithContext()
 func (c *Client) CreateShell() (*Shell, error) {
-	request := NewOpenShellRequest(c.url, &c.Parameters)
+	return c.CreateShellWithContext(context.Background())
+}
+
+// CreateShellWithContext will create a WinRM Shell,
+// which is the prealable for running commands.
+func (c *Client) CreateShellWithContext(ctx context.Context) (*Shell, error) {
+	return c.CreateShellWithOptions(ctx, ShellOptions{})
+}
+
+// CreateShellWithOptions creates a WinRM Shell with the settings of options
+func (c *Client) CreateShellWithOptions(ctx context.Context, options ShellOptions) (*Shell, error) {
+	request := NewOpenShellRequestWithOptions(c.url, c.requestParameters(), &options)
 	defer request.Free()
 
-	response, err := c.sendRequest(request)
+	start := time.Now()
+	response, err := c.sendRequestWithContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +224,36 @@ func (c *Client) CreateShell() (*Shell, error) {
 		return nil, err
 	}
 
-	return c.NewShell(shellID), nil
+	shell := c.NewShell(shellID)
+	shell.creation = time.Since(start)
+	if c.stats != nil {
+		atomic.AddInt64(&c.stats.openShells, 1)
+		shell.counted = 1
+	}
+
+	return shell, nil
+}
+
+// WithParams returns a view of the client sending its requests with the Timeout,
+// Locale and EnvelopeSize of params, the empty ones keeping the client values.
+// The derived client shares the transport and credentials, the quota queue, the circuit breaker,
+// the statistics and the server information, so it is cheap to create for a single operation:
+// client.WithParams(p).RunWithContext(...)
+// It doesn't use the shell pool of the client, whose shells were created with its parameters,
+// so its Run helpers create a shell per command, and its Close leaves the pool alone.
+func (c *Client) WithParams(params *Parameters) *Client {
+	derived := *c
+	derived.pool = nil
+	if params.Timeout != "" {
+		derived.Timeout = params.Timeout
+	}
+	if params.Locale != "" {
+		derived.Locale = params.Locale
+	}
+	if params.EnvelopeSize != 0 {
+		derived.EnvelopeSize = params.EnvelopeSize
+	}
+	return &derived
 }
 
 // NewShell will create a new WinRM Shell for the given shellID
@@ -98,9 +261,133 @@ func (c *Client) NewShell(id string) *Shell {
 	return &Shell{client: c, id: id}
 }
 
+// ConnectShell connects to the shell id another client disconnected from with Shell.Disconnect,
+// the output its commands buffered meanwhile being then received with Shell.AttachCommand.
+// Like Disconnect, it only supports the PowerShell (PSRP) shells, ErrDisconnectUnsupported
+// being returned otherwise, and needs WinRM 3.0.
+func (c *Client) ConnectShell(ctx context.Context, id string) (*Shell, error) {
+	if err := c.requireDisconnect(ctx); err != nil {
+		return nil, err
+	}
+
+	request := NewConnectRequest(c.url, id, c.requestParameters())
+	defer request.Free()
+
+	if _, err := c.sendRequestWithContext(ctx, request); err != nil {
+		return nil, err
+	}
+
+	return c.NewShell(id), nil
+}
+
+// AttachShell returns the existing shell id, found by Shell.ID, after checking it's still open
+// on the server, so that a restarted process can go on with the commands it started
+func (c *Client) AttachShell(ctx context.Context, id string) (*Shell, error) {
+	shell := c.NewShell(id)
+	if err := shell.ping(ctx); err != nil {
+		return nil, fmt.Errorf("attaching shell %s: %w", id, err)
+	}
+	return shell, nil
+}
+
 // sendRequest exec the custom http func from the client
 func (c *Client) sendRequest(request *soap.SoapMessage) (string, error) {
-	return c.http.Post(c, request)
+	return c.sendRequestWithContext(context.Background(), request)
+}
+
+// sendRequestWithContext exec the custom http func from the client,
+// aborting it when ctx is canceled
+func (c *Client) sendRequestWithContext(ctx context.Context, request *soap.SoapMessage) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if c.stats != nil {
+		atomic.AddInt64(&c.stats.operations, 1)
+	}
+
+	if c.RetryPolicy == nil {
+		return c.sendRequestWithQuota(ctx, request)
+	}
+	return c.RetryPolicy.do(ctx, func() (string, error) {
+		return c.sendRequestWithQuota(ctx, request)
+	})
+}
+
+// sendRequestWithQuota posts request, waiting for the quotas when the client has a QuotaQueue
+func (c *Client) sendRequestWithQuota(ctx context.Context, request *soap.SoapMessage) (string, error) {
+	response, err := c.sendRequestOnce(ctx, request)
+	if err == nil || !isQuotaFault(err) {
+		return response, err
+	}
+	if c.quota == nil {
+		return "", fmt.Errorf("%w: %w", ErrQuotaExceeded, err)
+	}
+
+	return c.quota.wait(ctx, err, func() (string, error) {
+		return c.sendRequestOnce(ctx, request)
+	})
+}
+
+// post sends request with the transport, through the middlewares of the client
+func (c *Client) post(ctx context.Context, request *soap.SoapMessage) (string, error) {
+	next := PostFunc(func(ctx context.Context, request *soap.SoapMessage) (string, error) {
+		return c.http.Post(ctx, c, request)
+	})
+	for i := len(c.Middlewares) - 1; i >= 0; i-- {
+		next = c.Middlewares[i](next)
+	}
+
+	if c.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+	}
+
+	if c.stats != nil {
+		start := time.Now()
+		defer func() {
+			atomic.AddInt64(&c.stats.requests, 1)
+			atomic.AddInt64(&c.stats.roundTrips, int64(time.Since(start)))
+		}()
+	}
+
+	return next(ctx, request)
+}
+
+// sendRequestOnce posts request, keeping track of the last error
+func (c *Client) sendRequestOnce(ctx context.Context, request *soap.SoapMessage) (string, error) {
+	var probe bool
+	if c.breaker != nil {
+		var err error
+		if probe, err = c.breaker.allow(); err != nil {
+			return "", err
+		}
+	}
+
+	if c.RateLimiter != nil {
+		if err := c.RateLimiter.Wait(ctx); err != nil {
+			if c.breaker != nil {
+				c.breaker.record(probe, err, true)
+			}
+			return "", err
+		}
+	}
+
+	response, err := c.post(ctx, request)
+	if err != nil && c.reauthenticate(ctx, err) {
+		response, err = c.post(ctx, request)
+	}
+	if c.breaker != nil {
+		c.breaker.record(probe, err, ctx.Err() != nil)
+	}
+	if c.stats != nil {
+		if err != nil {
+			c.stats.setLastError(err)
+		} else {
+			atomic.StoreInt32(&c.stats.authenticated, 1)
+		}
+	}
+	return response, err
 }
 
 // Run will run command on the the remote host, writing the process stdout and stderr to
@@ -132,7 +419,17 @@ func (c *Client) RunWithString(command string, stdin string) (string, string, in
 func (c *Client) RunWithContextWithString(ctx context.Context, command string, stdin string) (string, string, int, error) {
 	var outWriter, errWriter bytes.Buffer
 	exitCode, err := c.RunWithContextWithInput(ctx, command, &outWriter, &errWriter, strings.NewReader(stdin))
-	return outWriter.String(), errWriter.String(), exitCode, err
+	return outWriter.String(), errWriter.String(), exitCode, withPartialOutput(err, outWriter.String(), errWriter.String())
+}
+
+// RunWithTimeout runs command on the remote host like RunCmdWithContext, terminating it if
+// it's still running after timeout. The error is then a *CommandTimeoutError holding the output
+// received until then, which is returned too.
+func (c *Client) RunWithTimeout(ctx context.Context, command string, timeout time.Duration) (string, string, int, error) {
+	ctx, cancel := withCommandTimeout(ctx, timeout)
+	defer cancel()
+
+	return c.RunCmdWithContext(ctx, command)
 }
 
 // RunCmdWithContext will run command on the the remote host, returning the process stdout and stderr
@@ -141,7 +438,101 @@ func (c *Client) RunWithContextWithString(ctx context.Context, command string, s
 func (c *Client) RunCmdWithContext(ctx context.Context, command string) (string, string, int, error) {
 	var outWriter, errWriter bytes.Buffer
 	exitCode, err := c.RunWithContextWithInput(ctx, command, &outWriter, &errWriter, nil)
-	return outWriter.String(), errWriter.String(), exitCode, err
+	return outWriter.String(), errWriter.String(), exitCode, withPartialOutput(err, outWriter.String(), errWriter.String())
+}
+
+// RunWithCallbacks runs command on the remote host, calling callback with each decoded stdout
+// and stderr chunk as it is received, stream being "stdout" or "stderr" and seq counting the
+// chunks from 0. The calls are made in sequence, in the order the server sent the chunks, so
+// a UI can stream the output live, see ExecuteOptions.OnOutput.
+// If the context is canceled, the remote command is canceled.
+func (c *Client) RunWithCallbacks(ctx context.Context, command string, callback func(stream string, seq int, chunk []byte)) (int, error) {
+	// the output goes to the callback, the pipes only need to be drained
+	cmd, err := c.runWithContextWithInput(ctx, command, &ExecuteOptions{
+		ConsoleModeStdin: true,
+		OnOutput:         callback,
+	}, io.Discard, io.Discard, nil)
+	if cmd == nil {
+		return 1, err
+	}
+
+	return cmd.ExitCode(), err
+}
+
+// TimedChunk is an output chunk with the time it was received at
+type TimedChunk struct {
+	OutputChunk
+	Time time.Time
+}
+
+// CombinedOutput is the stdout and stderr of a command merged in a single stream,
+// keeping the order the server sent their chunks in
+type CombinedOutput []TimedChunk
+
+// String returns the merged output text
+func (o CombinedOutput) String() string {
+	var text strings.Builder
+	for _, chunk := range o {
+		text.Write(chunk.Data)
+	}
+	return text.String()
+}
+
+// RunCombinedWithContext runs command on the remote host, returning its stdout and stderr merged
+// in a single stream with the arrival time of each chunk, for logs where the interleaving
+// matters more than the separation of the streams.
+// If the context is canceled, the remote command is canceled.
+func (c *Client) RunCombinedWithContext(ctx context.Context, command string) (CombinedOutput, int, error) {
+	var output CombinedOutput
+	exitCode, err := c.RunWithCallbacks(ctx, command, func(stream string, seq int, chunk []byte) {
+		output = append(output, TimedChunk{
+			OutputChunk: OutputChunk{Stream: stream, Data: chunk},
+			Time:        time.Now(),
+		})
+	})
+	return output, exitCode, err
+}
+
+// CommandResult holds the outcome of a command run on the remote host
+type CommandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// number of bytes dropped from each stream because of Parameters.OutputLimit
+	StdoutDropped int64
+	StderrDropped int64
+	Timing        CommandTiming
+}
+
+// Truncated reports whether some of the command output was dropped
+func (r *CommandResult) Truncated() bool {
+	return r.StdoutDropped > 0 || r.StderrDropped > 0
+}
+
+// RunWithContextWithResult will run command on the the remote host, returning the process output,
+// exit code and the number of bytes dropped because of Parameters.OutputLimit.
+// If the context is canceled, the remote command is canceled.
+func (c *Client) RunWithContextWithResult(ctx context.Context, command string) (*CommandResult, error) {
+	var outWriter, errWriter bytes.Buffer
+	cmd, err := c.runWithContextWithInput(ctx, command, nil, &outWriter, &errWriter, nil)
+	if cmd == nil {
+		return nil, err
+	}
+
+	return newCommandResult(cmd, &outWriter, &errWriter), err
+}
+
+// newCommandResult builds the CommandResult of a finished command whose output was collected in stdout and stderr
+func newCommandResult(cmd *Command, stdout, stderr *bytes.Buffer) *CommandResult {
+	result := &CommandResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: cmd.ExitCode(),
+	}
+	result.StdoutDropped, result.StderrDropped = cmd.DroppedBytes()
+	result.Timing = cmd.Timing()
+
+	return result
 }
 
 // RunPSWithString will basically wrap your code to execute commands in powershell.exe. Default RunWithString
@@ -159,7 +550,7 @@ func (c *Client) RunPSWithContextWithString(ctx context.Context, command string,
 
 	// Let's check if we actually created a command
 	if command == "" {
-		return "", "", 1, errors.New("cannot encode the given command")
+		return "", "", 1, ErrCommandEncoding
 	}
 
 	// Specify powershell.exe to run encoded command
@@ -173,12 +564,10 @@ func (c *Client) RunPSWithContext(ctx context.Context, command string) (string,
 
 	// Let's check if we actually created a command
 	if command == "" {
-		return "", "", 1, errors.New("cannot encode the given command")
+		return "", "", 1, ErrCommandEncoding
 	}
 
-	var outWriter, errWriter bytes.Buffer
-	exitCode, err := c.RunWithContextWithInput(ctx, command, &outWriter, &errWriter, nil)
-	return outWriter.String(), errWriter.String(), exitCode, err
+	return c.RunCmdWithContext(ctx, command)
 }
 
 // RunWithInput will run command on the the remote host, writing the process stdout and stderr to
@@ -201,43 +590,83 @@ func (c *Client) RunWithInput(command string, stdout, stderr io.Writer, stdin io
 // performance reasons to buffer it.
 // If stdin is nil, this is equivalent to c.RunWithContext()
 func (c *Client) RunWithContextWithInput(ctx context.Context, command string, stdout, stderr io.Writer, stdin io.Reader) (int, error) {
-	shell, err := c.CreateShell()
-	if err != nil {
+	cmd, err := c.runWithContextWithInput(ctx, command, nil, stdout, stderr, stdin)
+	if cmd == nil {
 		return 1, err
 	}
-	defer shell.Close()
-	cmd, err := shell.ExecuteWithContext(ctx, command)
+
+	return cmd.ExitCode(), err
+}
+
+// runWithContextWithInput runs command in a new shell and waits for its termination,
+// returning the finished Command, or nil if it couldn't be started.
+// options is nil for the commands run without ExecuteWithOptions.
+func (c *Client) runWithContextWithInput(ctx context.Context, command string, options *ExecuteOptions, stdout, stderr io.Writer, stdin io.Reader) (*Command, error) {
+	if !c.ExitError {
+		return c.runCommand(ctx, command, options, stdout, stderr, stdin)
+	}
+
+	stderrTail := &tailWriter{w: stderr, size: exitErrorStderrSize}
+	cmd, err := c.runCommand(ctx, command, options, stdout, stderrTail, stdin)
+	if err == nil && cmd != nil && cmd.ExitCode() != 0 {
+		err = &ExitError{Command: command, ExitCode: cmd.ExitCode(), Stderr: string(stderrTail.tail)}
+	}
+	return cmd, err
+}
+
+// runCommand runs command in a shell of the pool, or in a new one
+func (c *Client) runCommand(ctx context.Context, command string, options *ExecuteOptions, stdout, stderr io.Writer, stdin io.Reader) (*Command, error) {
+	if c.pool != nil {
+		return c.runInPool(ctx, command, options, stdout, stderr, stdin)
+	}
+
+	shell, err := c.CreateShellWithContext(ctx)
 	if err != nil {
-		return 1, err
+		return nil, err
 	}
+	defer shell.Close()
 
-	var wg sync.WaitGroup
-	wg.Add(3)
+	return shell.run(ctx, command, options, stdout, stderr, stdin)
+}
 
-	go func() {
-		defer func() {
-			wg.Done()
-		}()
-		if stdin == nil {
-			return
-		}
-		defer func() {
-			cmd.Stdin.Close()
-		}()
-		_, _ = io.Copy(cmd.Stdin, stdin)
-	}()
-	go func() {
-		defer wg.Done()
-		_, _ = io.Copy(stdout, cmd.Stdout)
-	}()
-	go func() {
-		defer wg.Done()
-		_, _ = io.Copy(stderr, cmd.Stderr)
-	}()
+// tailWriter writes to w, keeping the last size bytes written
+type tailWriter struct {
+	w    io.Writer
+	size int
+	tail []byte
+}
 
-	cmd.Wait()
-	wg.Wait()
-	cmd.Close()
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.tail = append(t.tail, p...)
+	if len(t.tail) > t.size {
+		t.tail = append(t.tail[:0], t.tail[len(t.tail)-t.size:]...)
+	}
+	return t.w.Write(p)
+}
+
+// runInPool is runWithContextWithInput using a shell of the pool,
+// which is only given back if the command ran without error.
+// A pooled shell the server deleted meanwhile (reboot, idle timeout) is replaced
+// by a new one, the command being sent once more since it wasn't started.
+func (c *Client) runInPool(ctx context.Context, command string, options *ExecuteOptions, stdout, stderr io.Writer, stdin io.Reader) (*Command, error) {
+	shell, err := c.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := shell.run(ctx, command, options, stdout, stderr, stdin)
+	if cmd == nil && isShellNotFound(err) {
+		shell.forget()
+		if shell, err = c.CreateShellWithContext(ctx); err != nil {
+			return nil, err
+		}
+		cmd, err = shell.run(ctx, command, options, stdout, stderr, stdin)
+	}
+	if err != nil {
+		_ = shell.Close()
+		return cmd, err
+	}
+	c.pool.put(shell)
 
-	return cmd.ExitCode(), cmd.err
+	return cmd, nil
 }
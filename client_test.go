@@ -2,11 +2,14 @@ package winrm
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
-	"github.com/masterzen/winrm/soap"
+	"github.com/satendraraj/winrm/soap"
 
 	"net"
 	"time"
@@ -20,7 +23,7 @@ type Requester struct {
 	dial      func(network, addr string) (net.Conn, error)
 }
 
-func (r *Requester) Post(client *Client, request *soap.SoapMessage) (string, error) {
+func (r *Requester) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
 	return r.http(client, request)
 }
 
@@ -70,6 +73,7 @@ func (s *WinRMSuite) TestClientCreateShell(c *C) {
 
 	shell, _ := client.CreateShell()
 	c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Assert(shell.ID(), Equals, "67A74734-DD32-4F10-89DE-49A060483810")
 }
 
 func (s *WinRMSuite) TestRun(c *C) {
@@ -246,6 +250,7 @@ func (s *WinRMSuite) TestReplaceDial(c *C) {
 	}).Dial
 
 	params := NewParameters("PT60S", "en-US", 153600)
+	params.AllowInsecureBasic = true
 	usedCustomDial := false
 	params.Dial = func(network, addr string) (net.Conn, error) {
 		usedCustomDial = true
@@ -260,3 +265,328 @@ func (s *WinRMSuite) TestReplaceDial(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(usedCustomDial, Equals, true)
 }
+
+func (s *WinRMSuite) TestReplaceDialContext(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "this is the input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	type key struct{}
+	var dialed []interface{}
+	params := NewParametersBuilder().DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, ctx.Value(key{}))
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}).Build()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShellWithContext(context.WithValue(context.Background(), key{}, "create"))
+	c.Assert(err, IsNil)
+	c.Assert(dialed, DeepEquals, []interface{}{"create"})
+
+	// a canceled context aborts the dial
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	transport := NewClientWithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	c.Assert(transport.Transport(endpoint), IsNil)
+	client.http = transport
+	_, err = client.post(ctx, NewOpenShellRequest(client.url, &client.Parameters))
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+}
+
+func (s *WinRMSuite) TestRunWithContextWithResult(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "no input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	params := NewParameters("PT60S", "en-US", 153600)
+	params.AllowInsecureBasic = true
+	params.OutputLimit = 6
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	result, err := client.RunWithContextWithResult(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	c.Assert(result.ExitCode, Equals, 123)
+	c.Assert(result.Stdout, Equals, "That's\n[output truncated: 13 bytes dropped]\n")
+	c.Assert(result.StdoutDropped, Equals, int64(13))
+	c.Assert(result.StderrDropped, Equals, int64(26))
+	c.Assert(result.Truncated(), Equals, true)
+	c.Assert(result.Timing.ShellCreation > 0, Equals, true)
+	c.Assert(result.Timing.FirstOutput > 0, Equals, true)
+	c.Assert(result.Timing.Duration >= result.Timing.FirstOutput, Equals, true)
+	c.Assert(result.Timing.Receives, Equals, 2)
+}
+
+func (s *WinRMSuite) TestRunWithContextWithResultPooledShellCreation(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "no input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	params := NewParametersBuilder().ShellPool(1, 0).AllowInsecureBasic(true).Build()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+	defer client.Close()
+
+	// the reused shell was created for the first command only
+	result, err := client.RunWithContextWithResult(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	c.Assert(result.Timing.ShellCreation > 0, Equals, true)
+	result, err = client.RunWithContextWithResult(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	c.Assert(result.Timing.ShellCreation, Equals, time.Duration(0))
+}
+
+func (s *WinRMSuite) TestRunWithCallbacks(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "no input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	var chunks []string
+	code, err := client.RunWithCallbacks(context.Background(), "ipconfig /all", func(stream string, seq int, chunk []byte) {
+		chunks = append(chunks, fmt.Sprintf("%d %s: %s", seq, stream, chunk))
+	})
+	c.Assert(err, IsNil)
+	c.Assert(code, Equals, 123)
+	c.Assert(chunks, DeepEquals, []string{
+		"0 stdout: That's all folks!!!",
+		"1 stderr: This is stderr, I'm pretty sure!",
+	})
+}
+
+func (s *WinRMSuite) TestRunWithCallbacksDecodedOutput(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, "transfer/Create"):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return doneOutputResponse("\x1b[32mok\x1b[0m\r\nfailed\r\n", 1), nil
+		}
+		return "", nil
+	}
+
+	// the chunks are decoded like the output, ExitError applying
+	params := NewParametersBuilder().StripANSI(true).NormalizeNewlines(true).ExitError(true).Build()
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+	client.http = &r
+	var chunks []string
+	code, err := client.RunWithCallbacks(context.Background(), "deploy.cmd", func(stream string, seq int, chunk []byte) {
+		chunks = append(chunks, fmt.Sprintf("%d %s: %s", seq, stream, chunk))
+	})
+	c.Assert(err, FitsTypeOf, &ExitError{})
+	c.Assert(code, Equals, 1)
+	c.Assert(chunks, DeepEquals, []string{"0 stdout: ok\nfailed\n"})
+
+	// and stop at MaxOutput
+	params = NewParametersBuilder().StripANSI(true).NormalizeNewlines(true).MaxOutput(4).Build()
+	client, err = NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+	client.http = &r
+	chunks = nil
+	_, err = client.RunWithCallbacks(context.Background(), "deploy.cmd", func(stream string, seq int, chunk []byte) {
+		chunks = append(chunks, fmt.Sprintf("%d %s: %s", seq, stream, chunk))
+	})
+	c.Assert(errors.Is(err, ErrOutputLimitExceeded), Equals, true)
+	c.Assert(chunks, DeepEquals, []string{"0 stdout: ok\nf"})
+}
+
+func (s *WinRMSuite) TestRunCombinedWithContext(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "no input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	start := time.Now()
+	output, code, err := client.RunCombinedWithContext(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	c.Assert(code, Equals, 123)
+	c.Assert(output.String(), Equals, "That's all folks!!!This is stderr, I'm pretty sure!")
+	c.Assert(output, HasLen, 2)
+	c.Assert(output[0].Stream, Equals, "stdout")
+	c.Assert(output[1].Stream, Equals, "stderr")
+	c.Assert(output[0].Time.Before(start), Equals, false)
+	c.Assert(output[1].Time.Before(output[0].Time), Equals, false)
+}
+
+func (s *WinRMSuite) TestRunExitError(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "no input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	params := NewParametersBuilder().ExitError(true).Build()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	stdout, _, code, err := client.RunCmdWithContext(context.Background(), "ipconfig /all")
+	c.Assert(stdout, Equals, "That's all folks!!!")
+	c.Assert(code, Equals, 123)
+	var exitErr *ExitError
+	c.Assert(errors.As(err, &exitErr), Equals, true)
+	c.Assert(exitErr.Command, Equals, "ipconfig /all")
+	c.Assert(exitErr.ExitCode, Equals, 123)
+	c.Assert(exitErr.Stderr, Equals, "This is stderr, I'm pretty sure!")
+	c.Assert(err, ErrorMatches, `command "ipconfig /all" exited with code 123: This is stderr, I'm pretty sure!`)
+}
+
+func (s *WinRMSuite) TestTailWriter(c *C) {
+	var output bytes.Buffer
+	tail := &tailWriter{w: &output, size: 4}
+	_, _ = tail.Write([]byte("abc"))
+	_, _ = tail.Write([]byte("defg"))
+	c.Assert(output.String(), Equals, "abcdefg")
+	c.Assert(string(tail.tail), Equals, "defg")
+}
+
+func (s *WinRMSuite) TestNewRemoteClient(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "no input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	remote, err := NewRemoteClient(endpoint, "Administrator", "v3r1S3cre7", DefaultParameters)
+	c.Assert(err, IsNil)
+	c.Assert(remote, FitsTypeOf, &Client{})
+
+	var executor Executor = remote
+	result, err := executor.Exec(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	c.Assert(result.ExitCode, Equals, 123)
+	c.Assert(result.Stdout, Equals, "That's all folks!!!")
+
+	_, err = NewRemoteClient(NewEndpoint(host, port, false, false, nil, []byte("bad"), []byte("bad"), 0), "a", "b",
+		&Parameters{TransportDecorator: func() Transporter { return &ClientAuthRequest{} }})
+	c.Assert(err, NotNil)
+}
+
+type legacyRequester struct {
+	Requester
+	release chan struct{}
+}
+
+func (r *legacyRequester) Post(client *Client, request *soap.SoapMessage) (string, error) {
+	<-r.release
+	return createShellResponse, nil
+}
+
+func (s *WinRMSuite) TestAdaptTransporter(c *C) {
+	legacy := &legacyRequester{release: make(chan struct{})}
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return AdaptTransporter(legacy) }).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.CreateShellWithContext(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	close(legacy.release)
+	shell, err := client.CreateShellWithContext(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+}
+
+func (s *WinRMSuite) TestClientWithParams(c *C) {
+	client, err := NewClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b")
+	c.Assert(err, IsNil)
+
+	var requests []string
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		requests = append(requests, message.String())
+		return createShellResponse, nil
+	}
+	client.http = &r
+
+	derived := client.WithParams(&Parameters{Timeout: "PT600S"})
+	_, err = derived.CreateShell()
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	c.Assert(requests, HasLen, 2)
+	c.Assert(requests[0], Contains, ">PT600S<")
+	c.Assert(requests[0], Contains, `xml:lang="en-US"`)
+	c.Assert(requests[1], Contains, ">PT60S<")
+	c.Assert(derived.EnvelopeSize, Equals, client.EnvelopeSize)
+}
+
+func (s *WinRMSuite) TestClientWithParamsSkipsShellPool(c *C) {
+	var created, deleted int
+	params := NewParametersBuilder().ShellPool(2, 0).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+	r := poolRequester(&created, &deleted)
+	run := r.http
+	var timeouts []string
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), ActionCommand) {
+			timeouts = append(timeouts, client.Timeout)
+		}
+		return run(client, message)
+	}
+	client.http = r
+
+	_, _, _, err = client.RunCmdWithContext(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+	derived := client.WithParams(&Parameters{Timeout: "PT600S"})
+	_, _, _, err = derived.RunCmdWithContext(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+	c.Assert(timeouts, DeepEquals, []string{"PT60S", "PT600S"})
+	c.Assert(created, Equals, 2)
+	c.Assert(deleted, Equals, 1)
+
+	// the pool of the client isn't closed by the derived client
+	c.Assert(derived.Close(), IsNil)
+	c.Assert(client.ShellPoolStats().Idle, Equals, 1)
+}
+
+func (s *WinRMSuite) TestMiddlewares(c *C) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next PostFunc) PostFunc {
+			return func(ctx context.Context, request *soap.SoapMessage) (string, error) {
+				calls = append(calls, name+" before")
+				response, err := next(ctx, request)
+				calls = append(calls, name+" after")
+				return response, err
+			}
+		}
+	}
+	builder := NewParametersBuilder().Middlewares(trace("outer"), trace("inner"))
+	params := builder.Build()
+	other := builder.Middlewares(trace("extra")).Build()
+	c.Assert(params.Middlewares, HasLen, 2)
+	c.Assert(other.Middlewares, HasLen, 3)
+
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		calls = append(calls, "transport")
+		return createShellResponse, nil
+	}
+	client.http = &r
+
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(calls, DeepEquals, []string{"outer before", "inner before", "transport", "inner after", "outer after"})
+}
@@ -1,13 +1,16 @@
 package winrm
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type commandWriter struct {
@@ -21,8 +24,19 @@ type commandReader struct {
 	write  *io.PipeWriter
 	read   *io.PipeReader
 	stream string
+	// received counts the decoded bytes, written the ones kept in the pipe
+	received int64
+	written  int64
+	dropped  int64
+	ansi     *ansiStripper
+	// transcoder decodes the output from Parameters.OutputEncoding
+	transcoder *transcoder
+	newlines   *newlineNormalizer
 }
 
+// truncationMarker is appended to a stream whose output went over Parameters.OutputLimit
+const truncationMarker = "\n[output truncated: %d bytes dropped]\n"
+
 // Command represents a given command running on a Shell. This structure allows to get access
 // to the various stdout, stderr and stdin pipes.
 type Command struct {
@@ -38,17 +52,75 @@ type Command struct {
 
 	done   chan struct{}
 	cancel chan struct{}
+	// onOutput is ExecuteOptions.OnOutput, outputSeq the sequence number of its next chunk
+	onOutput  func(stream string, seq int, chunk []byte)
+	outputSeq int
+	// maxOutput is ExecuteOptions.MaxOutput or Parameters.MaxOutput, exceeded tells it was reached
+	maxOutput int64
+	exceeded  bool
+	// raw tells the output is passed as is, without the decoding and limits of the Parameters
+	raw bool
+
+	// timing of the command, see CommandTiming; but started and shellCreation,
+	// they are guarded by stateMutex
+	started       time.Time
+	shellCreation time.Duration
+	firstOutput   time.Time
+	finished      time.Time
+	receives      int
+
+	// commandLine is the command line sent, to find the process of the command
+	commandLine string
+	stateMutex  sync.Mutex
+	states      []CommandStateChange
+}
+
+// CommandStateChange is a change of the state of a command reported by the server
+type CommandStateChange struct {
+	// State is CommandStatePending, CommandStateRunning or CommandStateDone
+	State string
+	// Time is when the client received the new state
+	Time time.Time
+}
+
+// CommandTiming holds the timing metrics of a command, to profile slow hosts
+type CommandTiming struct {
+	// ShellCreation is the time taken to create the shell of the command, zero when it wasn't
+	// created by this client or when the shell already ran a command, like the shells of a pool
+	ShellCreation time.Duration
+	// FirstOutput is the time from the command start to its first output,
+	// zero when it had none
+	FirstOutput time.Duration
+	// Duration is the time from the command start to its termination
+	Duration time.Duration
+	// Receives is the number of output requests sent
+	Receives int
 }
 
-func newCommand(ctx context.Context, shell *Shell, ids string) *Command {
+// newCommand returns the Command ids running on shell and starts receiving its output,
+// options being nil for the commands run without ExecuteWithOptions
+func newCommand(ctx context.Context, shell *Shell, ids string, options *ExecuteOptions) *Command {
 	command := &Command{
-		shell:    shell,
-		client:   shell.client,
-		id:       ids,
-		exitCode: 0,
-		err:      nil,
-		done:     make(chan struct{}),
-		cancel:   make(chan struct{}),
+		shell:     shell,
+		client:    shell.client,
+		id:        ids,
+		exitCode:  0,
+		err:       nil,
+		done:      make(chan struct{}),
+		cancel:    make(chan struct{}),
+		maxOutput: int64(shell.client.Parameters.MaxOutput),
+		started:   time.Now(),
+		// only the first command of the shell waited for its creation
+		shellCreation: shell.takeCreation(),
+	}
+	if options != nil {
+		command.onOutput = options.OnOutput
+		if options.MaxOutput > 0 {
+			command.maxOutput = int64(options.MaxOutput)
+		}
+		if options.raw {
+			command.raw, command.maxOutput = true, 0
+		}
 	}
 
 	command.Stdout = newCommandReader("stdout", command)
@@ -58,50 +130,131 @@ func newCommand(ctx context.Context, shell *Shell, ids string) *Command {
 	}
 	command.Stderr = newCommandReader("stderr", command)
 
+	if stats := command.client.stats; stats != nil {
+		atomic.AddInt64(&stats.inFlight, 1)
+	}
 	go fetchOutput(ctx, command)
+	if interval := command.client.Parameters.KeepAlive; interval > 0 {
+		go keepAlive(ctx, command, interval)
+	}
 
 	return command
 }
 
+// keepAlive pings the shell of command at interval until it terminates
+func keepAlive(ctx context.Context, command *Command, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-command.done:
+			return
+		case <-ticker.C:
+			// a failure shows up in the output requests
+			_ = command.shell.ping(ctx)
+		}
+	}
+}
+
 func newCommandReader(stream string, command *Command) *commandReader {
 	read, write := io.Pipe()
-	return &commandReader{
+	reader := &commandReader{
 		Command: command,
 		stream:  stream,
 		write:   write,
 		read:    read,
 	}
+	if command.raw {
+		return reader
+	}
+	if command.client.Parameters.StripANSI {
+		reader.ansi = &ansiStripper{}
+	}
+	if enc := command.client.Parameters.OutputEncoding; enc != nil {
+		reader.transcoder = newTranscoder(enc)
+	}
+	if command.client.Parameters.NormalizeNewlines {
+		reader.newlines = &newlineNormalizer{}
+	}
+	return reader
 }
 
+// drainTimeout bounds the last output request of a canceled command, which the server
+// answers at once when it holds output, a hung one being given up on
+const drainTimeout = time.Second
+
 func fetchOutput(ctx context.Context, command *Command) {
 	ctxDone := ctx.Done()
+	var delay time.Duration
+	// drained tells an output request returned after the cancellation
+	drained := false
 	for {
 		select {
 		case <-command.cancel:
-			_, _ = command.slurpAllOutput()
-			err := errors.New("canceled")
-			command.Stderr.write.CloseWithError(err)
-			command.Stdout.write.CloseWithError(err)
+			// the output the server buffered before the cancellation is still delivered,
+			// by a last output request unless the one running then got it
+			if !drained {
+				drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+				_, _, _ = command.slurpAllOutput(drainCtx)
+				cancel()
+			}
+			command.Stderr.closeOutput(ErrCommandCanceled)
+			command.Stdout.closeOutput(ErrCommandCanceled)
+			command.setFinished()
 			close(command.done)
 			return
 		case <-ctxDone:
-			command.err = ctx.Err()
+			command.err = context.Cause(ctx)
 			ctxDone = nil
 			command.Close()
 		default:
-			finished, err := command.slurpAllOutput()
+			finished, output, err := command.slurpAllOutput(ctx)
 			if finished {
 				command.err = err
+				command.setFinished()
 				close(command.done)
 				return
 			}
+			select {
+			case <-command.cancel:
+				drained = true
+			default:
+			}
+			delay = command.client.Parameters.receiveDelay(delay, output)
+			command.pause(ctx, delay)
 		}
 	}
 }
 
+// receiveDelay returns the time to wait before the next output request, previous being
+// the one waited before the last request and output telling whether it received some
+func (p *Parameters) receiveDelay(previous time.Duration, output bool) time.Duration {
+	if output || previous < p.ReceiveInterval || p.ReceiveMaxInterval <= p.ReceiveInterval {
+		return p.ReceiveInterval
+	}
+	if previous*2 > p.ReceiveMaxInterval {
+		return p.ReceiveMaxInterval
+	}
+	return previous * 2
+}
+
+// pause waits for delay, returning early when the command is canceled
+func (c *Command) pause(ctx context.Context, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.cancel:
+	case <-ctx.Done():
+	}
+}
+
 func (c *Command) check() error {
 	if c.id == "" {
-		return errors.New("Command has already been closed")
+		return ErrCommandClosed
 	}
 	if c.shell == nil {
 		return errors.New("Command has no associated shell")
@@ -112,7 +265,9 @@ func (c *Command) check() error {
 	return nil
 }
 
-// Close will terminate the running command
+// Close will terminate the running command: it sends the terminate signal and stops receiving
+// its output, the Stdout and Stderr readers failing with ErrCommandCanceled if it was still
+// running. To only tell the command its input is over, use CloseStdin.
 func (c *Command) Close() error {
 	if err := c.check(); err != nil {
 		return err
@@ -124,64 +279,119 @@ func (c *Command) Close() error {
 		close(c.cancel)
 	}
 
-	request := NewSignalRequest(c.client.url, c.shell.id, c.id, &c.client.Parameters)
+	request := NewSignalRequest(c.client.url, c.shell.id, c.id, c.client.requestParameters())
+	defer request.Free()
+
+	_, err := c.client.sendRequest(request)
+	return err
+}
+
+// CloseStdin sends the end of the input to the command, like closing Stdin: the command
+// keeps running and its output is still received until it terminates
+func (c *Command) CloseStdin() error {
+	return c.Stdin.Close()
+}
+
+// Signal sends sig (SignalCtrlC, SignalCtrlBreak or SignalTerminate) to the running command.
+// Unlike Close, the output is still received afterwards, so the process can handle the signal
+// and exit gracefully, reporting its exit code.
+func (c *Command) Signal(sig string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+
+	request := NewSignalRequestWithCode(c.client.url, c.shell.id, c.id, sig, c.client.requestParameters())
 	defer request.Free()
 
 	_, err := c.client.sendRequest(request)
 	return err
 }
 
-func (c *Command) slurpAllOutput() (bool, error) {
+func (c *Command) slurpAllOutput(ctx context.Context) (finished bool, output bool, err error) {
 	if err := c.check(); err != nil {
-		c.Stderr.write.CloseWithError(err)
-		c.Stdout.write.CloseWithError(err)
-		return true, err
+		c.Stderr.closeOutput(err)
+		c.Stdout.closeOutput(err)
+		return true, false, err
 	}
 
-	request := NewGetOutputRequest(c.client.url, c.shell.id, c.id, "stdout stderr", &c.client.Parameters)
+	request := NewGetOutputRequest(c.client.url, c.shell.id, c.id, "stdout stderr", c.client.requestParameters())
 	defer request.Free()
 
-	response, err := c.client.sendRequest(request)
+	c.stateMutex.Lock()
+	c.receives++
+	c.stateMutex.Unlock()
+	response, err := c.client.sendRequestWithContext(ctx, request)
 	if err != nil {
+		if ctx.Err() != nil {
+			// the command is canceled, fetchOutput terminates it
+			return false, false, err
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// Parameters.RequestTimeout elapsed, the server is hung
+			c.Stderr.closeOutput(err)
+			c.Stdout.closeOutput(err)
+			return true, false, err
+		}
 		var errWithTimeout *url.Error
 		if errors.As(err, &errWithTimeout) && errWithTimeout.Timeout() {
 			// Operation timeout because the server didn't respond in time
-			return false, err
+			return false, false, err
 		}
 		if strings.Contains(err.Error(), "OperationTimeout") {
 			// Operation timeout because there was no command output
-			return false, err
+			return false, false, err
 		}
 		if strings.Contains(err.Error(), "EOF") {
 			c.exitCode = 16001
 		}
 
-		c.Stderr.write.CloseWithError(err)
-		c.Stdout.write.CloseWithError(err)
-		return true, err
+		c.Stderr.closeOutput(err)
+		c.Stdout.closeOutput(err)
+		return true, false, err
 	}
 
-	var exitCode int
-	var stdout, stderr bytes.Buffer
-	finished, exitCode, err := ParseSlurpOutputErrResponse(response, &stdout, &stderr)
+	chunks, state, exitCode, err := parseReceiveResponse(response)
 	if err != nil {
-		c.Stderr.write.CloseWithError(err)
-		c.Stdout.write.CloseWithError(err)
-		return true, err
+		c.Stderr.closeOutput(err)
+		c.Stdout.closeOutput(err)
+		return true, false, err
 	}
-	if stdout.Len() > 0 {
-		_, _ = c.Stdout.write.Write(stdout.Bytes())
+	c.setState(state)
+	finished = state == CommandStateDone
+	if len(chunks) > 0 {
+		c.stateMutex.Lock()
+		if c.firstOutput.IsZero() {
+			c.firstOutput = time.Now()
+		}
+		c.stateMutex.Unlock()
+	}
+	// the chunks are written one at a time, so that OnOutput sees them in order
+	for _, chunk := range chunks {
+		switch chunk.Stream {
+		case "stdout":
+			c.Stdout.writeOutput(chunk.Data)
+		case "stderr":
+			c.Stderr.writeOutput(chunk.Data)
+		}
+		if c.exceeded {
+			break
+		}
 	}
-	if stderr.Len() > 0 {
-		_, _ = c.Stderr.write.Write(stderr.Bytes())
+	if c.exceeded {
+		// stop receiving the output of a runaway command and terminate it
+		err := fmt.Errorf("%w: more than %d bytes", ErrOutputLimitExceeded, c.maxOutput)
+		c.Stderr.closeOutput(nil)
+		c.Stdout.closeOutput(nil)
+		_ = c.Close()
+		return true, true, err
 	}
 	if finished {
 		c.exitCode = exitCode
-		_ = c.Stderr.write.Close()
-		_ = c.Stdout.write.Close()
+		c.Stderr.closeOutput(nil)
+		c.Stdout.closeOutput(nil)
 	}
 
-	return finished, nil
+	return finished, len(chunks) > 0, nil
 }
 
 func (c *Command) sendInput(data []byte, eof bool) error {
@@ -189,24 +399,211 @@ func (c *Command) sendInput(data []byte, eof bool) error {
 		return err
 	}
 
-	request := NewSendInputRequest(c.client.url, c.shell.id, c.id, data, eof, &c.client.Parameters)
+	request := NewSendInputRequest(c.client.url, c.shell.id, c.id, data, eof, c.client.requestParameters())
 	defer request.Free()
 
 	_, err := c.client.sendRequest(request)
 	return err
 }
 
+// ID returns the identifier of the command on the server, as found in its WinRM operational logs
+func (c *Command) ID() string {
+	return c.id
+}
+
+// setState records the state reported by a Receive response when it changed
+func (c *Command) setState(state string) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if state == "" || (len(c.states) > 0 && c.states[len(c.states)-1].State == state) {
+		return
+	}
+	c.states = append(c.states, CommandStateChange{State: state, Time: time.Now()})
+}
+
+// setFinished records the termination time of the command, which isn't in flight anymore
+func (c *Command) setFinished() {
+	if stats := c.client.stats; stats != nil {
+		atomic.AddInt64(&stats.inFlight, -1)
+	}
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.finished = time.Now()
+}
+
+// State returns the last state of the command reported by the server, like CommandStateRunning,
+// or an empty string before the first output request returned
+func (c *Command) State() string {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if len(c.states) == 0 {
+		return ""
+	}
+	return c.states[len(c.states)-1].State
+}
+
+// StateChanges returns the changes of the command state reported by the server so far
+func (c *Command) StateChanges() []CommandStateChange {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return append([]CommandStateChange(nil), c.states...)
+}
+
+// win32Process is the part of a Win32_Process WMI instance used to find the process of a command
+type win32Process struct {
+	ProcessID   int    `xml:"ProcessId"`
+	CommandLine string `xml:"CommandLine"`
+}
+
+// ProcessID returns the ID of the remote process started for the command, cmd.exe unless it
+// runs with ExecuteOptions.SkipCmdShell, so that it can be inspected or terminated through WMI.
+// WinRM doesn't report it: the process is looked up among the children of the WinRM shell
+// hosts (winrshost.exe) by its command line, which fails with ErrProcessNotFound when no
+// process, or several ones, match, or when the command line is unknown (Shell.AttachCommand).
+func (c *Command) ProcessID(ctx context.Context) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	if c.commandLine == "" {
+		// every process would match an empty command line
+		return 0, fmt.Errorf("%w: the command line of an attached command is unknown", ErrProcessNotFound)
+	}
+
+	hosts, err := EnumerateAs[win32Process](ctx, c.client, ResourceURIWMICIMv2+"*",
+		"SELECT ProcessId FROM Win32_Process WHERE Name = 'winrshost.exe'")
+	if err != nil {
+		return 0, err
+	}
+	if len(hosts) == 0 {
+		return 0, ErrProcessNotFound
+	}
+	parents := make([]string, len(hosts))
+	for i, host := range hosts {
+		parents[i] = "ParentProcessId = " + strconv.Itoa(host.ProcessID)
+	}
+
+	children, err := EnumerateAs[win32Process](ctx, c.client, ResourceURIWMICIMv2+"*",
+		"SELECT ProcessId, CommandLine FROM Win32_Process WHERE "+strings.Join(parents, " OR "))
+	if err != nil {
+		return 0, err
+	}
+	pid := 0
+	for _, child := range children {
+		if !strings.Contains(child.CommandLine, c.commandLine) {
+			continue
+		}
+		if pid != 0 {
+			return 0, fmt.Errorf("%w: several processes run %q", ErrProcessNotFound, c.commandLine)
+		}
+		pid = child.ProcessID
+	}
+	if pid == 0 {
+		return 0, ErrProcessNotFound
+	}
+
+	return pid, nil
+}
+
 // ExitCode returns command exit code when it is finished. Before that the result is always 0.
 func (c *Command) ExitCode() int {
 	return c.exitCode
 }
 
+// DroppedBytes returns the number of stdout and stderr bytes discarded because
+// they went over Parameters.OutputLimit. It is only final once the command has terminated.
+func (c *Command) DroppedBytes() (stdout int64, stderr int64) {
+	return c.Stdout.dropped, c.Stderr.dropped
+}
+
+// Timing returns the timing metrics of the command. It is only final once the command has terminated.
+func (c *Command) Timing() CommandTiming {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	timing := CommandTiming{
+		ShellCreation: c.shellCreation,
+		Receives:      c.receives,
+	}
+	if !c.firstOutput.IsZero() {
+		timing.FirstOutput = c.firstOutput.Sub(c.started)
+	}
+	if c.finished.IsZero() {
+		timing.Duration = time.Since(c.started)
+	} else {
+		timing.Duration = c.finished.Sub(c.started)
+	}
+	return timing
+}
+
+// pipe copies stdin, which can be nil, to the command and its output to stdout and stderr,
+// returning its error once it terminated
+func (c *Command) pipe(stdout, stderr io.Writer, stdin io.Reader) error {
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go func() {
+		defer func() {
+			wg.Done()
+		}()
+		if stdin == nil {
+			return
+		}
+		defer func() {
+			c.Stdin.Close()
+		}()
+		_, _ = io.Copy(c.Stdin, stdin)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(stdout, c.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(stderr, c.Stderr)
+	}()
+
+	c.Wait()
+	wg.Wait()
+	c.Close()
+
+	return c.err
+}
+
 // Wait function will block the current goroutine until the remote command terminates.
 func (c *Command) Wait() {
 	// block until finished
 	<-c.done
 }
 
+// WaitWithContext blocks until the remote command terminates, returning its error,
+// or until ctx is done, returning the context error while the command keeps running.
+func (c *Command) WaitWithContext(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Exited reports without blocking whether the remote command has terminated
+func (c *Command) Exited() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Poll reports without blocking whether the remote command has terminated,
+// returning then its exit code and error
+func (c *Command) Poll() (exited bool, exitCode int, err error) {
+	if !c.Exited() {
+		return false, 0, nil
+	}
+	return true, c.exitCode, c.err
+}
+
 // Write data to this Pipe
 // commandWriter implements io.Writer and io.Closer interface
 func (w *commandWriter) Write(data []byte) (int, error) {
@@ -224,7 +621,7 @@ func (w *commandWriter) Write(data []byte) (int, error) {
 	origLen := len(data)
 	for len(data) > 0 {
 		// never send more data than our EnvelopeSize.
-		n := min(w.client.Parameters.EnvelopeSize-1000, len(data))
+		n := min(w.client.requestParameters().EnvelopeSize-1000, len(data))
 		if err := w.sendInput(data[:n], false); err != nil {
 			break
 		}
@@ -266,6 +663,94 @@ func (w *commandWriter) Close() error {
 	return w.sendInput(nil, w.eof)
 }
 
+// writeOutput forwards data received from the remote command to the pipe, in this order:
+//   - decoding it from Parameters.OutputEncoding
+//   - removing escape sequences if Parameters.StripANSI is set
+//   - replacing CRLF with LF if Parameters.NormalizeNewlines is set
+//   - delivering it with deliver
+func (r *commandReader) writeOutput(data []byte) {
+	if r.transcoder != nil {
+		data = r.transcoder.decode(data, false)
+	}
+	if r.ansi != nil {
+		data = r.ansi.strip(data)
+	}
+	if r.newlines != nil {
+		data = r.newlines.normalize(data)
+	}
+	r.deliver(data)
+}
+
+// deliver writes decoded output to the pipe, in this order:
+//   - cutting it at MaxOutput bytes received by both streams, the command then exceeding it
+//   - passing it to ExecuteOptions.OnOutput
+//   - keeping at most Parameters.OutputLimit bytes, counting the rest as dropped
+func (r *commandReader) deliver(data []byte) {
+	if r.maxOutput > 0 {
+		remaining := r.maxOutput - r.Stdout.received - r.Stderr.received
+		if int64(len(data)) > remaining {
+			r.exceeded = true
+			data = data[:remaining]
+		}
+	}
+	if len(data) == 0 {
+		return
+	}
+	r.received += int64(len(data))
+	r.callback(data)
+	if limit := int64(r.client.Parameters.OutputLimit); limit > 0 && !r.raw {
+		remaining := limit - r.written
+		if remaining < 0 {
+			remaining = 0
+		}
+		if int64(len(data)) > remaining {
+			r.dropped += int64(len(data)) - remaining
+			data = data[:remaining]
+		}
+	}
+	if len(data) == 0 {
+		return
+	}
+	r.written += int64(len(data))
+	_, _ = r.write.Write(data)
+}
+
+// callback passes decoded output to ExecuteOptions.OnOutput
+func (r *commandReader) callback(data []byte) {
+	if r.onOutput == nil || len(data) == 0 {
+		return
+	}
+	r.onOutput(r.stream, r.outputSeq, data)
+	r.outputSeq++
+}
+
+// closeOutput terminates the pipe, delivering the rest of the decoded output and appending
+// a truncation marker first if some output has been dropped
+func (r *commandReader) closeOutput(err error) {
+	if err == nil {
+		// an incomplete character or CRLF at the end of the output
+		var rest []byte
+		if r.transcoder != nil {
+			rest = r.transcoder.decode(nil, true)
+		}
+		if r.ansi != nil {
+			rest = r.ansi.strip(rest)
+		}
+		if r.newlines != nil {
+			rest = append(r.newlines.normalize(rest), r.newlines.flush()...)
+		}
+		r.deliver(rest)
+	}
+	if r.dropped > 0 {
+		_, _ = fmt.Fprintf(r.write, truncationMarker, r.dropped)
+	}
+	if err != nil {
+		r.write.CloseWithError(err)
+		return
+	}
+	_ = r.write.Close()
+}
+
 // Read data from this Pipe
 func (r *commandReader) Read(buf []byte) (int, error) {
 	n, err := r.read.Read(buf)
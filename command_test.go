@@ -2,6 +2,8 @@ package winrm
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,7 +11,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/masterzen/winrm/soap"
+	"github.com/satendraraj/winrm/soap"
 	. "gopkg.in/check.v1"
 )
 
@@ -43,6 +45,7 @@ func (s *WinRMSuite) TestExecuteCommand(c *C) {
 	}
 	client.http = &r
 	command, _ := shell.Execute("ipconfig /all")
+	c.Assert(command.ID(), Equals, "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4")
 	var stdout, stderr bytes.Buffer
 	var wg sync.WaitGroup
 	f := func(b *bytes.Buffer, r *commandReader) {
@@ -179,6 +182,43 @@ func (s *WinRMSuite) TestCloseCommandStopsFetch(c *C) {
 	}
 }
 
+func (s *WinRMSuite) TestCloseCommandDrainsOutput(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	params := NewParametersBuilder().ReceiveInterval(time.Hour, 0).Build()
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	receives := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch {
+		case strings.Contains(message.String(), ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(message.String(), ActionReceive):
+			receives++
+			if receives == 1 {
+				return "", &HTTPError{StatusCode: 500, Body: operationTimeoutResponse}
+			}
+			// the output buffered by the server when the command was closed
+			return singleOutputResponse, nil
+		}
+		return "", nil
+	}
+	client.http = &r
+	command, err := shell.ExecuteWithContext(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	go func() { _, _ = io.ReadAll(command.Stderr) }()
+
+	// the command waits for the next output request when it is closed
+	time.Sleep(10 * time.Millisecond)
+	c.Assert(command.Close(), IsNil)
+	stdout, err := io.ReadAll(command.Stdout)
+	c.Assert(err, Equals, ErrCommandCanceled)
+	c.Assert(string(stdout), Equals, "That's all folks!!!")
+	c.Assert(receives, Equals, 2)
+}
+
 func (s *WinRMSuite) TestConnectionTimeout(c *C) {
 	count := 0
 	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -294,3 +334,363 @@ func (s *WinRMSuite) TestEOFError(c *C) {
 	c.Assert(command.exitCode, Equals, 16001)
 	c.Assert(command.err.Error(), Contains, "EOF")
 }
+
+func (s *WinRMSuite) TestCommandOutputLimit(c *C) {
+	params := NewParameters("PT60S", "en-US", 153600)
+	params.OutputLimit = 4
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	count := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		defer func() { count++ }()
+		switch count {
+		case 0:
+			return executeCommandResponse, nil
+		case 1, 2:
+			return outputResponse, nil
+		default:
+			return doneCommandResponse, nil
+		}
+	}
+	client.http = &r
+	command, err := shell.Execute("ipconfig /all")
+	c.Assert(err, IsNil)
+	var stdout, stderr bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); _, _ = io.Copy(&stdout, command.Stdout) }()
+	go func() { defer wg.Done(); _, _ = io.Copy(&stderr, command.Stderr) }()
+	command.Wait()
+	wg.Wait()
+
+	c.Assert(stdout.String(), Equals, "That\n[output truncated: 34 bytes dropped]\n")
+	c.Assert(stderr.String(), Equals, "This\n[output truncated: 60 bytes dropped]\n")
+	outDropped, errDropped := command.DroppedBytes()
+	c.Assert(outDropped, Equals, int64(34))
+	c.Assert(errDropped, Equals, int64(60))
+}
+
+func (s *WinRMSuite) TestCommandStripANSI(c *C) {
+	params := NewParameters("PT60S", "en-US", 153600)
+	params.StripANSI = true
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	colored := strings.Replace(singleOutputResponse, "VGhhdCdzIGFsbCBmb2xrcyEhIQ==", "G1szMm1ncmVlbhtbMG0=", 1)
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	count := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		defer func() { count++ }()
+		switch count {
+		case 0:
+			return executeCommandResponse, nil
+		case 1:
+			return colored, nil
+		default:
+			return doneCommandResponse, nil
+		}
+	}
+	client.http = &r
+	command, err := shell.Execute("ipconfig /all")
+	c.Assert(err, IsNil)
+	var stdout bytes.Buffer
+	go func() { _, _ = io.Copy(io.Discard, command.Stderr) }()
+	_, _ = io.Copy(&stdout, command.Stdout)
+	command.Wait()
+
+	c.Assert(stdout.String(), Equals, "green")
+}
+
+func (s *WinRMSuite) TestCommandSignal(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	signaled := make(chan struct{})
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, "/windows/shell/Command"):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "/windows/shell/Signal"):
+			c.Check(body, Contains, "<rsp:Code>"+SignalCtrlC+"</rsp:Code>")
+			close(signaled)
+			return "", nil
+		}
+		// the command runs until it gets the signal
+		<-signaled
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	command, err := shell.Execute("ping -t localhost")
+	c.Assert(err, IsNil)
+	c.Assert(command.Signal(SignalCtrlC), IsNil)
+	command.Wait()
+	c.Assert(command.ExitCode(), Equals, 123)
+	c.Assert(command.err, IsNil)
+}
+
+func (s *WinRMSuite) TestCommandWaitWithContext(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	finish := make(chan struct{})
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), "/windows/shell/Command") {
+			return executeCommandResponse, nil
+		}
+		<-finish
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	command, err := shell.Execute("ping -t localhost")
+	c.Assert(err, IsNil)
+	c.Assert(command.Exited(), Equals, false)
+	exited, _, _ := command.Poll()
+	c.Assert(exited, Equals, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Assert(command.WaitWithContext(ctx), Equals, context.DeadlineExceeded)
+
+	close(finish)
+	c.Assert(command.WaitWithContext(context.Background()), IsNil)
+	c.Assert(command.Exited(), Equals, true)
+	exited, code, err := command.Poll()
+	c.Assert(exited, Equals, true)
+	c.Assert(code, Equals, 123)
+	c.Assert(err, IsNil)
+}
+
+func (s *WinRMSuite) TestCommandKeepAlive(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	params := NewParametersBuilder().KeepAlive(10 * time.Millisecond).Build()
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	pinged := make(chan struct{}, 100)
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionGet):
+			c.Check(body, Contains, shell.id)
+			pinged <- struct{}{}
+			return "", nil
+		}
+		// the command runs until the shell got pinged twice
+		<-pinged
+		<-pinged
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	command, err := shell.Execute("msiexec /i big.msi /quiet")
+	c.Assert(err, IsNil)
+	command.Wait()
+	c.Assert(command.ExitCode(), Equals, 123)
+}
+
+func (s *WinRMSuite) TestReceiveDelay(c *C) {
+	params := NewParametersBuilder().ReceiveInterval(100*time.Millisecond, time.Second).Build()
+	delay := params.receiveDelay(0, true)
+	c.Assert(delay, Equals, 100*time.Millisecond)
+	for _, expected := range []time.Duration{200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		delay = params.receiveDelay(delay, false)
+		c.Assert(delay, Equals, expected)
+	}
+	c.Assert(params.receiveDelay(delay, true), Equals, 100*time.Millisecond)
+
+	c.Assert(DefaultParameters.receiveDelay(0, false), Equals, time.Duration(0))
+	fixed := NewParametersBuilder().ReceiveInterval(50*time.Millisecond, 0).Build()
+	c.Assert(fixed.receiveDelay(50*time.Millisecond, false), Equals, 50*time.Millisecond)
+}
+
+func (s *WinRMSuite) TestCommandMaxOutput(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	params := NewParametersBuilder().MaxOutput(25).Build()
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	var terminated bool
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionSignal):
+			terminated = true
+			return "", nil
+		case strings.Contains(body, ActionReceive):
+			// the command never stops writing
+			return outputResponse, nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	stdout, stderr, _, err := client.RunCmdWithContext(context.Background(), "type huge.log")
+	c.Assert(errors.Is(err, ErrOutputLimitExceeded), Equals, true)
+	c.Assert(stdout, Equals, "That's all folks!!!")
+	c.Assert(stderr, Equals, "This i")
+	c.Assert(terminated, Equals, true)
+}
+
+func (s *WinRMSuite) TestCommandCloseStdin(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	eof := make(chan struct{})
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionSend):
+			c.Check(body, Contains, `End="true"`)
+			close(eof)
+			return "", nil
+		case strings.Contains(body, ActionSignal):
+			c.Errorf("the command was terminated")
+			return "", nil
+		}
+		// the command sorts its input once it has read it all
+		<-eof
+		return doneOutputResponse("a\r\nb\r\n", 0), nil
+	}
+	client.http = &r
+
+	command, err := shell.Execute("sort")
+	c.Assert(err, IsNil)
+	c.Assert(command.CloseStdin(), IsNil)
+	c.Assert(command.CloseStdin(), Equals, io.ErrClosedPipe)
+	output, err := io.ReadAll(command.Stdout)
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, "a\r\nb\r\n")
+	command.Wait()
+	c.Assert(command.ExitCode(), Equals, 0)
+}
+
+func (s *WinRMSuite) TestCommandNormalizeNewlines(c *C) {
+	params := NewParametersBuilder().NormalizeNewlines(true).Build()
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	count := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		defer func() { count++ }()
+		switch count {
+		case 0:
+			return executeCommandResponse, nil
+		case 1:
+			return strings.Replace(doneOutputResponse("", 0), "Done", "Running", 1), nil
+		}
+		return doneOutputResponse("\r\nWindows IP Configuration\r\n\r\n", 0), nil
+	}
+	client.http = &r
+	command, err := shell.Execute("ipconfig")
+	c.Assert(err, IsNil)
+	output, err := io.ReadAll(command.Stdout)
+	c.Assert(err, IsNil)
+	command.Wait()
+
+	c.Assert(string(output), Equals, "\nWindows IP Configuration\n\n")
+}
+
+func win32ProcessesResponse(processes ...string) string {
+	return `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Process"><s:Body><n:EnumerateResponse><w:Items>` +
+		strings.Join(processes, "") + `</w:Items><w:EndOfSequence/></n:EnumerateResponse></s:Body></s:Envelope>`
+}
+
+func (s *WinRMSuite) TestCommandStateAndProcessID(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	finish := make(chan struct{})
+	receives := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "winrshost.exe"):
+			return win32ProcessesResponse(`<p:Win32_Process><p:ProcessId>4242</p:ProcessId></p:Win32_Process>`), nil
+		case strings.Contains(body, ActionEnumerate):
+			c.Check(body, Contains, "WHERE ParentProcessId = 4242")
+			return win32ProcessesResponse(
+				`<p:Win32_Process><p:ProcessId>5150</p:ProcessId><p:CommandLine>C:\Windows\system32\cmd.exe /c ipconfig /all</p:CommandLine></p:Win32_Process>`,
+				`<p:Win32_Process><p:ProcessId>5151</p:ProcessId><p:CommandLine>C:\Windows\system32\cmd.exe /c dir</p:CommandLine></p:Win32_Process>`), nil
+		case strings.Contains(body, ActionReceive):
+			receives++
+			if receives == 1 {
+				return outputResponse, nil
+			}
+			<-finish
+			return doneCommandResponse, nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	command, err := shell.Execute("ipconfig", "/all")
+	c.Assert(err, IsNil)
+	go func() { _, _ = io.Copy(io.Discard, command.Stderr) }()
+	_, err = io.ReadAtLeast(command.Stdout, make([]byte, 19), 19)
+	c.Assert(err, IsNil)
+	c.Assert(command.State(), Equals, CommandStateRunning)
+
+	pid, err := command.ProcessID(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(pid, Equals, 5150)
+
+	close(finish)
+	_, _ = io.Copy(io.Discard, command.Stdout)
+	command.Wait()
+	changes := command.StateChanges()
+	c.Assert(changes, HasLen, 2)
+	c.Assert(changes[0].State, Equals, CommandStateRunning)
+	c.Assert(changes[1].State, Equals, CommandStateDone)
+}
+
+func (s *WinRMSuite) TestAttachedCommandProcessID(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		c.Check(message.String(), Not(Contains), ActionEnumerate)
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	command, err := shell.AttachCommand(context.Background(), "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4")
+	c.Assert(err, IsNil)
+	_, err = command.ProcessID(context.Background())
+	c.Assert(errors.Is(err, ErrProcessNotFound), Equals, true)
+	_, _ = io.Copy(io.Discard, command.Stdout)
+	command.Wait()
+}
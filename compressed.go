@@ -0,0 +1,104 @@
+package winrm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// compressedOutputScript gzips the given remote file, writes it base64 encoded
+// on stdout and removes the file
+const compressedOutputScript = `$path = [Environment]::ExpandEnvironmentVariables('%s')
+$in = [IO.File]::OpenRead($path)
+$buffer = New-Object IO.MemoryStream
+$gzip = New-Object IO.Compression.GZipStream($buffer, [IO.Compression.CompressionMode]::Compress)
+$in.CopyTo($gzip)
+$gzip.Close()
+$in.Close()
+Remove-Item -Force $path
+[Console]::Out.Write([Convert]::ToBase64String($buffer.ToArray()))`
+
+// compressedCleanupTimeout bounds the removal of the remote file of a failed RunCompressedWithContext
+const compressedCleanupTimeout = 10 * time.Second
+
+// RunCompressedWithContext will run command on the the remote host with its stdout and stderr
+// redirected to a temporary remote file, which is then compressed, downloaded and decompressed
+// locally. This is much faster than RunWithContext for commands known to produce huge outputs,
+// at the cost of not streaming the output while the command runs.
+// It returns the combined stdout and stderr of the command and its exit code.
+func (c *Client) RunCompressedWithContext(ctx context.Context, command string) ([]byte, int, error) {
+	shell, err := c.CreateShellWithContext(ctx)
+	if err != nil {
+		return nil, 1, err
+	}
+	defer shell.Close()
+
+	path := `%TEMP%\winrm-` + uuid.Must(uuid.NewV4()).String() + ".log"
+	removed := false
+	defer func() {
+		if !removed {
+			// the output of a failed command is left behind otherwise
+			cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compressedCleanupTimeout)
+			defer cancel()
+			_, _ = shell.run(cleanupCtx, fmt.Sprintf(`del /f /q "%s"`, path), nil, io.Discard, io.Discard, nil)
+		}
+	}()
+
+	var stderr bytes.Buffer
+	cmd, err := shell.run(ctx, fmt.Sprintf(`(%s) > "%s" 2>&1`, command, path), nil, io.Discard, &stderr, nil)
+	if cmd == nil {
+		return nil, 1, err
+	}
+	if err != nil {
+		return nil, cmd.ExitCode(), err
+	}
+	exitCode := cmd.ExitCode()
+
+	var encoded bytes.Buffer
+	stderr.Reset()
+	script := Powershell(fmt.Sprintf(compressedOutputScript, strings.ReplaceAll(path, "'", "''")))
+	// the payload bypasses the output filters of the client, which would corrupt it
+	cmd, err = shell.run(ctx, script, &ExecuteOptions{raw: true}, &encoded, &stderr, nil)
+	if err != nil {
+		return nil, exitCode, fmt.Errorf("downloading compressed output: %w", err)
+	}
+	if cmd.ExitCode() != 0 {
+		return nil, exitCode, fmt.Errorf("compressing remote output failed with exit code %d: %s", cmd.ExitCode(), stderr.String())
+	}
+	removed = true
+
+	output, err := decompressOutput(encoded.Bytes())
+	if err != nil {
+		return nil, exitCode, err
+	}
+
+	return output, exitCode, nil
+}
+
+// decompressOutput decodes the base64 gzip stream produced by compressedOutputScript
+func decompressOutput(encoded []byte) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding compressed output: %w", err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing output: %w", err)
+	}
+	defer reader.Close()
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing output: %w", err)
+	}
+
+	return output, nil
+}
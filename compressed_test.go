@@ -0,0 +1,85 @@
+package winrm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestRunCompressedWithContext(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	// the payload isn't filtered like the output of the commands
+	params := NewParametersBuilder().OutputLimit(4).MaxOutput(8).NormalizeNewlines(true).StripANSI(true).Build()
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write([]byte("a huge log"))
+	_ = gz.Close()
+	encoded := base64.StdEncoding.EncodeToString(compressed.Bytes())
+
+	var commands []string
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			return createShellResponse, nil
+		case strings.Contains(body, "shell/Command"):
+			commands = append(commands, body)
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Receive") && len(commands) == 1:
+			return doneOutputResponse("", 3), nil
+		case strings.Contains(body, "shell/Receive"):
+			return doneOutputResponse(encoded, 0), nil
+		default:
+			return "", nil
+		}
+	}
+	client.http = &r
+
+	output, code, err := client.RunCompressedWithContext(context.Background(), "cd /d C:\\ & dism /online /get-features")
+	c.Assert(err, IsNil)
+	c.Assert(code, Equals, 3)
+	c.Assert(string(output), Equals, "a huge log")
+	c.Assert(commands, HasLen, 2)
+	c.Assert(commands[0], Contains, `(cd /d C:\ & dism /online /get-features) > "%TEMP%\winrm-`)
+	c.Assert(commands[1], Contains, "powershell.exe -EncodedCommand")
+}
+
+func (s *WinRMSuite) TestRunCompressedWithContextCleanup(c *C) {
+	client, err := NewClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	var commands []string
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			return createShellResponse, nil
+		case strings.Contains(body, "shell/Command"):
+			commands = append(commands, body)
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Receive") && len(commands) == 1:
+			return "", errors.New("connection reset")
+		case strings.Contains(body, "shell/Receive"):
+			return doneOutputResponse("", 0), nil
+		default:
+			return "", nil
+		}
+	}
+	client.http = &r
+
+	_, _, err = client.RunCompressedWithContext(context.Background(), "dism /online /get-features")
+	c.Assert(err, ErrorMatches, ".*connection reset")
+	c.Assert(commands, HasLen, 2)
+	c.Assert(commands[1], Contains, `del /f /q "%TEMP%\winrm-`)
+}
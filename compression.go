@@ -0,0 +1,136 @@
+package winrm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// acceptEncoding is the Accept-Encoding of the requests when Parameters.Compression is set.
+// Setting it disables the transparent gzip decompression of net/http, so decompressResponse
+// handles both encodings.
+const acceptEncoding = "gzip, deflate"
+
+// the states of requestCompression
+const (
+	// compressionUnknown: the server didn't advertise gzip requests yet, they are sent in clear
+	compressionUnknown int32 = iota
+	// compressionAccepted: the server advertised gzip in the Accept-Encoding of a response
+	compressionAccepted
+	// compressionRefused: the server failed a compressed request, which succeeded in clear
+	compressionRefused
+)
+
+// requestCompression is the compression of the requests negotiated with the server,
+// shared by a client and the views derived with WithParams
+type requestCompression struct {
+	state int32
+}
+
+// compressRequests tells if the requests of client should be compressed:
+// Parameters.Compression is set, and the server advertised it accepts gzip requests
+func (c *Client) compressRequests() bool {
+	return c.Compression && c.compression != nil && atomic.LoadInt32(&c.compression.state) == compressionAccepted
+}
+
+// negotiateCompression compresses the next requests once resp advertises that the server
+// accepts gzip requests, with the Accept-Encoding response header of RFC 7694
+func (c *Client) negotiateCompression(resp *http.Response) {
+	if !c.Compression || c.compression == nil || !acceptsGzip(resp.Header.Values("Accept-Encoding")) {
+		return
+	}
+	atomic.CompareAndSwapInt32(&c.compression.state, compressionUnknown, compressionAccepted)
+}
+
+// refuseCompression records that the server doesn't accept compressed requests after all
+func (c *Client) refuseCompression() {
+	if c.compression != nil {
+		atomic.StoreInt32(&c.compression.state, compressionRefused)
+	}
+}
+
+// acceptsGzip tells if the Accept-Encoding header values list gzip with a non zero quality
+func acceptsGzip(values []string) bool {
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok && strings.Trim(q, "0.") == "" {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// refusesCompression tells if resp could be the server failing to decode a compressed request:
+// a 415 as intended by HTTP, but Windows rather answers 400 or 500 without a SOAP fault
+func refusesCompression(resp *http.Response, compatibility Compatibility) bool {
+	switch resp.StatusCode {
+	case http.StatusUnsupportedMediaType, http.StatusBadRequest:
+		return true
+	case http.StatusInternalServerError:
+		return !isSOAPContentType(resp.Header.Get("Content-Type"), compatibility)
+	}
+	return false
+}
+
+// compressRequest returns the gzip compressed body of a request
+func compressRequest(body string) (*bytes.Reader, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := io.WriteString(writer, body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buffer.Bytes()), nil
+}
+
+// decompressResponse replaces the body of resp by its decoded content when it is compressed
+func decompressResponse(resp *http.Response) error {
+	var reader io.ReadCloser
+	var err error
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		reader, err = zlib.NewReader(resp.Body)
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("unsupported response content encoding %q", encoding)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return fmt.Errorf("decompressing the response: %w", err)
+	}
+
+	resp.Body = &decompressedBody{ReadCloser: reader, compressed: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+
+	return nil
+}
+
+// decompressedBody closes the compressed body with the decompressing reader
+type decompressedBody struct {
+	io.ReadCloser
+	compressed io.ReadCloser
+}
+
+func (b *decompressedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.compressed.Close()
+}
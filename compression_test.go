@@ -0,0 +1,131 @@
+package winrm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestCompression(c *C) {
+	var encodings []string
+	responseEncoding, advertise := "gzip", false
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			c.Assert(err, IsNil)
+			body = reader
+		}
+		b, err := io.ReadAll(body)
+		c.Assert(err, IsNil)
+		c.Assert(strings.Contains(string(b), "transfer/Create"), Equals, true)
+
+		if advertise {
+			w.Header().Set("Accept-Encoding", "gzip")
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), responseEncoding) {
+			_, _ = w.Write([]byte(createShellResponse))
+			return
+		}
+		var compressed bytes.Buffer
+		var writer io.WriteCloser = gzip.NewWriter(&compressed)
+		if responseEncoding == "deflate" {
+			writer = zlib.NewWriter(&compressed)
+		}
+		_, _ = io.WriteString(writer, createShellResponse)
+		writer.Close()
+		w.Header().Set("Content-Encoding", responseEncoding)
+		_, _ = w.Write(compressed.Bytes())
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	// the requests are sent in clear as long as the server doesn't advertise gzip
+	params := NewParametersBuilder().Compression(true).AllowInsecureBasic(true).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+	for _, responseEncoding = range []string{"gzip", "deflate"} {
+		shell, err := client.CreateShell()
+		c.Assert(err, IsNil)
+		c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	}
+	c.Assert(encodings, DeepEquals, []string{"", ""})
+
+	encodings, advertise = nil, true
+	for _, responseEncoding = range []string{"gzip", "deflate", "gzip"} {
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil)
+	}
+	c.Assert(encodings, DeepEquals, []string{"", "gzip", "gzip"})
+
+	// without the parameter the requests are sent as before
+	encodings = nil
+	client, err = NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(encodings, DeepEquals, []string{""})
+}
+
+func (s *WinRMSuite) TestCompressionRefused(c *C) {
+	var encodings []string
+	status := http.StatusBadRequest
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		w.Header().Set("Accept-Encoding", "gzip")
+		if r.Header.Get("Content-Encoding") != "" || status == http.StatusInternalServerError {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	params := NewParametersBuilder().Compression(true).AllowInsecureBasic(true).Build()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "test", "test", params)
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil)
+	}
+	c.Assert(encodings, DeepEquals, []string{"", "gzip", "", ""})
+
+	// a request failing in clear as well is retried once, compression being kept
+	encodings = nil
+	client, err = NewClientWithParameters(endpoint, "test", "test", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	status = http.StatusInternalServerError
+	for i := 0; i < 2; i++ {
+		_, err = client.CreateShell()
+		c.Assert(err, NotNil)
+	}
+	c.Assert(encodings, DeepEquals, []string{"", "gzip", "", "gzip", ""})
+}
+
+func (s *WinRMSuite) TestAcceptsGzip(c *C) {
+	for _, t := range []struct {
+		values []string
+		gzip   bool
+	}{
+		{nil, false},
+		{[]string{"identity"}, false},
+		{[]string{"deflate, GZIP"}, true},
+		{[]string{"deflate", "gzip;q=0.5"}, true},
+		{[]string{"gzip;q=0"}, false},
+		{[]string{"gzip; q=0.000"}, false},
+	} {
+		c.Check(acceptsGzip(t.values), Equals, t.gzip, Commentf("%q", t.values))
+	}
+}
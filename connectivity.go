@@ -0,0 +1,99 @@
+package winrm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConnectionTestResult is the outcome of a Test-NetConnection run on the remote host
+type ConnectionTestResult struct {
+	ComputerName            string
+	RemoteAddress           string
+	RemotePort              int
+	SourceAddress           string
+	InterfaceAlias          string
+	NameResolutionSucceeded bool
+	// TcpTestSucceeded tells if RemotePort accepted a connection, it is only set by TestPort
+	TcpTestSucceeded bool
+	// PingSucceeded and RoundTripTime are only set by TestPing
+	PingSucceeded bool
+	RoundTripTime time.Duration
+}
+
+// Reachable tells if the tested host answered
+func (r *ConnectionTestResult) Reachable() bool {
+	if r.RemotePort != 0 {
+		return r.TcpTestSucceeded
+	}
+	return r.PingSucceeded
+}
+
+// TestPort checks from the remote host that a TCP connection to host:port can be established,
+// so orchestration can validate that the managed server reaches its dependencies.
+// It relies on Test-NetConnection, available since Windows Server 2012 R2.
+// An unreachable port is reported in the result, not as an error.
+func (c *Client) TestPort(ctx context.Context, host string, port int) (*ConnectionTestResult, error) {
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+
+	return c.testConnection(ctx, host, "-Port "+strconv.Itoa(port))
+}
+
+// TestPing checks from the remote host that host answers ICMP echo requests
+func (c *Client) TestPing(ctx context.Context, host string) (*ConnectionTestResult, error) {
+	return c.testConnection(ctx, host, "")
+}
+
+func (c *Client) testConnection(ctx context.Context, host, arguments string) (*ConnectionTestResult, error) {
+	if host == "" {
+		return nil, fmt.Errorf("connection test needs a host")
+	}
+
+	result, err := c.Exec(ctx, connectionTestScript(host, arguments), WithPowerShell())
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("connection test to %s failed with exit code %d: %s", host, result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+
+	return parseConnectionTest(result.Stdout)
+}
+
+// connectionTestScript runs Test-NetConnection and prints the interesting
+// properties as JSON, addresses being flattened to strings
+func connectionTestScript(host, arguments string) string {
+	return "$r = Test-NetConnection -ComputerName " + psQuote(host) + " " + arguments +
+		" -InformationLevel Detailed -WarningAction SilentlyContinue -ErrorAction Stop;" +
+		"[pscustomobject]@{" +
+		"ComputerName = [string]$r.ComputerName;" +
+		"RemoteAddress = [string]$r.RemoteAddress;" +
+		"RemotePort = [int]$r.RemotePort;" +
+		"SourceAddress = [string]$r.SourceAddress.IPAddress;" +
+		"InterfaceAlias = [string]$r.InterfaceAlias;" +
+		"NameResolutionSucceeded = [bool]$r.NameResolutionSucceeded;" +
+		"TcpTestSucceeded = [bool]$r.TcpTestSucceeded;" +
+		"PingSucceeded = [bool]$r.PingSucceeded;" +
+		"RoundTripTimeMs = [int64]$r.PingReplyDetails.RoundtripTime" +
+		"} | ConvertTo-Json -Compress"
+}
+
+func parseConnectionTest(output string) (*ConnectionTestResult, error) {
+	var parsed struct {
+		ConnectionTestResult
+		RoundTripTimeMs int64
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &parsed); err != nil {
+		return nil, fmt.Errorf("unexpected connection test output %q: %w", output, err)
+	}
+
+	result := parsed.ConnectionTestResult
+	result.RoundTripTime = time.Duration(parsed.RoundTripTimeMs) * time.Millisecond
+
+	return &result, nil
+}
@@ -0,0 +1,57 @@
+package winrm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestTestPort(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	output := `{"ComputerName":"db01","RemoteAddress":"10.0.0.12","RemotePort":1433,"SourceAddress":"10.0.0.5",` +
+		`"InterfaceAlias":"Ethernet","NameResolutionSucceeded":true,"TcpTestSucceeded":false,"PingSucceeded":false,"RoundTripTimeMs":0}` + "\r\n"
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			return createShellResponse, nil
+		case strings.Contains(body, "shell/Command"):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Receive"):
+			return doneOutputResponse(output, 0), nil
+		default:
+			return "", nil
+		}
+	}
+	client.http = &r
+
+	result, err := client.TestPort(context.Background(), "db01", 1433)
+	c.Assert(err, IsNil)
+	c.Assert(result.RemoteAddress, Equals, "10.0.0.12")
+	c.Assert(result.RemotePort, Equals, 1433)
+	c.Assert(result.NameResolutionSucceeded, Equals, true)
+	c.Assert(result.Reachable(), Equals, false)
+
+	_, err = client.TestPort(context.Background(), "db01", 0)
+	c.Assert(err, ErrorMatches, "invalid port 0")
+}
+
+func (s *WinRMSuite) TestParseConnectionTest(c *C) {
+	result, err := parseConnectionTest(`{"ComputerName":"8.8.8.8","PingSucceeded":true,"RoundTripTimeMs":14}`)
+	c.Assert(err, IsNil)
+	c.Assert(result.Reachable(), Equals, true)
+	c.Assert(result.RoundTripTime, Equals, 14*time.Millisecond)
+
+	_, err = parseConnectionTest("Test-NetConnection : not recognized")
+	c.Assert(err, ErrorMatches, "unexpected connection test output.*")
+
+	c.Assert(connectionTestScript("db'01", "-Port 1433"), Matches,
+		`\$r = Test-NetConnection -ComputerName 'db''01' -Port 1433 -InformationLevel Detailed .*ConvertTo-Json -Compress`)
+}
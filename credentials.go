@@ -0,0 +1,95 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// CredentialProvider supplies the credentials of the client requests, so they can come from
+// a secret store or follow the rotation of a service account password
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (user, password string, err error)
+}
+
+// CredentialRefresher is implemented by the CredentialProviders caching credentials:
+// Refresh is called when the server rejects the credentials with a 401 status,
+// before the request is sent again once with the credentials provided next.
+type CredentialRefresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// CredentialProviderFunc adapts a function to the CredentialProvider interface
+type CredentialProviderFunc func(ctx context.Context) (user, password string, err error)
+
+// Credentials returns f(ctx)
+func (f CredentialProviderFunc) Credentials(ctx context.Context) (string, string, error) {
+	return f(ctx)
+}
+
+// credentials returns the username and password of a request
+func (c *Client) credentials(ctx context.Context) (string, string, error) {
+	if c.CredentialProvider == nil {
+		return c.username, c.password, nil
+	}
+	return c.CredentialProvider.Credentials(ctx)
+}
+
+// reauthenticate tells if the request rejected with err should be sent again once. It is the
+// case of a 401 status once the session was authenticated (expired ticket, rotated password...)
+// or when the credentials come from a provider. The provider credentials are refreshed,
+// and the transport authentication state dropped so that the handshake runs again.
+func (c *Client) reauthenticate(ctx context.Context, err error) bool {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	established := c.stats != nil && atomic.LoadInt32(&c.stats.authenticated) == 1
+	if c.CredentialProvider == nil && !established {
+		return false
+	}
+
+	if refresher, ok := c.CredentialProvider.(CredentialRefresher); ok {
+		if err := refresher.Refresh(ctx); err != nil {
+			return false
+		}
+	}
+	if reauthenticator, ok := c.http.(Reauthenticator); ok {
+		reauthenticator.Reauthenticate()
+	}
+
+	return true
+}
+
+// splitUsername returns the user and domain parts of username,
+// written either as DOMAIN\user or as the user@domain UPN
+func splitUsername(username string) (user, domain string) {
+	if i := strings.Index(username, `\`); i >= 0 {
+		return username[i+1:], username[:i]
+	}
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		return username[:i], username[i+1:]
+	}
+	return username, ""
+}
+
+// qualifiedUsername formats username as DOMAIN\user for NTLM and Basic, domain being
+// used when username doesn't carry one. A UPN is kept as is, both accepting it.
+func qualifiedUsername(username, domain string) string {
+	if domain == "" || strings.ContainsAny(username, `\@`) {
+		return username
+	}
+	return domain + `\` + username
+}
+
+// kerberosPrincipal returns the user and realm of username for Kerberos, domain
+// being used when username doesn't carry one. Realms are upper case by convention.
+func kerberosPrincipal(username, domain string) (user, realm string) {
+	user, realm = splitUsername(username)
+	if realm == "" {
+		realm = domain
+	}
+	return user, strings.ToUpper(realm)
+}
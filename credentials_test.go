@@ -0,0 +1,167 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestUsernameFormats(c *C) {
+	user, domain := splitUsername(`CORP\alice`)
+	c.Assert([]string{user, domain}, DeepEquals, []string{"alice", "CORP"})
+	user, domain = splitUsername("alice@corp.example.com")
+	c.Assert([]string{user, domain}, DeepEquals, []string{"alice", "corp.example.com"})
+	user, domain = splitUsername("alice")
+	c.Assert([]string{user, domain}, DeepEquals, []string{"alice", ""})
+
+	c.Assert(qualifiedUsername("alice", "CORP"), Equals, `CORP\alice`)
+	c.Assert(qualifiedUsername(`OTHER\alice`, "CORP"), Equals, `OTHER\alice`)
+	c.Assert(qualifiedUsername("alice@corp.example.com", "CORP"), Equals, "alice@corp.example.com")
+	c.Assert(qualifiedUsername("alice", ""), Equals, "alice")
+
+	user, realm := kerberosPrincipal("alice", "corp.example.com")
+	c.Assert([]string{user, realm}, DeepEquals, []string{"alice", "CORP.EXAMPLE.COM"})
+	user, realm = kerberosPrincipal("alice@other.example.com", "corp.example.com")
+	c.Assert([]string{user, realm}, DeepEquals, []string{"alice", "OTHER.EXAMPLE.COM"})
+}
+
+func (s *WinRMSuite) TestEndpointDomain(c *C) {
+	var username string
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, _, _ = r.BasicAuth()
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	endpoint.Domain = "CORP"
+	client, err := NewClient(endpoint, "alice", "secret")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(username, Equals, `CORP\alice`)
+
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return &clientRequest{ntlm: true} }).Build()
+	client, err = NewClientWithParameters(endpoint, "alice", "secret", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(username, Equals, `CORP\alice`)
+
+	client, err = NewClientWithDomain(endpoint, "OTHER", "alice", "secret", nil)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(username, Equals, `OTHER\alice`)
+}
+
+type rotatingProvider struct {
+	password  string
+	refreshed int
+}
+
+func (p *rotatingProvider) Credentials(ctx context.Context) (string, string, error) {
+	return "svc-deploy", p.password, nil
+}
+
+func (p *rotatingProvider) Refresh(ctx context.Context) error {
+	p.refreshed++
+	p.password = "rotated"
+	return nil
+}
+
+func (s *WinRMSuite) TestCredentialProvider(c *C) {
+	var passwords []string
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, _ := r.BasicAuth()
+		passwords = append(passwords, password)
+		if password != "rotated" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	provider := &rotatingProvider{password: "expired"}
+	params := NewParametersBuilder().CredentialProvider(provider).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "ignored", "ignored", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(passwords, DeepEquals, []string{"expired", "rotated"})
+	c.Assert(provider.refreshed, Equals, 1)
+
+	// without provider, a 401 is returned as is
+	client, err = NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "svc-deploy", "wrong")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	var httpErr *HTTPError
+	c.Assert(errors.As(err, &httpErr), Equals, true)
+	c.Assert(httpErr.StatusCode, Equals, http.StatusUnauthorized)
+	c.Assert(passwords, HasLen, 3)
+
+	failing := CredentialProviderFunc(func(ctx context.Context) (string, string, error) {
+		return "", "", errors.New("vault sealed")
+	})
+	client, err = NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "", "",
+		NewParametersBuilder().CredentialProvider(failing).Build())
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, ".*getting the credentials: vault sealed")
+}
+
+type countingTransport struct {
+	clientRequest
+	reauthenticated int
+}
+
+func (t *countingTransport) Reauthenticate() {
+	t.reauthenticated++
+	t.clientRequest.Reauthenticate()
+}
+
+func (s *WinRMSuite) TestReauthenticate(c *C) {
+	requests, expired := 0, false
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if expired {
+			expired = false
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	transport := &countingTransport{}
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return transport }).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+
+	// a 401 before the session is authenticated is a credentials error
+	expired = true
+	_, err = client.CreateShell()
+	c.Assert(err, NotNil)
+	c.Assert(requests, Equals, 1)
+	c.Assert(transport.reauthenticated, Equals, 0)
+
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(requests, Equals, 2)
+
+	// once authenticated, the handshake runs again and the request is retried
+	expired = true
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(requests, Equals, 4)
+	c.Assert(transport.reauthenticated, Equals, 1)
+}
@@ -0,0 +1,138 @@
+package winrm
+
+import (
+	"expvar"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DebugSnapshot is a point in time view of a client internals,
+// meant to introspect a stuck controller
+type DebugSnapshot struct {
+	Endpoint string
+	// OpenShells counts the shells created by the client and not closed yet
+	OpenShells int64
+	// InFlightCommands counts the commands started and not finished yet
+	InFlightCommands int64
+	// Pool is set when the client has a shell pool
+	Pool *ShellPoolStats `json:",omitempty"`
+	// QuotaRetries counts the operations attempts repeated because of the server quotas,
+	// QuotaWaiting the operations currently waiting for quota
+	QuotaRetries int64
+	QuotaWaiting int
+	// LastError is the last error returned by the transport, at LastErrorTime
+	LastError     string    `json:",omitempty"`
+	LastErrorTime time.Time `json:",omitempty"`
+}
+
+// clientStats holds the counters of DebugSnapshot,
+// shared by a client and the views derived with WithParams
+type clientStats struct {
+	openShells int64
+	inFlight   int64
+	// authenticated is set to 1 once a request succeeded
+	authenticated int32
+
+	// the counters of Stats
+	operations    int64
+	requests      int64
+	bytesSent     int64
+	bytesReceived int64
+	authFailures  int64
+	roundTrips    int64 // total duration of the requests, in nanoseconds
+
+	mutex         sync.Mutex
+	lastError     string
+	lastErrorTime time.Time
+}
+
+func (s *clientStats) setLastError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+}
+
+// Stats are the transport counters of a client, since its creation
+type Stats struct {
+	// Requests counts the SOAP requests sent, Retries those repeating a previous one
+	// (retry policy, re-authentication or quota)
+	Requests int64
+	Retries  int64
+	// BytesSent and BytesReceived count the bytes of the HTTP bodies
+	BytesSent     int64
+	BytesReceived int64
+	// AuthFailures counts the requests answered with a 401 status, the authentication having failed.
+	// The challenges of the NTLM and Kerberos handshakes, answered by their transport, aren't counted.
+	AuthFailures int64
+	// AverageRoundTrip is the average time between a request and its response
+	AverageRoundTrip time.Duration
+}
+
+// Stats returns the transport counters of the client, shared with the clients
+// derived with WithParams
+func (c *Client) Stats() Stats {
+	if c.stats == nil {
+		return Stats{}
+	}
+
+	stats := Stats{
+		Requests:      atomic.LoadInt64(&c.stats.requests),
+		BytesSent:     atomic.LoadInt64(&c.stats.bytesSent),
+		BytesReceived: atomic.LoadInt64(&c.stats.bytesReceived),
+		AuthFailures:  atomic.LoadInt64(&c.stats.authFailures),
+	}
+	if retries := stats.Requests - atomic.LoadInt64(&c.stats.operations); retries > 0 {
+		stats.Retries = retries
+	}
+	if stats.Requests > 0 {
+		stats.AverageRoundTrip = time.Duration(atomic.LoadInt64(&c.stats.roundTrips) / stats.Requests)
+	}
+
+	return stats
+}
+
+// countingBody counts the bytes read from a response body
+type countingBody struct {
+	io.ReadCloser
+	count *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.count, int64(n))
+	return n, err
+}
+
+// DebugSnapshot returns the current state of the client internals
+func (c *Client) DebugSnapshot() DebugSnapshot {
+	snapshot := DebugSnapshot{Endpoint: c.url}
+	if c.stats != nil {
+		snapshot.OpenShells = atomic.LoadInt64(&c.stats.openShells)
+		snapshot.InFlightCommands = atomic.LoadInt64(&c.stats.inFlight)
+		c.stats.mutex.Lock()
+		snapshot.LastError, snapshot.LastErrorTime = c.stats.lastError, c.stats.lastErrorTime
+		c.stats.mutex.Unlock()
+	}
+	if c.pool != nil {
+		stats := c.pool.stats()
+		snapshot.Pool = &stats
+	}
+	if c.quota != nil {
+		snapshot.QuotaRetries = atomic.LoadInt64(&c.quota.retries)
+		snapshot.QuotaWaiting = int(atomic.LoadInt32(&c.quota.waiting))
+	}
+
+	return snapshot
+}
+
+// PublishExpvar publishes the DebugSnapshot of the client under name in the expvar
+// variables, served as JSON on /debug/vars by the expvar package handler.
+// As with expvar.Publish, it panics if name is already used.
+func (c *Client) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.DebugSnapshot()
+	}))
+}
@@ -0,0 +1,121 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestDebugSnapshot(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	fail := false
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if fail {
+			return "", errors.New("connection refused")
+		}
+		if strings.Contains(message.String(), "transfer/Create") {
+			return createShellResponse, nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	shell, err := client.CreateShellWithContext(context.Background())
+	c.Assert(err, IsNil)
+	snapshot := client.DebugSnapshot()
+	c.Assert(snapshot.Endpoint, Equals, "http://localhost:5985/wsman")
+	c.Assert(snapshot.OpenShells, Equals, int64(1))
+	c.Assert(snapshot.Pool, IsNil)
+
+	c.Assert(shell.Close(), IsNil)
+	c.Assert(shell.Close(), IsNil)
+	c.Assert(client.DebugSnapshot().OpenShells, Equals, int64(0))
+
+	fail = true
+	_, err = client.CreateShellWithContext(context.Background())
+	c.Assert(err, NotNil)
+	snapshot = client.DebugSnapshot()
+	c.Assert(snapshot.LastError, Equals, "connection refused")
+	c.Assert(snapshot.LastErrorTime.IsZero(), Equals, false)
+
+	client.PublishExpvar("winrm-debug-test")
+	c.Assert(expvar.Get("winrm-debug-test").String(), Matches, `.*"Endpoint":"http://localhost:5985/wsman".*"LastError":"connection refused".*`)
+}
+
+func (s *WinRMSuite) TestDebugSnapshotInFlightCommands(c *C) {
+	client, err := NewClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	release := make(chan struct{})
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			<-release
+			return doneOutputResponse("", 0), nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	shell := client.NewShell("67A74734-DD32-4F10-89DE-49A060483810")
+	executed, err := shell.ExecuteWithContext(context.Background(), "dir")
+	c.Assert(err, IsNil)
+	attached, err := shell.AttachCommand(context.Background(), "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4")
+	c.Assert(err, IsNil)
+	c.Assert(client.DebugSnapshot().InFlightCommands, Equals, int64(2))
+
+	close(release)
+	executed.Wait()
+	attached.Wait()
+	c.Assert(client.DebugSnapshot().InFlightCommands, Equals, int64(0))
+}
+
+func (s *WinRMSuite) TestStats(c *C) {
+	challenge := true
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if challenge {
+			challenge = false
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	params := NewParametersBuilder().RetryPolicy(&RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		RetryOn:        func(err error) bool { return true },
+	}).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+	c.Assert(client.Stats(), DeepEquals, Stats{})
+
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	stats := client.Stats()
+	c.Assert(stats.Requests, Equals, int64(3))
+	c.Assert(stats.Retries, Equals, int64(1))
+	c.Assert(stats.AuthFailures, Equals, int64(1))
+	c.Assert(stats.BytesSent > 0, Equals, true)
+	c.Assert(stats.BytesReceived, Equals, int64(2*len(createShellResponse)))
+	c.Assert(stats.AverageRoundTrip > 0, Equals, true)
+}
@@ -2,6 +2,8 @@ package winrm
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,14 +12,24 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/bodgit/ntlmssp"
 	ntlmhttp "github.com/bodgit/ntlmssp/http"
 	"github.com/satendraraj/winrm/soap"
 )
 
+// Encryption provides a transport encrypting the SOAP messages with the NTLM or Kerberos session key,
+// for HTTP endpoints which don't allow unencrypted traffic (the Windows default).
+// Each request is authenticated on its connection before being sealed in the security context
+// of that handshake: the requests of a client are sent one at a time, so the concurrent commands
+// of its shells wait for each other's requests, output requests included.
 type Encryption struct {
+	// mutex serializes Post, the security context and the authenticated connection
+	// being shared by the handshake and the sealed request
+	mutex          sync.Mutex
 	ntlm           *ClientNTLM
+	kerberos       *ClientKerberos
 	protocol       string
 	protocolString []byte
 	httpClient     *http.Client
@@ -45,7 +57,8 @@ When using Encryption, there are three options available
     protocol: The protocol string used for the particular auth protocol
 
     The auth protocol used, will determine the wrapping and unwrapping method plus
-    the protocol string to use. Currently only NTLM is supported
+    the protocol string to use. Currently NTLM and Kerberos are supported, the Kerberos
+    one reading /etc/krb5.conf: NewKerberosEncryption takes other settings
 
     based on the python code from https://pypi.org/project/pywinrm/
 
@@ -63,49 +76,89 @@ func NewEncryption(protocol string) (*Encryption, error) {
 	case "ntlm":
 		encryption.protocolString = []byte("application/HTTP-SPNEGO-session-encrypted")
 		return encryption, nil
-		/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
+	case "kerberos":
+		return NewKerberosEncryption(&Settings{KrbConfig: "/etc/krb5.conf"}), nil
+		/* credssp is currently unimplemented, leave holder for future to keep in sync with python implementation
 		case "credssp":
 			encryption.protocolString = []byte("application/HTTP-CredSSP-session-encrypted")
-		case "kerberos": // kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
-			encryption.protocolString = []byte("application/HTTP-SPNEGO-session-encrypted")
 		*/
 	}
 
-	return nil, fmt.Errorf("Encryption for protocol '%s' not supported", protocol)
+	return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedEncryption, protocol)
+}
+
+// NewKerberosEncryption creates a transport authenticating with Kerberos, configured by settings,
+// which encrypts the messages with the key the server returns in the mutual authentication.
+// The username and password of the client are used when settings has no WinRMUsername.
+// Only the AES encryption types are supported.
+func NewKerberosEncryption(settings *Settings) *Encryption {
+	return &Encryption{
+		ntlm:           &ClientNTLM{},
+		kerberos:       NewClientKerberos(settings),
+		protocol:       "kerberos",
+		protocolString: []byte("application/HTTP-SPNEGO-session-encrypted"),
+	}
 }
 
 func (e *Encryption) Transport(endpoint *Endpoint) error {
-	e.httpClient = &http.Client{}
+	if e.kerberos != nil {
+		return e.kerberos.Transport(endpoint)
+	}
+	e.httpClient = &http.Client{CheckRedirect: noRedirect}
 	return e.ntlm.Transport(endpoint)
 }
 
-func (e *Encryption) Post(client *Client, message *soap.SoapMessage) (string, error) {
-	var userName, domain string
-	if strings.Contains(client.username, "@") {
-		parts := strings.Split(client.username, "@")
-		domain = parts[1]
-		userName = parts[0]
-	} else if strings.Contains(client.username, "\\") {
-		parts := strings.Split(client.username, "\\")
-		domain = parts[0]
-		userName = parts[1]
-	} else {
-		userName = client.username
+// Reauthenticate closes the connections authenticated by the NTLM handshake,
+// dropping the Kerberos tickets as well
+func (e *Encryption) Reauthenticate() {
+	if e.kerberos != nil {
+		e.kerberos.Reauthenticate()
+		return
+	}
+	e.CloseIdleConnections()
+}
+
+// CloseIdleConnections closes the connections kept open for the next requests
+func (e *Encryption) CloseIdleConnections() {
+	if e.kerberos != nil {
+		e.kerberos.CloseIdleConnections()
+		return
+	}
+	if e.httpClient != nil {
+		e.httpClient.CloseIdleConnections()
+	}
+	e.ntlm.CloseIdleConnections()
+}
+
+func (e *Encryption) Post(ctx context.Context, client *Client, message *soap.SoapMessage) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.kerberos != nil {
+		return e.postKerberos(ctx, client, message)
+	}
+
+	username, password, err := client.credentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting the credentials: %w", err)
+	}
+	userName, domain := splitUsername(username)
+	if domain == "" {
+		domain = client.domain
 	}
 
-	e.ntlmClient, _ = ntlmssp.NewClient(ntlmssp.SetUserInfo(userName, client.password), ntlmssp.SetDomain(domain), ntlmssp.SetVersion(ntlmssp.DefaultVersion()))
+	e.ntlmClient, _ = ntlmssp.NewClient(ntlmssp.SetUserInfo(userName, password), ntlmssp.SetDomain(domain), ntlmssp.SetVersion(ntlmssp.DefaultVersion()))
 	e.ntlmhttp, _ = ntlmhttp.NewClient(e.httpClient, e.ntlmClient)
 
-	var err error
-	if err = e.PrepareRequest(client, client.url); err == nil {
-		return e.PrepareEncryptedRequest(client, client.url, []byte(message.String()))
+	if err = e.PrepareRequest(ctx, client, client.url); err == nil {
+		return e.PrepareEncryptedRequest(ctx, client, client.url, []byte(message.String()))
 	} else {
-		return e.ntlm.Post(client, message)
+		return e.ntlm.Post(ctx, client, message)
 	}
 }
 
-func (e *Encryption) PrepareRequest(client *Client, endpoint string) error {
-	req, err := http.NewRequest("POST", endpoint, nil)
+func (e *Encryption) PrepareRequest(ctx context.Context, client *Client, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -114,6 +167,9 @@ func (e *Encryption) PrepareRequest(client *Client, endpoint string) error {
 	req.Header.Set("Content-Length", "0")
 	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
 	req.Header.Set("Connection", "Keep-Alive")
+	if err := client.decorateRequest(req); err != nil {
+		return err
+	}
 
 	resp, err := e.ntlmhttp.Do(req)
 	if err != nil {
@@ -129,12 +185,84 @@ func (e *Encryption) PrepareRequest(client *Client, endpoint string) error {
 	}
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("http error %d", resp.StatusCode)
+		return &HTTPError{StatusCode: resp.StatusCode}
 	}
 
 	return nil
 }
 
+// postKerberos authenticates a connection with Kerberos, then sends message on it
+// sealed in the security context set up by the mutual authentication
+func (e *Encryption) postKerberos(ctx context.Context, client *Client, message *soap.SoapMessage) (string, error) {
+	username, realm, password, err := e.kerberos.principal(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	session, err := e.kerberos.acquireSession(username, realm, password)
+	if err != nil {
+		return "", err
+	}
+	defer e.kerberos.releaseSession(session)
+	httpClient := e.kerberos.httpClient()
+
+	endpoint, err := url.Parse(client.url)
+	if err != nil {
+		return "", err
+	}
+	token, security, err := newKerberosContext(session.client, e.kerberos.servicePrincipal(endpoint))
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", client.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "WinRM client")
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	req.Header.Set("Connection", "Keep-Alive")
+	req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	if err := client.decorateRequest(req); err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unknown error %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &HTTPError{StatusCode: resp.StatusCode}
+	}
+	if err := security.accept(resp); err != nil {
+		return "", err
+	}
+
+	plain := []byte(message.String())
+	signature, sealed, err := security.wrap(plain)
+	if err != nil {
+		return "", err
+	}
+	req, err = e.encryptedRequest(ctx, client, client.url, "multipart/encrypted", e.encryptedPart(plain, sealedStream(signature, sealed)))
+	if err != nil {
+		return "", err
+	}
+	resp, err = httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unknown error %w", err)
+	}
+
+	body, err := e.parseEncryptedResponse(resp, func(encryptedData []byte) ([]byte, error) {
+		signature, sealed, err := splitSealedStream(encryptedData)
+		if err != nil {
+			return nil, err
+		}
+		return security.unwrap(signature, sealed)
+	})
+	return string(body), err
+}
+
 /*
 Creates a prepared request to send to the server with an encrypted message
 and correct headers
@@ -143,7 +271,7 @@ and correct headers
 :param message: The unencrypted message to send to the server
 :return: A prepared request that has an decrypted message
 */
-func (e *Encryption) PrepareEncryptedRequest(client *Client, endpoint string, message []byte) (string, error) {
+func (e *Encryption) PrepareEncryptedRequest(ctx context.Context, client *Client, endpoint string, message []byte) (string, error) {
 	url, err := url.Parse(endpoint)
 	if err != nil {
 		return "", err
@@ -169,19 +297,11 @@ func (e *Encryption) PrepareEncryptedRequest(client *Client, endpoint string, me
 		encrypted_message = e.encryptMessage(message, host)
 	}
 
-	encrypted_message = append(encrypted_message, []byte(mimeBoundary)...)
-	encrypted_message = append(encrypted_message, []byte("--\r\n")...)
-
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(encrypted_message))
+	req, err := e.encryptedRequest(ctx, client, endpoint, content_type, encrypted_message)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set("User-Agent", "WinRM client")
-	req.Header.Set("Connection", "Keep-Alive")
-	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(encrypted_message)))
-	req.Header.Set("Content-Type", fmt.Sprintf(`%s;protocol="%s";boundary="Encrypted Boundary"`, content_type, e.protocolString))
-
 	resp, err := e.ntlmhttp.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("unknown error %w", err)
@@ -192,6 +312,26 @@ func (e *Encryption) PrepareEncryptedRequest(client *Client, endpoint string, me
 	return string(body), err
 }
 
+// encryptedRequest creates the request posting the encrypted parts to endpoint
+func (e *Encryption) encryptedRequest(ctx context.Context, client *Client, endpoint, content_type string, encrypted_message []byte) (*http.Request, error) {
+	encrypted_message = append(encrypted_message, []byte(mimeBoundary)...)
+	encrypted_message = append(encrypted_message, []byte("--\r\n")...)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(encrypted_message))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "WinRM client")
+	req.Header.Set("Connection", "Keep-Alive")
+	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(encrypted_message)))
+	req.Header.Set("Content-Type", fmt.Sprintf(`%s;protocol="%s";boundary="Encrypted Boundary"`, content_type, e.protocolString))
+	if err := client.decorateRequest(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
 /*
 Takes in the encrypted response from the server and decrypts it
 
@@ -199,9 +339,17 @@ Takes in the encrypted response from the server and decrypts it
 :return: The unencrypted message from the server
 */
 func (e *Encryption) ParseEncryptedResponse(response *http.Response) ([]byte, error) {
+	host := response.Request.URL.Hostname()
+	return e.parseEncryptedResponse(response, func(encryptedData []byte) ([]byte, error) {
+		return e.decryptMessage(encryptedData, host)
+	})
+}
+
+// parseEncryptedResponse returns the body of response, its encrypted parts being decrypted with decrypt
+func (e *Encryption) parseEncryptedResponse(response *http.Response, decrypt func([]byte) ([]byte, error)) ([]byte, error) {
 	contentType := response.Header.Get("Content-Type")
 	if strings.Contains(contentType, fmt.Sprintf(`protocol="%s"`, e.protocolString)) {
-		return e.decryptResponse(response, response.Request.URL.Hostname())
+		return e.decryptResponse(response, decrypt)
 	}
 	body, err := io.ReadAll(response.Body)
 	response.Body.Close()
@@ -213,7 +361,11 @@ func (e *Encryption) ParseEncryptedResponse(response *http.Response) ([]byte, er
 
 func (e *Encryption) encryptMessage(message []byte, host string) []byte {
 	encryptedStream, _ := e.buildMessage(message, host)
+	return e.encryptedPart(message, encryptedStream)
+}
 
+// encryptedPart returns the MIME part of message, encrypted as encryptedStream
+func (e *Encryption) encryptedPart(message, encryptedStream []byte) []byte {
 	messagePayload := bytes.Join([][]byte{
 		[]byte(mimeBoundary),
 		[]byte("\r\n"),
@@ -242,7 +394,7 @@ func deleteEmpty(b [][]byte) [][]byte {
 // because in the header we have "\tContent-Type: application/HTTP-SPNEGO-session-encrypted\r\n"
 // on call to textproto.ReadMIMEHeader
 // because of "The first line cannot start with a leading space."
-func (e *Encryption) decryptResponse(response *http.Response, host string) ([]byte, error) {
+func (e *Encryption) decryptResponse(response *http.Response, decrypt func([]byte) ([]byte, error)) ([]byte, error) {
 	body, _ := io.ReadAll(response.Body)
 	parts := deleteEmpty(bytes.Split(body, []byte(fmt.Sprintf("%s\r\n", mimeBoundary))))
 	var message []byte
@@ -262,7 +414,7 @@ func (e *Encryption) decryptResponse(response *http.Response, host string) ([]by
 			payload = payload[:len(payload)-boundaryLength-4]
 		}
 		encryptedData := bytes.ReplaceAll(payload, []byte("\tContent-Type: application/octet-stream\r\n"), []byte{})
-		decryptedMessage, err := e.decryptMessage(encryptedData, host)
+		decryptedMessage, err := decrypt(encryptedData)
 		if err != nil {
 			return nil, err
 		}
@@ -282,14 +434,12 @@ func (e *Encryption) decryptMessage(encryptedData []byte, host string) ([]byte,
 	switch e.protocol {
 	case "ntlm":
 		return e.decryptNtlmMessage(encryptedData, host)
-		/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
+		/* credssp is currently unimplemented, leave holder for future to keep in sync with python implementation
 		case "credssp":
 			return e.decryptCredsspMessage(encryptedData, host)
-		case "kerberos":
-			return e.decryptKerberosMessage(encryptedData, host)
 		*/
 	default:
-		return nil, errors.New("Encryption for protocol " + e.protocol + " not supported")
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedEncryption, e.protocol)
 	}
 }
 
@@ -305,7 +455,7 @@ func (e *Encryption) decryptNtlmMessage(encryptedData []byte, host string) ([]by
 	return message, nil
 }
 
-/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
+/* credssp is currently unimplemented, leave holder for future to keep in sync with python implementation
 func (e *Encryption) decryptCredsspMessage(encryptedData []byte, host string) ([]byte, error) {
 	// // TODO
 	// encryptedMessage := encryptedData[4:]
@@ -321,34 +471,18 @@ func (e *Encryption) decryptCredsspMessage(encryptedData []byte, host string) ([
 	// }
 	// return message, nil
 }
-
-func (enc *Encryption) decryptKerberosMessage(encryptedData []byte, host string) ([]byte, error) {
-	// //TODO
-	// signatureLength := binary.LittleEndian.Uint32(encryptedData[0:4])
-	// signature := encryptedData[4 : 4+signatureLength]
-	// encryptedMessage := encryptedData[4+signatureLength:]
-
-	// message, err := enc.session.Auth.UnwrapWinrm(host, encryptedMessage, signature)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// return message, nil
-}
 */
 
 func (e *Encryption) buildMessage(encryptedData []byte, host string) ([]byte, error) {
 	switch e.protocol {
 	case "ntlm":
 		return e.buildNTLMMessage(encryptedData, host)
-		/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
+		/* credssp is currently unimplemented, leave holder for future to keep in sync with python implementation
 		case "credssp":
 			return e.buildCredSSPMessage(encryptedData, host)
-		case "kerberos":
-			return e.buildKerberosMessage(encryptedData, host)
 		*/
 	default:
-		return nil, errors.New("Encryption for protocol " + e.protocol + " not supported")
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedEncryption, e.protocol)
 	}
 }
 
@@ -372,7 +506,26 @@ func (enc *Encryption) buildNTLMMessage(message []byte, host string) ([]byte, er
 	return buf.Bytes(), nil
 }
 
-/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
+// sealedStream returns the encrypted stream of a part: the length of signature, signature and sealed
+func sealedStream(signature, sealed []byte) []byte {
+	stream := make([]byte, 4, 4+len(signature)+len(sealed))
+	binary.LittleEndian.PutUint32(stream, uint32(len(signature)))
+	return append(append(stream, signature...), sealed...)
+}
+
+// splitSealedStream returns the signature and the sealed message of the encrypted stream of a part
+func splitSealedStream(stream []byte) ([]byte, []byte, error) {
+	if len(stream) < 4 {
+		return nil, nil, errors.New("encrypted part too short")
+	}
+	signatureLength := int(binary.LittleEndian.Uint32(stream))
+	if len(stream)-4 < signatureLength {
+		return nil, nil, errors.New("encrypted part too short")
+	}
+	return stream[4 : 4+signatureLength], stream[4+signatureLength:], nil
+}
+
+/* credssp is currently unimplemented, leave holder for future to keep in sync with python implementation
 func (e *Encryption) buildCredSSPMessage(message []byte, host string) ([]byte, error) {
 	// //TODO
 	// context := e.session.Auth.Contexts[host]
@@ -387,16 +540,6 @@ func (e *Encryption) buildCredSSPMessage(message []byte, host string) ([]byte, e
 	// return append(trailer, sealedMessage...), nil
 }
 
-func (e *Encryption) buildKerberosMessage(message []byte, host string) ([]byte, error) {
-	// //TODO
-	// sealedMessage, signature := e.session.Auth.WrapWinrm(host, message)
-
-	// signatureLength := make([]byte, 4)
-	// binary.LittleEndian.PutUint32(signatureLength, uint32(len(signature)))
-
-	// return append(append(signatureLength, signature...), sealedMessage...), nil
-}
-
 func (e *Encryption) getCredSSPTrailerLength(messageLength int, cipherSuite string) int {
 	var trailerLength int
 
@@ -1,7 +1,20 @@
 package winrm
 
 import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,16 +27,85 @@ type Endpoint struct {
 	// winrm ports (http:5985, https:5986).Versions
 	// of winrm can be customized to listen on other ports
 	Port int
+	// URL path of the WinRM listener, with an optional query string
+	// (gateways often expose it as /hosts/<name>/wsman), defaults to /wsman
+	Path string
+	// Windows domain of the client credentials, applied by each transport in the form it
+	// expects: DOMAIN\user for NTLM and Basic, user@REALM for Kerberos.
+	// It is ignored when the username already has a domain.
+	Domain string
+	// SPN overrides the service principal name of the Kerberos tickets, HTTP/<Host> by default,
+	// when the endpoint is reached through a CNAME or a load balancer.
+	// The SPN of the Kerberos transport settings takes precedence.
+	SPN string
 	// set the flag true for https connections
 	HTTPS bool
 	// set the flag true for skipping ssl verifications
 	Insecure bool
 	// if set, used to verify the hostname on the returned certificate
 	TLSServerName string
+	// TLSMinVersion and TLSMaxVersion bound the TLS versions (tls.VersionTLS12...) negotiated
+	// with the endpoint, the crypto/tls defaults being used when zero
+	TLSMinVersion uint16
+	TLSMaxVersion uint16
+	// TLSCipherSuites restricts the cipher suites of TLS 1.2 and earlier, for FIPS policies.
+	// The TLS 1.3 cipher suites aren't configurable.
+	TLSCipherSuites []uint16
+	// PinnedCertSHA256 lists the accepted SHA-256 fingerprints, in hexadecimal with or without
+	// colons, of the server leaf certificate or of its public key (SPKI). When set, the
+	// certificate is trusted on its fingerprint instead of a CA chain, which suits the
+	// self-signed certificates of WinRM listeners better than Insecure.
+	PinnedCertSHA256 []string
+	// TLSSessionCache keeps the TLS sessions so that the next connections resume them with
+	// an abbreviated handshake. Sharing one cache, like tls.NewLRUClientSessionCache(0),
+	// between the endpoints of short-lived clients saves a full handshake on each new client.
+	// Without it, the sessions aren't resumed.
+	TLSSessionCache tls.ClientSessionCache
 	// pointer pem certs, and key
+	// Cert and Key are used by ClientAuthRequest, and presented
+	// during the TLS handshake by the credential based transports
 	CACert []byte // cert auth to intdetify the server cert
 	Key    []byte // public key for client auth connections
 	Cert   []byte // cert for client auth connections
+	// CACerts are additional PEM bundles trusted along with CACert
+	CACerts [][]byte
+	// SystemCertPool starts the trusted CAs from the system ones, CACert and CACerts being
+	// added to them instead of replacing them, for servers issued by public and internal CAs
+	SystemCertPool bool
+	// TLSCertificate is an already loaded client certificate, used instead of Cert and Key,
+	// whose PrivateKey can be any crypto.Signer (e.g. held by an OS keychain)
+	TLSCertificate *tls.Certificate
+	// Signer holds the private key of the Cert certificate chain instead of Key, so a key
+	// stored in a PKCS#11 token or smartcard can be used without ever leaving it
+	Signer crypto.Signer
+	// ProxyURL is the proxy the requests go through instead of the one of the environment
+	// (HTTP_PROXY, HTTPS_PROXY, NO_PROXY). It can be an http:// or an https:// proxy, the latter
+	// being verified like the endpoint. Its user info, or ProxyUsername and ProxyPassword
+	// when set, authenticate to the proxy with Basic.
+	ProxyURL      *url.URL
+	ProxyUsername string
+	ProxyPassword string
+	// ProxyConnectHeader holds the headers added to the CONNECT requests tunneling the HTTPS
+	// connections through the proxy, and GetProxyConnectHeader, when set, returns them for
+	// each tunnel instead, e.g. with a fresh bearer token in Proxy-Authorization.
+	// Plain HTTP requests aren't tunneled, so they don't get them.
+	ProxyConnectHeader    http.Header
+	GetProxyConnectHeader func(ctx context.Context, proxyURL *url.URL, target string) (http.Header, error)
+	// MaxIdleConnsPerHost is the number of idle connections kept open for the next requests,
+	// 2 (http.DefaultMaxIdleConnsPerHost) when zero
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is the time an idle connection is kept open, 90 seconds when zero
+	IdleConnTimeout time.Duration
+	// HostAddresses maps host names to the IP addresses (or other host names) connected to
+	// instead, like a hosts file, for machines not registered in the system DNS yet.
+	// The certificate is still verified against the original name.
+	HostAddresses map[string]string
+	// Resolver looks up the host names missing from HostAddresses instead of the system resolver
+	Resolver *net.Resolver
+	// ConnectTimeout bounds the establishment of the TCP connections, 30 seconds when zero
+	ConnectTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshakes, 10 seconds when zero
+	TLSHandshakeTimeout time.Duration
 	// duration timeout for the underling tcp conn(http/https base protocol)
 	// if the time exceeds the connection is cloded/timeouts
 	Timeout time.Duration
@@ -37,13 +119,255 @@ func (ep *Endpoint) url() string {
 		scheme = "http"
 	}
 
-	return fmt.Sprintf("%s://%s:%d/wsman", scheme, ep.Host, ep.Port)
+	path := ep.Path
+	if path == "" {
+		path = "/wsman"
+	} else if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	// IPv6 literals are bracketed, and their zone escaped
+	host := net.JoinHostPort(strings.Replace(ep.hostname(), "%", "%25", 1), strconv.Itoa(ep.Port))
+
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
+
+// hostname returns Host without the brackets of an IPv6 literal
+func (ep *Endpoint) hostname() string {
+	return strings.TrimSuffix(strings.TrimPrefix(ep.Host, "["), "]")
+}
+
+// tlsServerName returns the name the server certificate is verified against: TLSServerName,
+// or the host without the zone of an IPv6 literal, which crypto/tls wouldn't recognize as an address
+func (ep *Endpoint) tlsServerName() string {
+	if ep.TLSServerName != "" {
+		return ep.TLSServerName
+	}
+	host := ep.hostname()
+	if i := strings.LastIndex(host, "%"); i >= 0 && strings.Contains(host, ":") {
+		return host[:i]
+	}
+	return ""
+}
+
+// applyTLSSettings sets the TLS versions and cipher suites of the endpoint on config
+func (ep *Endpoint) applyTLSSettings(config *tls.Config) error {
+	if ep.TLSMinVersion != 0 && ep.TLSMaxVersion != 0 && ep.TLSMinVersion > ep.TLSMaxVersion {
+		return fmt.Errorf("TLS minimum version %s is above the maximum version %s",
+			tls.VersionName(ep.TLSMinVersion), tls.VersionName(ep.TLSMaxVersion))
+	}
+	if ep.TLSMinVersion != 0 {
+		config.MinVersion = ep.TLSMinVersion
+	}
+	if ep.TLSMaxVersion != 0 {
+		config.MaxVersion = ep.TLSMaxVersion
+	}
+	if len(ep.TLSCipherSuites) > 0 {
+		config.CipherSuites = ep.TLSCipherSuites
+	}
+	config.ClientSessionCache = ep.TLSSessionCache
+	return ep.applyCertificatePins(config)
+}
+
+// applyCertificatePins replaces the CA verification of config by the check of
+// PinnedCertSHA256. VerifyConnection is used as it also runs on resumed sessions.
+func (ep *Endpoint) applyCertificatePins(config *tls.Config) error {
+	if len(ep.PinnedCertSHA256) == 0 {
+		return nil
+	}
+
+	pins := make(map[[sha256.Size]byte]bool, len(ep.PinnedCertSHA256))
+	for _, pin := range ep.PinnedCertSHA256 {
+		b, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid SHA-256 certificate fingerprint %q", pin)
+		}
+		pins[[sha256.Size]byte(b)] = true
+	}
+
+	//nolint:gosec
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("the server presented no certificate")
+		}
+		leaf := state.PeerCertificates[0]
+		if pins[sha256.Sum256(leaf.Raw)] || pins[sha256.Sum256(leaf.RawSubjectPublicKeyInfo)] {
+			return nil
+		}
+		return fmt.Errorf("the server certificate %s (SHA-256 %x) isn't pinned", leaf.Subject, sha256.Sum256(leaf.Raw))
+	}
+
+	return nil
+}
+
+// rootCAs returns the CAs the server certificate is verified against, nil for the system ones
+func (ep *Endpoint) rootCAs() (*x509.CertPool, error) {
+	bundles := ep.CACerts
+	if len(ep.CACert) > 0 {
+		bundles = append([][]byte{ep.CACert}, bundles...)
+	}
+	if len(bundles) == 0 {
+		return nil, nil
+	}
+
+	certPool := x509.NewCertPool()
+	if ep.SystemCertPool {
+		var err error
+		if certPool, err = x509.SystemCertPool(); err != nil {
+			return nil, fmt.Errorf("unable to load the system certificates: %w", err)
+		}
+	}
+	for _, bundle := range bundles {
+		if !certPool.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("unable to read certificates")
+		}
+	}
+
+	return certPool, nil
+}
+
+const (
+	defaultConnectTimeout      = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+func (ep *Endpoint) connectTimeout() time.Duration {
+	if ep.ConnectTimeout == 0 {
+		return defaultConnectTimeout
+	}
+	return ep.ConnectTimeout
+}
+
+func (ep *Endpoint) tlsHandshakeTimeout() time.Duration {
+	if ep.TLSHandshakeTimeout == 0 {
+		return defaultTLSHandshakeTimeout
+	}
+	return ep.TLSHandshakeTimeout
+}
+
+// dialContext returns the function opening the connections of the transports: dialContext,
+// dial ignoring the context, or a dialer with the endpoint connect timeout, connecting to
+// the addresses of HostAddresses and Resolver when set
+func (ep *Endpoint) dialContext(dialContext func(ctx context.Context, network, addr string) (net.Conn, error),
+	dial func(network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch {
+	case dialContext != nil:
+	case dial != nil:
+		dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(network, addr)
+		}
+	default:
+		dialContext = (&net.Dialer{
+			Timeout:   ep.connectTimeout(),
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if len(ep.HostAddresses) == 0 && ep.Resolver == nil {
+		return dialContext
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dialContext(ctx, network, addr)
+		}
+		if address, ok := ep.HostAddresses[host]; ok {
+			host = address
+		}
+		if ep.Resolver == nil || net.ParseIP(host) != nil {
+			return dialContext(ctx, network, net.JoinHostPort(host, port))
+		}
+
+		addresses, err := ep.Resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			var conn net.Conn
+			if conn, err = dialContext(ctx, network, net.JoinHostPort(address, port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// defaultIdleConnTimeout is the IdleConnTimeout of http.DefaultTransport
+const defaultIdleConnTimeout = 90 * time.Second
+
+func (ep *Endpoint) idleConnTimeout() time.Duration {
+	if ep.IdleConnTimeout == 0 {
+		return defaultIdleConnTimeout
+	}
+	return ep.IdleConnTimeout
+}
+
+// proxy returns the proxy function of the transports, fallback when ProxyURL isn't set
+func (ep *Endpoint) proxy(fallback func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	if ep.ProxyURL == nil {
+		return fallback
+	}
+
+	proxyURL := *ep.ProxyURL
+	if ep.ProxyUsername != "" {
+		proxyURL.User = url.UserPassword(ep.ProxyUsername, ep.ProxyPassword)
+	}
+
+	return http.ProxyURL(&proxyURL)
+}
+
+// clientCertificate returns the client certificate of the endpoint, nil if none is set
+func (ep *Endpoint) clientCertificate() (*tls.Certificate, error) {
+	if ep.TLSCertificate != nil {
+		return ep.TLSCertificate, nil
+	}
+	if ep.Signer != nil {
+		return signerCertificate(ep.Cert, ep.Signer)
+	}
+	if len(ep.Cert) == 0 || len(ep.Key) == 0 {
+		return nil, nil
+	}
+
+	cert, err := tls.X509KeyPair(ep.Cert, ep.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
 }
 
+// signerCertificate builds the certificate of the PEM certPEM chain whose private key is signer
+func signerCertificate(certPEM []byte, signer crypto.Signer) (*tls.Certificate, error) {
+	cert := &tls.Certificate{PrivateKey: signer}
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("no certificate found for the signer")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(leaf.PublicKey) {
+		return nil, errors.New("the signer public key doesn't match the certificate")
+	}
+	cert.Leaf = leaf
+
+	return cert, nil
+}
+
+// errNoClientCertificate is returned by the certificate authentication without certificate
+var errNoClientCertificate = errors.New("certificate authentication needs a client certificate")
+
 // NewEndpoint returns new pointer to struct Endpoint, with a default 60s response header timeout
 func NewEndpoint(host string, port int, https bool, insecure bool, Cacert, cert, key []byte, timeout time.Duration) *Endpoint {
 	endpoint := &Endpoint{
-		Host:     host,
+		Host:     strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"),
 		Port:     port,
 		HTTPS:    https,
 		Insecure: insecure,
@@ -61,3 +385,48 @@ func NewEndpoint(host string, port int, https bool, insecure bool, Cacert, cert,
 
 	return endpoint
 }
+
+// NewEndpointFromURL returns the endpoint of a WinRM listener URL like https://host:5986/wsman.
+// The port defaults to 5985 for http and 5986 for https, the path to /wsman;
+// a query string is kept with the path. The other settings are the NewEndpoint defaults.
+func NewEndpointFromURL(rawURL string) (*Endpoint, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint URL: %w", err)
+	}
+
+	var https bool
+	var port int
+	switch strings.ToLower(parsed.Scheme) {
+	case "http":
+		port = 5985
+	case "https":
+		https, port = true, 5986
+	default:
+		return nil, fmt.Errorf("invalid endpoint URL %q: the scheme must be http or https", rawURL)
+	}
+	if parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid endpoint URL %q: missing host", rawURL)
+	}
+	if parsed.User != nil {
+		return nil, fmt.Errorf("invalid endpoint URL %q: credentials aren't taken from the URL", rawURL)
+	}
+	if p := parsed.Port(); p != "" {
+		if port, err = strconv.Atoi(p); err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid endpoint URL %q: invalid port %s", rawURL, p)
+		}
+	}
+
+	endpoint := NewEndpoint(parsed.Hostname(), port, https, false, nil, nil, nil, 0)
+	if parsed.Path != "" && parsed.Path != "/" {
+		endpoint.Path = parsed.EscapedPath()
+	}
+	if parsed.RawQuery != "" {
+		if endpoint.Path == "" {
+			endpoint.Path = "/wsman"
+		}
+		endpoint.Path += "?" + parsed.RawQuery
+	}
+
+	return endpoint, nil
+}
@@ -1,6 +1,22 @@
 package winrm
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	. "gopkg.in/check.v1"
@@ -25,3 +41,440 @@ func (s *WinRMSuite) TestEndpointWithTimeout(c *C) {
 	endpoint := NewEndpoint("test", 5585, false, false, nil, nil, nil, 120*time.Second)
 	c.Assert(endpoint.Timeout, Equals, 120*time.Second)
 }
+
+func (s *WinRMSuite) TestEndpointUrlCustomPath(c *C) {
+	endpoint := &Endpoint{Host: "gateway", Port: 443, HTTPS: true, Path: "/hosts/web01/wsman"}
+	c.Assert(endpoint.url(), Equals, "https://gateway:443/hosts/web01/wsman")
+
+	endpoint = &Endpoint{Host: "gateway", Port: 8080, Path: "wsman"}
+	c.Assert(endpoint.url(), Equals, "http://gateway:8080/wsman")
+}
+
+func (s *WinRMSuite) TestEndpointUrlPathWithQuery(c *C) {
+	endpoint := &Endpoint{Host: "gateway", Port: 443, HTTPS: true, Path: "/hosts/web01/wsman?token=a%2Fb&x=1"}
+	c.Assert(endpoint.url(), Equals, "https://gateway:443/hosts/web01/wsman?token=a%2Fb&x=1")
+}
+
+func (s *WinRMSuite) TestEndpointClientCertificate(c *C) {
+	endpoint := NewEndpoint("test", 5986, true, false, nil, nil, nil, 0)
+	clientCert, err := endpoint.clientCertificate()
+	c.Assert(err, IsNil)
+	c.Assert(clientCert, IsNil)
+	c.Assert((&ClientAuthRequest{}).Transport(endpoint), Equals, errNoClientCertificate)
+
+	loaded, err := tls.X509KeyPair([]byte(cert), []byte(key))
+	c.Assert(err, IsNil)
+	endpoint.TLSCertificate = &loaded
+	endpoint.Cert, endpoint.Key = []byte("ignored"), []byte("ignored")
+	clientCert, err = endpoint.clientCertificate()
+	c.Assert(err, IsNil)
+	c.Assert(clientCert, Equals, &loaded)
+
+	transport := &ClientAuthRequest{}
+	c.Assert(transport.Transport(endpoint), IsNil)
+	c.Assert(transport.transport.(*http.Transport).TLSClientConfig.Certificates, HasLen, 1)
+}
+
+// tokenSigner hides the private key type like a PKCS#11 signer would
+type tokenSigner struct {
+	signer crypto.Signer
+	signed int
+}
+
+func (t *tokenSigner) Public() crypto.PublicKey {
+	return t.signer.Public()
+}
+
+func (t *tokenSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	t.signed++
+	return t.signer.Sign(rand, digest, opts)
+}
+
+func (s *WinRMSuite) TestEndpointSigner(c *C) {
+	loaded, err := tls.X509KeyPair([]byte(cert), []byte(key))
+	c.Assert(err, IsNil)
+	signer := &tokenSigner{signer: loaded.PrivateKey.(crypto.Signer)}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.TLS.PeerCertificates, HasLen, 1)
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	endpoint := NewEndpoint(host, port, true, true, nil, []byte(cert), nil, 0)
+	endpoint.Signer = signer
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return &ClientAuthRequest{} }).Build()
+	client, err := NewClientWithParameters(endpoint, "", "", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(signer.signed, Equals, 1)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	endpoint.Signer = other
+	_, err = endpoint.clientCertificate()
+	c.Assert(err, ErrorMatches, "the signer public key doesn't match the certificate")
+}
+
+func (s *WinRMSuite) TestEndpointProxyURL(c *C) {
+	var authorizations, targets []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Proxy-Authorization"))
+		targets = append(targets, r.URL.String())
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	c.Assert(err, IsNil)
+	proxyURL.User = url.UserPassword("embedded", "secret")
+
+	endpoint := NewEndpoint("srv-win", 5985, false, false, nil, nil, nil, 0)
+	endpoint.ProxyURL = proxyURL
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	endpoint.ProxyUsername, endpoint.ProxyPassword = "proxy-user", "proxy-password"
+	client, err = NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	c.Assert(targets, DeepEquals, []string{"http://srv-win:5985/wsman", "http://srv-win:5985/wsman"})
+	c.Assert(authorizations, DeepEquals, []string{"Basic ZW1iZWRkZWQ6c2VjcmV0", "Basic cHJveHktdXNlcjpwcm94eS1wYXNzd29yZA=="})
+	c.Assert(proxyURL.User.Username(), Equals, "embedded")
+
+	// the client certificate transport goes through the proxy too
+	endpoint.Cert, endpoint.Key = []byte(cert), []byte(key)
+	transport := &ClientAuthRequest{}
+	c.Assert(transport.Transport(endpoint), IsNil)
+	req, err := http.NewRequest("POST", endpoint.url(), nil)
+	c.Assert(err, IsNil)
+	used, err := transport.transport.(*http.Transport).Proxy(req)
+	c.Assert(err, IsNil)
+	c.Assert(used.Host, Equals, proxyURL.Host)
+	c.Assert(used.User.Username(), Equals, "proxy-user")
+}
+
+func (s *WinRMSuite) TestEndpointTLSSettings(c *C) {
+	endpoint := NewEndpoint("test", 5986, true, true, nil, []byte(cert), []byte(key), 0)
+	endpoint.TLSMinVersion = tls.VersionTLS12
+	endpoint.TLSMaxVersion = tls.VersionTLS13
+	endpoint.TLSCipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+
+	basic := &clientRequest{}
+	c.Assert(basic.Transport(endpoint), IsNil)
+	auth := &ClientAuthRequest{}
+	c.Assert(auth.Transport(endpoint), IsNil)
+	for _, transport := range []http.RoundTripper{basic.transport, auth.transport} {
+		config := transport.(*http.Transport).TLSClientConfig
+		c.Assert(config.MinVersion, Equals, uint16(tls.VersionTLS12))
+		c.Assert(config.MaxVersion, Equals, uint16(tls.VersionTLS13))
+		c.Assert(config.CipherSuites, DeepEquals, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384})
+	}
+
+	endpoint.TLSMinVersion = tls.VersionTLS13
+	endpoint.TLSMaxVersion = tls.VersionTLS12
+	c.Assert(basic.Transport(endpoint), ErrorMatches, "TLS minimum version TLS 1.3 is above the maximum version TLS 1.2")
+	c.Assert(auth.Transport(endpoint), ErrorMatches, "TLS minimum version TLS 1.3 is above the maximum version TLS 1.2")
+
+	// a TLS 1.3 only server
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	ts.TLS = &tls.Config{MinVersion: tls.VersionTLS13}
+	ts.StartTLS()
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	endpoint = NewEndpoint(host, port, true, true, nil, nil, nil, 0)
+	endpoint.TLSMinVersion = tls.VersionTLS13
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	endpoint.TLSMinVersion, endpoint.TLSMaxVersion = 0, tls.VersionTLS12
+	client, err = NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, ".*protocol version.*")
+}
+
+func (s *WinRMSuite) TestEndpointConnectionTimeouts(c *C) {
+	endpoint := NewEndpoint("test", 5986, true, false, nil, []byte(cert), []byte(key), 0)
+	basic := &clientRequest{}
+	c.Assert(basic.Transport(endpoint), IsNil)
+	c.Assert(basic.transport.(*http.Transport).TLSHandshakeTimeout, Equals, 10*time.Second)
+
+	endpoint.TLSHandshakeTimeout = time.Second
+	for _, transporter := range []Transporter{&clientRequest{}, &ClientAuthRequest{}} {
+		c.Assert(transporter.Transport(endpoint), IsNil)
+	}
+	c.Assert(endpoint.connectTimeout(), Equals, 30*time.Second)
+	endpoint.ConnectTimeout = 50 * time.Millisecond
+	c.Assert(endpoint.connectTimeout(), Equals, 50*time.Millisecond)
+	auth := &ClientAuthRequest{}
+	c.Assert(auth.Transport(endpoint), IsNil)
+	c.Assert(auth.transport.(*http.Transport).TLSHandshakeTimeout, Equals, time.Second)
+}
+
+func (s *WinRMSuite) TestEndpointIPv6(c *C) {
+	endpoint := NewEndpoint("2001:db8::5", 5985, false, false, nil, nil, nil, 0)
+	c.Assert(endpoint.url(), Equals, "http://[2001:db8::5]:5985/wsman")
+
+	endpoint = NewEndpoint("[2001:db8::5]", 5986, true, false, nil, nil, nil, 0)
+	c.Assert(endpoint.Host, Equals, "2001:db8::5")
+	c.Assert(endpoint.url(), Equals, "https://[2001:db8::5]:5986/wsman")
+	c.Assert(endpoint.tlsServerName(), Equals, "")
+
+	endpoint = &Endpoint{Host: "fe80::1%eth0", Port: 5986, HTTPS: true}
+	c.Assert(endpoint.url(), Equals, "https://[fe80::1%25eth0]:5986/wsman")
+	parsed, err := url.Parse(endpoint.url())
+	c.Assert(err, IsNil)
+	c.Assert(parsed.Hostname(), Equals, "fe80::1%eth0")
+	c.Assert(endpoint.tlsServerName(), Equals, "fe80::1")
+
+	endpoint.TLSServerName = "srv-win.corp.example.com"
+	c.Assert(endpoint.tlsServerName(), Equals, "srv-win.corp.example.com")
+
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		c.Skip("no IPv6 loopback")
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	ts.Listener = listener
+	ts.Start()
+	defer ts.Close()
+
+	client, err := NewClient(NewEndpoint("::1", listener.Addr().(*net.TCPAddr).Port, false, false, nil, nil, nil, 0), "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+}
+
+func (s *WinRMSuite) TestNewEndpointFromURL(c *C) {
+	endpoint, err := NewEndpointFromURL("https://srv-win.corp.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(endpoint.Host, Equals, "srv-win.corp.example.com")
+	c.Assert(endpoint.Port, Equals, 5986)
+	c.Assert(endpoint.HTTPS, Equals, true)
+	c.Assert(endpoint.Timeout, Equals, 60*time.Second)
+	c.Assert(endpoint.url(), Equals, "https://srv-win.corp.example.com:5986/wsman")
+
+	endpoint, err = NewEndpointFromURL("HTTP://10.0.0.5/")
+	c.Assert(err, IsNil)
+	c.Assert(endpoint.url(), Equals, "http://10.0.0.5:5985/wsman")
+
+	endpoint, err = NewEndpointFromURL("http://gateway:8080/hosts/srv-win/wsman?tenant=a%20b")
+	c.Assert(err, IsNil)
+	c.Assert(endpoint.Port, Equals, 8080)
+	c.Assert(endpoint.url(), Equals, "http://gateway:8080/hosts/srv-win/wsman?tenant=a%20b")
+
+	endpoint, err = NewEndpointFromURL("https://gateway?tenant=a")
+	c.Assert(err, IsNil)
+	c.Assert(endpoint.url(), Equals, "https://gateway:5986/wsman?tenant=a")
+
+	endpoint, err = NewEndpointFromURL("https://[fe80::1%25eth0]:5986/wsman")
+	c.Assert(err, IsNil)
+	c.Assert(endpoint.Host, Equals, "fe80::1%eth0")
+	c.Assert(endpoint.url(), Equals, "https://[fe80::1%25eth0]:5986/wsman")
+
+	for rawURL, message := range map[string]string{
+		"srv-win:5985":             ".*the scheme must be http or https",
+		"ftp://srv-win":            ".*the scheme must be http or https",
+		"https://":                 ".*missing host",
+		"https://srv-win:0":        ".*invalid port 0",
+		"https://srv-win:99999":    ".*invalid port 99999",
+		"https://user:pw@srv-win":  ".*credentials aren't taken from the URL",
+		"https://srv-win:5986/%zz": "invalid endpoint URL: .*",
+	} {
+		_, err = NewEndpointFromURL(rawURL)
+		c.Assert(err, ErrorMatches, message, Commentf(rawURL))
+	}
+}
+
+func (s *WinRMSuite) TestEndpointPinnedCertSHA256(c *C) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	certificate := sha256.Sum256(ts.Certificate().Raw)
+	publicKey := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	colons := strings.ToUpper(hex.EncodeToString(certificate[:]))
+	for i := len(colons) - 2; i > 0; i -= 2 {
+		colons = colons[:i] + ":" + colons[i:]
+	}
+
+	for _, pin := range []string{hex.EncodeToString(certificate[:]), colons, hex.EncodeToString(publicKey[:])} {
+		endpoint := NewEndpoint(host, port, true, false, nil, nil, nil, 0)
+		endpoint.PinnedCertSHA256 = []string{hex.EncodeToString(make([]byte, sha256.Size)), pin}
+		client, err := NewClient(endpoint, "test", "test")
+		c.Assert(err, IsNil)
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil, Commentf(pin))
+	}
+
+	endpoint := NewEndpoint(host, port, true, false, nil, nil, nil, 0)
+	endpoint.PinnedCertSHA256 = []string{hex.EncodeToString(make([]byte, sha256.Size))}
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, ".*the server certificate .* isn't pinned")
+
+	endpoint.PinnedCertSHA256 = []string{"not a fingerprint"}
+	_, err = NewClient(endpoint, "test", "test")
+	c.Assert(err, ErrorMatches, `.*invalid SHA-256 certificate fingerprint "not a fingerprint"`)
+}
+
+func (s *WinRMSuite) TestEndpointHostAddresses(c *C) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	defer ts.Close()
+	_, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	// the httptest certificate is issued to example.com, which must stay the verified name
+	endpoint := NewEndpoint("example.com", port, true, false, caCert, nil, nil, 0)
+	endpoint.HostAddresses = map[string]string{"example.com": "127.0.0.1"}
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	endpoint = NewEndpoint("example.com", port, true, false, caCert, nil, nil, 0)
+	endpoint.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("no DNS server")
+		},
+	}
+	client, err = NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, ".*no DNS server.*")
+}
+
+func (s *WinRMSuite) TestEndpointCACerts(c *C) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+
+	for _, systemCertPool := range []bool{false, true} {
+		endpoint := NewEndpoint(host, port, true, false, []byte(cert), nil, nil, 0)
+		endpoint.CACerts = [][]byte{caCert}
+		endpoint.SystemCertPool = systemCertPool
+		client, err := NewClient(endpoint, "test", "test")
+		c.Assert(err, IsNil)
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil)
+	}
+
+	endpoint := NewEndpoint(host, port, true, false, nil, nil, nil, 0)
+	endpoint.CACerts = [][]byte{caCert, []byte("garbage")}
+	_, err = NewClient(endpoint, "test", "test")
+	c.Assert(err, ErrorMatches, ".*unable to read certificates")
+}
+
+func (s *WinRMSuite) TestEndpointProxyConnectHeader(c *C) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	var gateways, authorizations []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, http.MethodConnect)
+		gateways = append(gateways, r.Header.Get("X-Gateway"))
+		authorizations = append(authorizations, r.Header.Get("Proxy-Authorization"))
+		target, err := net.Dial("tcp", r.Host)
+		if !c.Check(err, IsNil) {
+			return
+		}
+		defer target.Close()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if !c.Check(err, IsNil) {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() { _, _ = io.Copy(target, conn) }()
+		_, _ = io.Copy(conn, target)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	c.Assert(err, IsNil)
+
+	endpoint := NewEndpoint(host, port, true, true, nil, nil, nil, 0)
+	endpoint.ProxyURL = proxyURL
+	endpoint.ProxyConnectHeader = http.Header{"X-Gateway": {"static"}}
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	endpoint.GetProxyConnectHeader = func(ctx context.Context, proxy *url.URL, target string) (http.Header, error) {
+		c.Check(target, Equals, net.JoinHostPort(host, strconv.Itoa(port)))
+		return http.Header{"Proxy-Authorization": {"Bearer token"}}, nil
+	}
+	client, err = NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	c.Assert(gateways, DeepEquals, []string{"static", ""})
+	c.Assert(authorizations, DeepEquals, []string{"", "Bearer token"})
+}
+
+func (s *WinRMSuite) TestEndpointTLSSessionCache(c *C) {
+	var resumed []bool
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resumed = append(resumed, r.TLS.DidResume)
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	cache := tls.NewLRUClientSessionCache(0)
+	for _, sessionCache := range []tls.ClientSessionCache{nil, nil, cache, cache} {
+		endpoint := NewEndpoint(host, port, true, true, nil, nil, nil, 0)
+		endpoint.TLSSessionCache = sessionCache
+		client, err := NewClient(endpoint, "test", "test")
+		c.Assert(err, IsNil)
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil)
+		c.Assert(client.Close(), IsNil)
+	}
+	c.Assert(resumed, DeepEquals, []bool{false, false, false, true})
+}
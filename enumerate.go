@@ -0,0 +1,62 @@
+package winrm
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+)
+
+// Enumerate lists the instances of resourceURI (like
+// "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service"),
+// optionally filtered with a WQL query, returning each of them as an XML document.
+// Pull requests are sent until the server signals the end of the enumeration.
+func (c *Client) Enumerate(ctx context.Context, resourceURI, filter string) ([]string, error) {
+	request := NewEnumerateRequest(c.url, resourceURI, filter, c.requestParameters())
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	items, enumerationContext, end, err := ParseEnumerateResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	for !end && enumerationContext != "" {
+		pull := NewPullRequest(c.url, resourceURI, enumerationContext, c.requestParameters())
+		response, err = c.sendRequestWithContext(ctx, pull)
+		pull.Free()
+		if err != nil {
+			return nil, err
+		}
+
+		var pulled []string
+		pulled, enumerationContext, end, err = ParseEnumerateResponse(response)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, pulled...)
+	}
+
+	return items, nil
+}
+
+// EnumerateAs is Client.Enumerate unmarshaling each instance into a T
+// with encoding/xml, so T fields are mapped with xml tags like `xml:"Name"`.
+func EnumerateAs[T interface{}](ctx context.Context, client *Client, resourceURI, filter string) ([]T, error) {
+	items, err := client.Enumerate(ctx, resourceURI, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]T, len(items))
+	for i, item := range items {
+		if err := xml.Unmarshal([]byte(item), &result[i]); err != nil {
+			return nil, fmt.Errorf("unmarshaling enumeration item %d: %w", i, err)
+		}
+	}
+
+	return result, nil
+}
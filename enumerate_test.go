@@ -0,0 +1,41 @@
+package winrm
+
+import (
+	"context"
+	"strings"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+var enumerateResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service"><s:Header><a:Action>http://schemas.xmlsoap.org/ws/2004/09/enumeration/EnumerateResponse</a:Action></s:Header><s:Body><n:EnumerateResponse><n:EnumerationContext>uuid:5A8B7C10-0000-0000-0000-000000000001</n:EnumerationContext><w:Items><p:Win32_Service><p:Name>WinRM</p:Name><p:State>Running</p:State></p:Win32_Service></w:Items></n:EnumerateResponse></s:Body></s:Envelope>`
+
+var pullResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service"><s:Header><a:Action>http://schemas.xmlsoap.org/ws/2004/09/enumeration/PullResponse</a:Action></s:Header><s:Body><n:PullResponse><n:Items><p:Win32_Service><p:Name>Spooler</p:Name><p:State>Stopped</p:State></p:Win32_Service></n:Items><n:EndOfSequence/></n:PullResponse></s:Body></s:Envelope>`
+
+type service struct {
+	Name  string `xml:"Name"`
+	State string `xml:"State"`
+}
+
+func (s *WinRMSuite) TestEnumerateAs(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), "enumeration/Enumerate") {
+			c.Assert(message.String(), Contains, "<![CDATA[SELECT * FROM Win32_Service WHERE StartMode <> 'Disabled']]>")
+			return enumerateResponse, nil
+		}
+		assertXPath(c, message.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull")
+		assertXPath(c, message.Doc(), "//n:Pull/n:EnumerationContext", "uuid:5A8B7C10-0000-0000-0000-000000000001")
+		return pullResponse, nil
+	}
+	client.http = &r
+
+	services, err := EnumerateAs[service](context.Background(), client,
+		"http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*", "SELECT * FROM Win32_Service WHERE StartMode <> 'Disabled'")
+	c.Assert(err, IsNil)
+	c.Assert(services, DeepEquals, []service{{Name: "WinRM", State: "Running"}, {Name: "Spooler", State: "Stopped"}})
+}
@@ -1,5 +1,158 @@
 package winrm
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+)
+
+var (
+	// ErrPartialResponse is returned when the server or a middlebox ended a response
+	// before the whole SOAP envelope was received
+	ErrPartialResponse = errors.New("partial response body")
+	// ErrInvalidContentType is returned when a response isn't a SOAP message
+	ErrInvalidContentType = errors.New("invalid content type")
+	// ErrCommandEncoding is returned when a command can't be encoded for PowerShell
+	ErrCommandEncoding = errors.New("cannot encode the given command")
+	// ErrCommandClosed is returned when using a Command that has already been closed
+	ErrCommandClosed = errors.New("Command has already been closed")
+	// ErrCommandCanceled is the error of the output of a Command closed before its termination
+	ErrCommandCanceled = errors.New("canceled")
+	// ErrUnsupportedEncryption is returned for message encryption protocols that aren't implemented
+	ErrUnsupportedEncryption = errors.New("encryption protocol not supported")
+	// ErrQuotaExceeded wraps the faults returned when the server shell or operation quotas are reached
+	ErrQuotaExceeded = errors.New("server quota exceeded")
+	// ErrStepFailed is returned by RunSteps when a step without ContinueOnError fails
+	ErrStepFailed = errors.New("step failed")
+	// ErrUnsupportedAuth is returned when the server offers no authentication scheme the client implements
+	ErrUnsupportedAuth = errors.New("no supported authentication scheme")
+	// ErrRedirect is wrapped by the RedirectError returned when a redirection isn't followed
+	ErrRedirect = errors.New("redirected")
+	// ErrCircuitOpen is returned without sending the request while the circuit breaker of the client is open
+	ErrCircuitOpen = errors.New("circuit breaker open")
+	// ErrCommandTimeout is wrapped by the CommandTimeoutError of the commands terminated
+	// because they ran longer than their timeout
+	ErrCommandTimeout = errors.New("command timed out")
+	// ErrOutputLimitExceeded is the error of the commands terminated because their output
+	// went over their MaxOutput limit, the output received up to the limit being kept
+	ErrOutputLimitExceeded = errors.New("output limit exceeded")
+	// ErrProcessNotFound is returned by Command.ProcessID when the process of the command can't be identified
+	ErrProcessNotFound = errors.New("process of the command not found")
+	// ErrDisconnectUnsupported is returned when disconnecting or connecting the shells of the cmd
+	// resource URI, only the PowerShell (PSRP) shells supporting it
+	ErrDisconnectUnsupported = errors.New("only PowerShell shells can be disconnected")
+)
+
+// InsecureBasicError is returned when Basic credentials would be sent over plain HTTP
+// while Parameters.AllowInsecureBasic is false
+type InsecureBasicError struct {
+	URL string
+}
+
+func (e *InsecureBasicError) Error() string {
+	return fmt.Sprintf("refusing to send Basic credentials over plain HTTP to %s", e.URL)
+}
+
+// RedirectError is returned when the server answers with a redirection the client doesn't
+// follow, because Parameters.FollowRedirects isn't set or it would be unsafe
+type RedirectError struct {
+	StatusCode int
+	Location   string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("%s with status %d to %s", ErrRedirect, e.StatusCode, e.Location)
+}
+
+func (e *RedirectError) Unwrap() error {
+	return ErrRedirect
+}
+
+// CommandTimeoutError is the error of a command terminated because it was still running after
+// Timeout, set by ExecuteOptions.Timeout, Parameters.CommandTimeout or RunWithTimeout.
+// It wraps ErrCommandTimeout and context.DeadlineExceeded.
+// Stdout and Stderr hold the output received before, when the helper collected it.
+type CommandTimeoutError struct {
+	Timeout time.Duration
+	Stdout  string
+	Stderr  string
+}
+
+func (e *CommandTimeoutError) Error() string {
+	return fmt.Sprintf("%s after %s", ErrCommandTimeout, e.Timeout)
+}
+
+func (e *CommandTimeoutError) Unwrap() []error {
+	return []error{ErrCommandTimeout, context.DeadlineExceeded}
+}
+
+// withCommandTimeout returns ctx canceled after timeout with a CommandTimeoutError cause
+func withCommandTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeoutCause(ctx, timeout, &CommandTimeoutError{Timeout: timeout})
+}
+
+// withPartialOutput returns err with the output collected in stdout and stderr
+// when it is a CommandTimeoutError
+func withPartialOutput(err error, stdout, stderr string) error {
+	var timeoutErr *CommandTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		return err
+	}
+	return &CommandTimeoutError{Timeout: timeoutErr.Timeout, Stdout: stdout, Stderr: stderr}
+}
+
+// ExitError is returned by the Run helpers when the command exits with a non-zero code
+// and Parameters.ExitError is set
+type ExitError struct {
+	Command  string
+	ExitCode int
+	// Stderr holds the last bytes of the command stderr, up to exitErrorStderrSize
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	message := fmt.Sprintf("command %q exited with code %d", e.Command, e.ExitCode)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		message += ": " + stderr
+	}
+	return message
+}
+
+// exitErrorStderrSize is the number of stderr bytes kept by an ExitError
+const exitErrorStderrSize = 1024
+
+// HTTPError is returned when the server answers with an unexpected HTTP status,
+// Body holds the response which usually is a SOAP fault
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http error %d: %s", e.StatusCode, e.Body)
+}
+
+// FaultCode returns the code of the WSManFault carried by the response, like
+// FaultOperationTimeout, or an empty string if there is none
+func (e *HTTPError) FaultCode() string {
+	doc, err := xmltree.ParseXML(strings.NewReader(e.Body))
+	if err != nil {
+		return ""
+	}
+	code, _ := first(doc, "//f:WSManFault/@Code")
+	return code
+}
+
+// isShellNotFound tells if err is the fault of a request on a shell the server deleted
+func isShellNotFound(err error) bool {
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && httpErr.FaultCode() == FaultShellNotFound
+}
+
 // winrmError generic error struct
 type winrmError struct {
 	message string
@@ -9,3 +162,17 @@ type winrmError struct {
 func (e winrmError) Error() string {
 	return e.message
 }
+
+// ProtocolVersionError is returned when a feature needs a WinRM stack version
+// the server doesn't advertise
+type ProtocolVersionError struct {
+	Required   string
+	Advertised string
+}
+
+func (e *ProtocolVersionError) Error() string {
+	if e.Advertised == "" {
+		return fmt.Sprintf("server doesn't advertise its protocol version, %s is required", e.Required)
+	}
+	return fmt.Sprintf("server protocol version %s is lower than the required %s", e.Advertised, e.Required)
+}
@@ -2,6 +2,8 @@ package winrm
 
 import (
 	"errors"
+	"net/http"
+	"net/url"
 
 	. "gopkg.in/check.v1"
 )
@@ -17,3 +19,57 @@ func (s *WinRMSuite) TestError(c *C) {
 		c.Assert(wErr.Error(), Equals, same.Error())
 	}(err, same)
 }
+
+func (s *WinRMSuite) TestErrorChains(c *C) {
+	status := http.StatusOK
+	contentType := "application/soap+xml"
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	client, err := NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test")
+	c.Assert(err, IsNil)
+
+	contentType = "text/html"
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrInvalidContentType), Equals, true)
+
+	contentType, status = "application/soap+xml", http.StatusInternalServerError
+	_, err = client.CreateShell()
+	var httpErr *HTTPError
+	c.Assert(errors.As(err, &httpErr), Equals, true)
+	c.Assert(httpErr.StatusCode, Equals, http.StatusInternalServerError)
+	c.Assert(httpErr.Body, Equals, response)
+
+	// a status without a SOAP fault, like a rejected authentication, matches both
+	contentType, status = "text/html", http.StatusUnauthorized
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrInvalidContentType), Equals, true)
+	c.Assert(errors.As(err, &httpErr), Equals, true)
+	c.Assert(httpErr.StatusCode, Equals, http.StatusUnauthorized)
+
+	ts.Close()
+	_, err = client.CreateShell()
+	var urlErr *url.Error
+	c.Assert(errors.As(err, &urlErr), Equals, true)
+	c.Assert(urlErr.Op, Equals, "Post")
+
+	_, err = NewEncryption("foo")
+	c.Assert(errors.Is(err, ErrUnsupportedEncryption), Equals, true)
+
+	command := &Command{}
+	c.Assert(command.Close(), Equals, ErrCommandClosed)
+}
+
+var shellNotFoundFault = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd"><s:Header><a:Action>http://schemas.dmtf.org/wbem/wsman/1/wsman/fault</a:Action></s:Header><s:Body><s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>w:InvalidSelectors</s:Value></s:Subcode></s:Code><s:Reason><s:Text xml:lang="en-US">The WS-Management service cannot process the request because the request contained invalid selectors for the resource. </s:Text></s:Reason><s:Detail><f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="2150858843" Machine="windows-host"><f:Message>The request for the Windows Remote Shell with ShellId 67A74734-DD32-4F10-89DE-49A060483810 failed because the shell was not found on the server.</f:Message></f:WSManFault></s:Detail></s:Fault></s:Body></s:Envelope>`
+
+func (s *WinRMSuite) TestHTTPErrorFaultCode(c *C) {
+	err := &HTTPError{StatusCode: http.StatusInternalServerError, Body: shellNotFoundFault}
+	c.Assert(err.FaultCode(), Equals, FaultShellNotFound)
+
+	err = &HTTPError{StatusCode: http.StatusBadGateway, Body: "<html>bad gateway</html>"}
+	c.Assert(err.FaultCode(), Equals, "")
+}
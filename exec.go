@@ -0,0 +1,74 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// RunOption configures how Client.Exec runs a command
+type RunOption func(*runOptions)
+
+type runOptions struct {
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	powershell bool
+}
+
+// WithStdin injects stdin as the command input
+func WithStdin(stdin io.Reader) RunOption {
+	return func(o *runOptions) {
+		o.stdin = stdin
+	}
+}
+
+// WithOutput streams the command stdout and stderr to the given writers
+// instead of collecting them in the CommandResult
+func WithOutput(stdout, stderr io.Writer) RunOption {
+	return func(o *runOptions) {
+		o.stdout = stdout
+		o.stderr = stderr
+	}
+}
+
+// WithPowerShell runs the command as a PowerShell script instead of a cmd.exe command line
+func WithPowerShell() RunOption {
+	return func(o *runOptions) {
+		o.powershell = true
+	}
+}
+
+// Exec runs command in a new shell on the remote host and waits for its termination.
+// It is the context-first replacement of the Run* variants: stdin, output
+// streaming and PowerShell are selected with RunOption values.
+// If the context is canceled, the remote command is canceled.
+func (c *Client) Exec(ctx context.Context, command string, options ...RunOption) (*CommandResult, error) {
+	opts := &runOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+
+	if opts.powershell {
+		command = Powershell(command)
+		if command == "" {
+			return nil, ErrCommandEncoding
+		}
+	}
+
+	var outWriter, errWriter bytes.Buffer
+	stdout, stderr := io.Writer(&outWriter), io.Writer(&errWriter)
+	if opts.stdout != nil {
+		stdout = opts.stdout
+	}
+	if opts.stderr != nil {
+		stderr = opts.stderr
+	}
+
+	cmd, err := c.runWithContextWithInput(ctx, command, nil, stdout, stderr, opts.stdin)
+	if cmd == nil {
+		return nil, err
+	}
+
+	return newCommandResult(cmd, &outWriter, &errWriter), err
+}
@@ -0,0 +1,64 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestExec(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "this is the input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	result, err := client.Exec(context.Background(), "ipconfig /all", WithStdin(strings.NewReader("this is the input")))
+	c.Assert(err, IsNil)
+	c.Assert(result.ExitCode, Equals, 123)
+	c.Assert(result.Stdout, Equals, "That's all folks!!!")
+	c.Assert(result.Stderr, Equals, "This is stderr, I'm pretty sure!")
+
+	// the fake server only sends the output once
+	ts, host, port, err = runWinRMFakeServer(c, "this is the input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	client, err = NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	var stdout, stderr bytes.Buffer
+	result, err = client.Exec(context.Background(), "ipconfig /all", WithPowerShell(),
+		WithStdin(strings.NewReader("this is the input")), WithOutput(&stdout, &stderr))
+	c.Assert(err, IsNil)
+	c.Assert(result.ExitCode, Equals, 123)
+	c.Assert(result.Stdout, Equals, "")
+	c.Assert(stdout.String(), Equals, "That's all folks!!!")
+	c.Assert(stderr.String(), Equals, "This is stderr, I'm pretty sure!")
+}
+
+func (s *WinRMSuite) TestCreateShellWithContextAbortsRequest(c *C) {
+	release := make(chan struct{})
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	defer close(release)
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.CreateShellWithContext(ctx)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+
+	_, err = client.CreateShellWithContext(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+}
@@ -1,59 +1,159 @@
 package winrm
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
+	"sync/atomic"
 
 	"github.com/satendraraj/winrm/soap"
+	"golang.org/x/text/encoding/unicode"
 )
 
 var soapXML = "application/soap+xml"
 
-// body func reads the response body and return it as a string
-func body(response *http.Response) (string, error) {
-	// if we received the content we expected
-	if strings.Contains(response.Header.Get("Content-Type"), "application/soap+xml") {
-		body, err := io.ReadAll(response.Body)
-		defer func() {
-			// defer can modify the returned value before
-			// it is actually passed to the calling statement
-			if errClose := response.Body.Close(); errClose != nil && err == nil {
-				err = errClose
-			}
-		}()
+// isSOAPContentType tells if contentType is acceptable for a SOAP response,
+// whatever the case and order of its parameters.
+// OMI style servers are known to answer with text/xml.
+func isSOAPContentType(contentType string, compatibility Compatibility) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// be lenient with malformed parameters, only the media type matters
+		mediaType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	}
+
+	switch mediaType {
+	case soapXML:
+		return true
+	case "text/xml":
+		return compatibility == CompatibilityOMI
+	}
+	return false
+}
+
+// decodeBody converts a response body to an UTF-8 string according
+// to its content type charset and byte order mark
+func decodeBody(body []byte, contentType string) (string, error) {
+	charset := ""
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		charset = strings.ToLower(strings.Trim(params["charset"], `"' `))
+	}
+
+	switch {
+	case bytes.HasPrefix(body, []byte{0xef, 0xbb, 0xbf}):
+		return string(body[3:]), nil
+	case bytes.HasPrefix(body, []byte{0xff, 0xfe}), bytes.HasPrefix(body, []byte{0xfe, 0xff}),
+		strings.HasPrefix(charset, "utf-16"), strings.HasPrefix(charset, "utf16"):
+		endianness := unicode.LittleEndian
+		if charset == "utf-16be" || charset == "utf16be" {
+			endianness = unicode.BigEndian
+		}
+		decoded, err := unicode.UTF16(endianness, unicode.UseBOM).NewDecoder().Bytes(body)
 		if err != nil {
-			return "", fmt.Errorf("error while reading request body %w", err)
+			return "", fmt.Errorf("decoding %s response: %w", charset, err)
 		}
+		return string(decoded), nil
+	}
 
-		return string(body), nil
+	return string(body), nil
+}
+
+// body func reads the response body and return it as a string
+func body(response *http.Response, compatibility Compatibility) (string, error) {
+	defer response.Body.Close()
+
+	// if we received the content we expected
+	contentType := response.Header.Get("Content-Type")
+	if !isSOAPContentType(contentType, compatibility) {
+		return "", ErrInvalidContentType
+	}
+
+	body, err := readBody(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	decoded, err := decodeBody(body, contentType)
+	if err != nil {
+		return "", err
+	}
+
+	return decoded, checkEnvelope(decoded)
+}
+
+// readBody reads a whole response body, whether it is sent with a Content-Length,
+// chunked or delimited by the connection close
+func readBody(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(r)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: connection closed after %d bytes", ErrPartialResponse, len(body))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while reading request body %w", err)
 	}
 
-	return "", fmt.Errorf("invalid content type")
+	return body, nil
+}
+
+// checkEnvelope detects a body cut before the end of the SOAP envelope,
+// which happens when the connection is closed early and no length was announced
+func checkEnvelope(body string) error {
+	if !strings.HasSuffix(strings.TrimSpace(body), "Envelope>") {
+		return fmt.Errorf("%w: missing end of SOAP envelope after %d bytes", ErrPartialResponse, len(body))
+	}
+
+	return nil
 }
 
 type clientRequest struct {
 	transport http.RoundTripper
-	dial      func(network, addr string) (net.Conn, error)
-	proxyfunc func(req *http.Request) (*url.URL, error)
+	// ntlm tells the credentials are used for NTLM authentication instead of Basic
+	ntlm bool
+	dial func(network, addr string) (net.Conn, error)
+	// dialContext opens the connections instead of dial
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	proxyfunc   func(req *http.Request) (*url.URL, error)
+	// roundTripper replaces the transport built from the endpoint settings
+	roundTripper http.RoundTripper
+	// client sends the requests over transport, keeping its connections between them
+	client *http.Client
 }
 
-func (c *clientRequest) Transport(endpoint *Endpoint) error {
-	dial := (&net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
-	}).Dial
+// Reauthenticate closes the idle connections, which may hold an authenticated state
+func (c *clientRequest) Reauthenticate() {
+	c.CloseIdleConnections()
+}
 
-	if c.dial != nil {
-		dial = c.dial
+// CloseIdleConnections closes the connections kept open for the next requests
+func (c *clientRequest) CloseIdleConnections() {
+	closeIdleConnections(c.transport)
+}
+
+// closeIdleConnections closes the idle connections of v (a transport or a Transporter)
+// when it keeps any
+func closeIdleConnections(v interface{}) {
+	if closer, ok := v.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
 	}
+}
+
+func (c *clientRequest) Transport(endpoint *Endpoint) error {
+	if c.roundTripper != nil {
+		c.setTransport(c.roundTripper)
+		return nil
+	}
+
+	dialContext := endpoint.dialContext(c.dialContext, c.dial)
 
-	proxyfunc := http.ProxyFromEnvironment
+	proxyfunc := endpoint.proxy(http.ProxyFromEnvironment)
 	if c.proxyfunc != nil {
 		proxyfunc = c.proxyfunc
 	}
@@ -63,56 +163,243 @@ func (c *clientRequest) Transport(endpoint *Endpoint) error {
 		Proxy: proxyfunc,
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: endpoint.Insecure,
-			ServerName:         endpoint.TLSServerName,
+			ServerName:         endpoint.tlsServerName(),
 		},
-		Dial:                  dial,
+		DialContext:           dialContext,
 		ResponseHeaderTimeout: endpoint.Timeout,
+		TLSHandshakeTimeout:   endpoint.tlsHandshakeTimeout(),
+		MaxIdleConnsPerHost:   endpoint.MaxIdleConnsPerHost,
+		IdleConnTimeout:       endpoint.idleConnTimeout(),
+		ProxyConnectHeader:    endpoint.ProxyConnectHeader,
+		GetProxyConnectHeader: endpoint.GetProxyConnectHeader,
 	}
 
-	if endpoint.CACert != nil && len(endpoint.CACert) > 0 {
-		certPool, err := readCACerts(endpoint.CACert)
+	if err := endpoint.applyTLSSettings(transport.TLSClientConfig); err != nil {
+		return err
+	}
+
+	certPool, err := endpoint.rootCAs()
+	if err != nil {
+		return err
+	}
+	transport.TLSClientConfig.RootCAs = certPool
+
+	// a client certificate can be required to establish the TLS tunnel
+	// (typically by a reverse proxy) on top of the WinRM credentials
+	cert, err := endpoint.clientCertificate()
+	if err != nil {
+		return err
+	}
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		transport.TLSClientConfig.Renegotiation = tls.RenegotiateOnceAsClient
+	}
+
+	c.setTransport(transport)
+
+	return nil
+}
+
+// setTransport sets the transport of the requests, and the HTTP client using it
+func (c *clientRequest) setTransport(transport http.RoundTripper) {
+	c.transport = transport
+	c.client = &http.Client{Transport: transport, CheckRedirect: noRedirect}
+}
+
+// httpClient returns the HTTP client shared by the requests of the transport
+func (c *clientRequest) httpClient() *http.Client {
+	if c.client == nil {
+		return &http.Client{Transport: c.transport, CheckRedirect: noRedirect}
+	}
+	return c.client
+}
+
+// Post make post to the winrm soap service, aborted when ctx is canceled
+func (c clientRequest) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	if !c.ntlm && !client.AllowInsecureBasic && strings.HasPrefix(client.url, "http:") {
+		return "", &InsecureBasicError{URL: client.url}
+	}
+
+	httpClient := c.httpClient()
+
+	return post(ctx, client, request, func(req *http.Request) (*http.Response, error) {
+		username, password, err := client.credentials(req.Context())
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("getting the credentials: %w", err)
 		}
+		req.SetBasicAuth(qualifiedUsername(username, client.domain), password)
+		return httpClient.Do(req)
+	})
+}
+
+// post sends request with do, which is in charge of the authentication,
+// and returns the body of a successful SOAP response
+func post(ctx context.Context, client *Client, request *soap.SoapMessage, do func(*http.Request) (*http.Response, error)) (string, error) {
+	return postURL(ctx, client, client.url, 0, client.compressRequests(), request, do)
+}
+
+// maxRedirects is the number of redirections followed for a request, like http.Client
+const maxRedirects = 10
+
+// noRedirect is the CheckRedirect of the HTTP clients, the redirections being handled by post
+func noRedirect(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
 
-		transport.TLSClientConfig.RootCAs = certPool
+// isRedirect tells if status is a redirection to the Location of the response
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
 	}
+	return false
+}
 
-	c.transport = transport
+// redirectLocation returns the URL the redirection resp of req leads to, or the error
+// returned when it isn't followed
+func redirectLocation(client *Client, req *http.Request, resp *http.Response, redirects int) (string, error) {
+	redirectErr := &RedirectError{StatusCode: resp.StatusCode, Location: resp.Header.Get("Location")}
+	if !client.FollowRedirects || redirects >= maxRedirects || redirectErr.Location == "" {
+		return "", redirectErr
+	}
+	location, err := req.URL.Parse(redirectErr.Location)
+	if err != nil || !sameOrigin(req.URL, location) {
+		return "", redirectErr
+	}
+	return location.String(), nil
+}
 
-	return nil
+// sameOrigin tells if the credentials sent to from can be sent to to as well:
+// same host and port, or the same host upgraded from HTTP to HTTPS
+func sameOrigin(from, to *url.URL) bool {
+	if !strings.EqualFold(from.Hostname(), to.Hostname()) {
+		return false
+	}
+	switch {
+	case to.Scheme == from.Scheme:
+		return to.Port() == from.Port()
+	case from.Scheme == "http" && to.Scheme == "https":
+		return true
+	}
+	return false
 }
 
-// Post make post to the winrm soap service
-func (c clientRequest) Post(client *Client, request *soap.SoapMessage) (string, error) {
-	httpClient := &http.Client{Transport: c.transport}
+// postURL posts request to url, following the redirections up to maxRedirects,
+// redirects being the number of redirections already followed.
+// A compressed request the server fails is posted once more in clear.
+func postURL(ctx context.Context, client *Client, url string, redirects int, compressed bool, request *soap.SoapMessage, do func(*http.Request) (*http.Response, error)) (string, error) {
+	var reqBody io.Reader = strings.NewReader(request.String())
+	if compressed {
+		var err error
+		if reqBody, err = compressRequest(request.String()); err != nil {
+			return "", err
+		}
+	}
 
-	//nolint:noctx
-	req, err := http.NewRequest("POST", client.url, strings.NewReader(request.String()))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, reqBody)
 	if err != nil {
 		return "", fmt.Errorf("impossible to create http request %w", err)
 	}
 	req.Header.Set("Content-Type", soapXML+";charset=UTF-8")
-	req.SetBasicAuth(client.username, client.password)
-	resp, err := httpClient.Do(req)
+	if client.Compression {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if err := client.decorateRequest(req); err != nil {
+		return "", err
+	}
+	resp, err := do(req)
 	if err != nil {
 		return "", fmt.Errorf("unknown error %w", err)
 	}
+	if client.stats != nil {
+		if req.ContentLength > 0 {
+			atomic.AddInt64(&client.stats.bytesSent, req.ContentLength)
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			atomic.AddInt64(&client.stats.authFailures, 1)
+		}
+		resp.Body = &countingBody{ReadCloser: resp.Body, count: &client.stats.bytesReceived}
+	}
+
+	client.negotiateCompression(resp)
+
+	// the server may not decode compressed requests after all: when the request succeeds
+	// in clear, they are sent in clear from now on
+	if compressed && refusesCompression(resp, client.Compatibility) {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		body, err := postURL(ctx, client, url, redirects, false, request, do)
+		if err == nil {
+			client.refuseCompression()
+		}
+		return body, err
+	}
+
+	// the request is posted again to the new location, through do which authenticates it
+	if isRedirect(resp.StatusCode) {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		location, err := redirectLocation(client, req, resp, redirects)
+		if err != nil {
+			return "", err
+		}
+		return postURL(ctx, client, location, redirects+1, compressed, request, do)
+	}
+	if err := decompressResponse(resp); err != nil {
+		return "", err
+	}
 
-	body, err := body(resp)
+	// authentication failures and the like don't come with a SOAP fault: their status is
+	// kept in an HTTPError for the retries and reauthentication, still matching ErrInvalidContentType
+	if resp.StatusCode != http.StatusOK && !isSOAPContentType(resp.Header.Get("Content-Type"), client.Compatibility) {
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return "", fmt.Errorf("%w: %w", &HTTPError{StatusCode: resp.StatusCode, Body: string(raw)}, ErrInvalidContentType)
+	}
+
+	body, err := body(resp, client.Compatibility)
 	if err != nil {
 		return "", fmt.Errorf("http response error: %d - %w", resp.StatusCode, err)
 	}
 
 	// if we have different 200 http status code
 	// we must replace the error
-	defer func() {
-		if resp.StatusCode != 200 {
-			body, err = "", fmt.Errorf("http error %d: %s", resp.StatusCode, body)
-		}
-	}()
+	if resp.StatusCode != 200 {
+		return "", &HTTPError{StatusCode: resp.StatusCode, Body: body}
+	}
+
+	return body, nil
+}
+
+// decorateRequest applies the RequestDecorator of the client parameters to req
+func (c *Client) decorateRequest(req *http.Request) error {
+	if c.RequestDecorator == nil {
+		return nil
+	}
+	if err := c.RequestDecorator(req); err != nil {
+		return fmt.Errorf("decorating the request: %w", err)
+	}
+	return nil
+}
 
-	return body, err
+// NewClientWithRoundTripper creates a basic auth transport sending the requests with roundTripper,
+// to instrument or cache them, or to go through a corporate TLS middlebox.
+// The TLS, proxy, dial and timeout settings of the endpoint are then left to roundTripper.
+func NewClientWithRoundTripper(roundTripper http.RoundTripper) *clientRequest {
+	return &clientRequest{
+		roundTripper: roundTripper,
+	}
+}
+
+// NewClientWithDialContext creates a basic auth transport opening its connections with dialContext
+func NewClientWithDialContext(dialContext func(ctx context.Context, network, addr string) (net.Conn, error)) *clientRequest {
+	return &clientRequest{
+		dialContext: dialContext,
+	}
 }
 
 // NewClientWithDial NewClientWithDial
@@ -1,7 +1,15 @@
 package winrm
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 
 	"net"
 	"time"
@@ -74,11 +82,367 @@ func (s *WinRMSuite) TestHttpViaCustomDialerRequest(c *C) {
 	c.Assert(err, IsNil)
 	defer ts.Close()
 	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
-	params := DefaultParameters
-	params.TransportDecorator = func() Transporter { return NewClientWithDial(dial) }
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return NewClientWithDial(dial) }).Build()
 	client, err := NewClientWithParameters(endpoint, "test", "test", params)
 	c.Assert(err, IsNil)
 	_, err = client.CreateShell()
 	c.Assert(err, IsNil)
 	c.Assert(usedCustomDialer, Equals, true)
 }
+
+func (s *WinRMSuite) TestHttpOMICompatibilityContentType(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		_, _ = w.Write([]byte(response))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+
+	params := NewParameters("PT60S", "en-US", 153600)
+	params.AllowInsecureBasic = true
+	client, err := NewClientWithParameters(endpoint, "test", "test", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, ".*invalid content type.*")
+
+	params = NewParameters("PT60S", "en-US", 153600)
+	params.AllowInsecureBasic = true
+	params.Compatibility = CompatibilityOMI
+	client, err = NewClientWithParameters(endpoint, "test", "test", params)
+	c.Assert(err, IsNil)
+	shell, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+}
+
+func (s *WinRMSuite) TestHttpTolerantContentTypeAndBOM(c *C) {
+	utf16 := []byte{0xff, 0xfe}
+	var utf16be []byte
+	for _, r := range response {
+		utf16 = append(utf16, byte(r), byte(r>>8))
+		utf16be = append(utf16be, byte(r>>8), byte(r))
+	}
+
+	for contentType, payload := range map[string][]byte{
+		"Application/SOAP+XML":                              []byte(response),
+		"application/soap+xml;charset=UTF-8":                []byte(response),
+		"application/soap+xml; action=\"x\"; charset=utf-8": []byte(response),
+		"application/soap+xml ; charset=utf-8":              append([]byte{0xef, 0xbb, 0xbf}, response...),
+		"application/soap+xml;charset=UTF-16":               utf16,
+		"application/soap+xml;charset=utf-16le":             utf16[2:],
+		"application/soap+xml;charset=UTF-16BE":             utf16be,
+	} {
+		ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			_, _ = w.Write(payload)
+		}))
+		c.Assert(err, IsNil)
+		endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+		client, err := NewClient(endpoint, "test", "test")
+		c.Assert(err, IsNil)
+		shell, err := client.CreateShell()
+		ts.Close()
+		c.Assert(err, IsNil, Commentf("content type %q", contentType))
+		c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	}
+}
+
+func (s *WinRMSuite) TestHttpChunkedAndConnectionCloseResponses(c *C) {
+	rawResponse := func(header, payload string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			c.Assert(err, IsNil)
+			defer conn.Close()
+			_, _ = buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/soap+xml\r\n" + header + "\r\n" + payload)
+			_ = buf.Flush()
+		}
+	}
+
+	for name, t := range map[string]struct {
+		handler http.HandlerFunc
+		err     string
+	}{
+		"chunked": {handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/soap+xml")
+			half := len(response) / 2
+			_, _ = w.Write([]byte(response[:half]))
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte(response[half:]))
+		}},
+		"connection close":           {handler: rawResponse("Connection: close\r\n", response)},
+		"truncated content length":   {handler: rawResponse("Content-Length: 100000\r\n", response), err: ".*partial response body: connection closed.*"},
+		"truncated connection close": {handler: rawResponse("Connection: close\r\n", response[:200]), err: ".*partial response body: missing end of SOAP envelope.*"},
+	} {
+		ts, host, port, err := StartTestServer(t.handler)
+		c.Assert(err, IsNil)
+		endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+		client, err := NewClient(endpoint, "test", "test")
+		c.Assert(err, IsNil)
+		shell, err := client.CreateShell()
+		ts.Close()
+		if t.err != "" {
+			c.Assert(errors.Is(err, ErrPartialResponse), Equals, true, Commentf(name))
+			c.Assert(err, ErrorMatches, t.err)
+			continue
+		}
+		c.Assert(err, IsNil, Commentf(name))
+		c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	}
+}
+
+func (s *WinRMSuite) TestHttpClientCertificateWithCredentials(c *C) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "test" || password != "secret" || len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(response))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	endpoint := NewEndpoint(host, port, true, true, nil, []byte(cert), []byte(key), 0)
+	client, err := NewClientWithParameters(endpoint, "test", "secret", NewParameters("PT60S", "en-US", 153600))
+	c.Assert(err, IsNil)
+	shell, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+}
+
+func (s *WinRMSuite) TestRequestDecorator(c *C) {
+	var traces []string
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traces = append(traces, r.Header.Get("Traceparent"))
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	fail := false
+	params := NewParametersBuilder().RequestDecorator(func(req *http.Request) error {
+		if fail {
+			return errors.New("no trace context")
+		}
+		req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		return nil
+	}).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(traces, DeepEquals, []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"})
+
+	fail = true
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, "decorating the request: no trace context")
+	c.Assert(traces, HasLen, 1)
+}
+
+func (s *WinRMSuite) TestAllowInsecureBasic(c *C) {
+	requests := 0
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+
+	// refused by default
+	client, err := NewClientWithParameters(endpoint, "test", "test", NewParameters("PT60S", "en-US", 153600))
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	var insecure *InsecureBasicError
+	c.Assert(errors.As(err, &insecure), Equals, true)
+	c.Assert(insecure.URL, Equals, endpoint.url())
+	client, err = NewClientWithParameters(endpoint, "test", "test", NewParametersBuilder().AllowInsecureBasic(false).Build())
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(errors.As(err, &insecure), Equals, true)
+	c.Assert(requests, Equals, 0)
+
+	client, err = NewClientWithParameters(endpoint, "test", "test", NewParametersBuilder().AllowInsecureBasic(true).Build())
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(requests, Equals, 1)
+}
+
+type countingRoundTripper struct {
+	requests int
+}
+
+func (t *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (s *WinRMSuite) TestNewClientWithRoundTripper(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	roundTripper := &countingRoundTripper{}
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return NewClientWithRoundTripper(roundTripper) }).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(roundTripper.requests, Equals, 1)
+
+	transport := NewClientNTLMWithRoundTripper(roundTripper)
+	c.Assert(transport.Transport(NewEndpoint(host, port, false, false, nil, nil, nil, 0)), IsNil)
+	c.Assert(transport.base, Equals, http.RoundTripper(roundTripper))
+}
+
+func (s *WinRMSuite) TestPostCanceledInFlight(c *C) {
+	release := make(chan struct{})
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	defer close(release)
+
+	client, err := NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, time.Hour), "test", "test")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.CreateShellWithContext(ctx)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+}
+
+func (s *WinRMSuite) TestConnectionReuse(c *C) {
+	addresses := map[string]bool{}
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addresses[r.RemoteAddr] = true
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	endpoint.MaxIdleConnsPerHost = 4
+	endpoint.IdleConnTimeout = time.Minute
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	transport := client.http.(*clientRequest).transport.(*http.Transport)
+	c.Assert(transport.MaxIdleConnsPerHost, Equals, 4)
+	c.Assert(transport.IdleConnTimeout, Equals, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil)
+	}
+	c.Assert(addresses, HasLen, 1)
+
+	// Close drops the idle connection, the client reconnects afterwards
+	c.Assert(client.Close(), IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(addresses, HasLen, 2)
+}
+
+func (s *WinRMSuite) TestRedirect(c *C) {
+	var authorizations []string
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.URL.Path == "/wsman" {
+			w.Header().Set("Location", "/gateway/wsman")
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		c.Check(strings.Contains(string(body), "transfer/Create"), Equals, true)
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrRedirect), Equals, true)
+	var redirectErr *RedirectError
+	c.Assert(errors.As(err, &redirectErr), Equals, true)
+	c.Assert(redirectErr.StatusCode, Equals, http.StatusTemporaryRedirect)
+	c.Assert(redirectErr.Location, Equals, "/gateway/wsman")
+	c.Assert(authorizations, HasLen, 1)
+
+	authorizations = nil
+	client, err = NewClientWithParameters(endpoint, "test", "test", NewParametersBuilder().FollowRedirects(true).Build())
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(authorizations, HasLen, 2)
+	c.Assert(authorizations[1], Equals, authorizations[0])
+	c.Assert(authorizations[1], Not(Equals), "")
+}
+
+func (s *WinRMSuite) TestRedirectToOtherHost(c *C) {
+	var received int
+	other, otherHost, otherPort, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer other.Close()
+
+	location := fmt.Sprintf("http://%s:%d/wsman", otherHost, otherPort)
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+
+	client, err := NewClientWithParameters(endpoint, "test", "test", NewParametersBuilder().FollowRedirects(true).Build())
+	c.Assert(err, IsNil)
+	for _, location = range []string{
+		fmt.Sprintf("http://%s:%d/wsman", otherHost, otherPort),
+		fmt.Sprintf("http://localhost:%d/wsman", otherPort),
+	} {
+		_, err = client.CreateShell()
+		c.Assert(errors.Is(err, ErrRedirect), Equals, true)
+	}
+	c.Assert(received, Equals, 0)
+}
+
+func (s *WinRMSuite) TestSameOrigin(c *C) {
+	from, _ := url.Parse("http://host:5985/wsman")
+	for to, same := range map[string]bool{
+		"http://HOST:5985/gateway": true,
+		"https://host:5986/wsman":  true,
+		"http://host:8080/wsman":   false,
+		"http://other:5985/wsman":  false,
+		"https://other:5986/wsman": false,
+		"ftp://host:5985/wsman":    false,
+	} {
+		location, err := url.Parse(to)
+		c.Assert(err, IsNil)
+		c.Check(sameOrigin(from, location), Equals, same, Commentf("%s", to))
+	}
+
+	from, _ = url.Parse("https://host:5986/wsman")
+	to, _ := url.Parse("http://host:5986/wsman")
+	c.Assert(sameOrigin(from, to), Equals, false)
+}
@@ -0,0 +1,151 @@
+package winrm
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// envelope sizes accepted by default by the WinRM stacks, MaxEnvelopeSizekb being
+// 150 before WinRM 3.0 and 500 since
+const (
+	legacyMaxEnvelopeSize = 153600
+	maxEnvelopeSize       = 512000
+)
+
+// ServerInfo describes the WS-Management stack of the remote server, as returned by Identify
+type ServerInfo struct {
+	ProtocolVersion string
+	ProductVendor   string
+	ProductVersion  string
+	// WinRM stack version like "2.0" or "3.0", empty if not advertised by the server
+	StackVersion string
+}
+
+// parseVersion returns the major and minor parts of a version like "2.0", 0.0 when invalid
+func parseVersion(version string) (int, int) {
+	parts := strings.SplitN(version, ".", 2)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0
+	}
+	var minor int
+	if len(parts) == 2 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major, minor
+}
+
+// atLeast tells if the server stack version is at least major.minor
+func (i *ServerInfo) atLeast(major, minor int) bool {
+	stackMajor, stackMinor := parseVersion(i.StackVersion)
+	return stackMajor > major || (stackMajor == major && stackMinor >= minor)
+}
+
+// SupportsRobustConnections tells if the server can disconnect and reconnect shells (WinRM 3.0+)
+func (i *ServerInfo) SupportsRobustConnections() bool {
+	return i.atLeast(3, 0)
+}
+
+// SupportsCompression tells if the server accepts compressed shell streams (WinRM 2.0+)
+func (i *ServerInfo) SupportsCompression() bool {
+	return i.atLeast(2, 0)
+}
+
+// MaxEnvelopeSize returns the default maximum envelope size accepted by the server
+func (i *ServerInfo) MaxEnvelopeSize() int {
+	if i.atLeast(3, 0) {
+		return maxEnvelopeSize
+	}
+	return legacyMaxEnvelopeSize
+}
+
+// serverIdentity holds the result of Identify, shared by a client and the ones derived from it
+// with WithParams, which can run commands concurrently
+type serverIdentity struct {
+	mutex sync.Mutex
+	info  *ServerInfo
+}
+
+func (i *serverIdentity) get() *ServerInfo {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.info
+}
+
+func (i *serverIdentity) set(info *ServerInfo) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.info = info
+}
+
+// requestParameters returns the parameters the requests are built with,
+// their EnvelopeSize being lowered to what the server accepts once identified
+func (c *Client) requestParameters() *Parameters {
+	info := c.identity.get()
+	if info == nil || c.EnvelopeSize <= info.MaxEnvelopeSize() {
+		return &c.Parameters
+	}
+	params := c.Parameters
+	params.EnvelopeSize = info.MaxEnvelopeSize()
+	return &params
+}
+
+// Identify sends a WS-Management Identify request to the server, remembering
+// the result which is then available through ServerInfo.
+// The requests then use an envelope size no larger than the server accepts,
+// Parameters.EnvelopeSize being left as is. Identify can be called at any time,
+// the commands already running picking it up with their next request.
+func (c *Client) Identify() (*ServerInfo, error) {
+	return c.IdentifyWithContext(context.Background())
+}
+
+// IdentifyWithContext is Identify, aborted when ctx is canceled
+func (c *Client) IdentifyWithContext(ctx context.Context) (*ServerInfo, error) {
+	request := NewIdentifyRequest()
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := ParseIdentifyResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	c.identity.set(info)
+
+	return info, nil
+}
+
+// ServerInfo returns what Identify detected about the server, or nil if it hasn't been called
+func (c *Client) ServerInfo() *ServerInfo {
+	return c.identity.get()
+}
+
+// RequireVersion checks that the server stack version is at least version (like "3.0"),
+// calling Identify first if needed. It returns a *ProtocolVersionError otherwise,
+// so features can fall back or fail clearly on older servers.
+func (c *Client) RequireVersion(version string) error {
+	return c.RequireVersionWithContext(context.Background(), version)
+}
+
+// RequireVersionWithContext is RequireVersion, its Identify request being aborted when ctx is canceled
+func (c *Client) RequireVersionWithContext(ctx context.Context, version string) error {
+	info := c.identity.get()
+	if info == nil {
+		var err error
+		if info, err = c.IdentifyWithContext(ctx); err != nil {
+			return err
+		}
+	}
+
+	if info.StackVersion == "" || !info.atLeast(parseVersion(version)) {
+		return &ProtocolVersionError{Required: version, Advertised: info.StackVersion}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestIdentifyRequest(c *C) {
+	request := NewIdentifyRequest()
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//env:Body/wsmid:Identify", "")
+	assertXPathNil(c, request.Doc(), "//a:Action")
+}
+
+func (s *WinRMSuite) TestParseIdentifyResponse(c *C) {
+	info, err := ParseIdentifyResponse(identifyResponse)
+	c.Assert(err, IsNil)
+	c.Assert(info.ProductVendor, Equals, "Microsoft Corporation")
+	c.Assert(info.StackVersion, Equals, "2.0")
+	c.Assert(info.SupportsCompression(), Equals, true)
+	c.Assert(info.SupportsRobustConnections(), Equals, false)
+	c.Assert(info.MaxEnvelopeSize(), Equals, 153600)
+	c.Assert((&ServerInfo{StackVersion: "3.0"}).MaxEnvelopeSize(), Equals, 512000)
+
+	_, err = ParseIdentifyResponse(createShellResponse)
+	c.Assert(err, NotNil)
+}
+
+func (s *WinRMSuite) TestClientIdentifyDegradesEnvelopeSize(c *C) {
+	params := NewParameters("PT60S", "en-US", 1024000)
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+	c.Assert(client.ServerInfo(), IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		c.Assert(message.String(), Contains, "wsmid:Identify")
+		return identifyResponse, nil
+	}
+	client.http = &r
+
+	info, err := client.Identify()
+	c.Assert(err, IsNil)
+	c.Assert(client.ServerInfo(), Equals, info)
+	c.Assert(client.EnvelopeSize, Equals, 1024000)
+	c.Assert(client.requestParameters().EnvelopeSize, Equals, 153600)
+
+	// the derived clients share the server information
+	derived := client.WithParams(&Parameters{Timeout: "PT10S"})
+	c.Assert(derived.ServerInfo(), Equals, info)
+	c.Assert(derived.requestParameters().EnvelopeSize, Equals, 153600)
+}
+
+func (s *WinRMSuite) TestClientIdentifyConcurrentCommands(c *C) {
+	params := NewParameters("PT60S", "en-US", 1024000)
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, "wsmid:Identify"):
+			return identifyResponse, nil
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return doneOutputResponse("ok", 0), nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := client.Identify()
+			c.Check(err, IsNil)
+		}()
+		go func() {
+			defer wg.Done()
+			stdout, _, _, err := client.RunCmdWithContext(context.Background(), "hostname")
+			c.Check(err, IsNil)
+			c.Check(stdout, Equals, "ok")
+		}()
+	}
+	wg.Wait()
+	c.Assert(client.requestParameters().EnvelopeSize, Equals, 153600)
+}
+
+func (s *WinRMSuite) TestClientRequireVersion(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	identified := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		identified++
+		return identifyResponse, nil
+	}
+	client.http = &r
+
+	c.Assert(client.RequireVersion("2.0"), IsNil)
+	err = client.RequireVersion("3.0")
+	var versionErr *ProtocolVersionError
+	c.Assert(errors.As(err, &versionErr), Equals, true)
+	c.Assert(versionErr.Required, Equals, "3.0")
+	c.Assert(versionErr.Advertised, Equals, "2.0")
+	c.Assert(identified, Equals, 1)
+}
+
+func (s *WinRMSuite) TestClientRequireVersionWithContext(c *C) {
+	client, err := NewClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	client.ResourceURI = ResourceURIPowerShell
+
+	var deadline bool
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		return identifyResponse, nil
+	}
+	client.http = &r
+	client.Middlewares = []Middleware{func(next PostFunc) PostFunc {
+		return func(ctx context.Context, request *soap.SoapMessage) (string, error) {
+			_, deadline = ctx.Deadline()
+			return next(ctx, request)
+		}
+	}}
+
+	// the Identify request of Disconnect gets its context
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var versionErr *ProtocolVersionError
+	c.Assert(errors.As(client.NewShell("id").Disconnect(ctx, 0), &versionErr), Equals, true)
+	c.Assert(deadline, Equals, true)
+}
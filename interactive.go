@@ -0,0 +1,241 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// InteractiveSession drives a single command of a remote shell, like cmd.exe or powershell.exe
+// waiting for its input, with the API of golang.org/x/crypto/ssh.Session so that tools
+// supporting SSH sessions can support WinRM with little changes.
+// The input written to Stdin or the StdinPipe is sent to the command as it comes.
+type InteractiveSession struct {
+	// Stdin, Stdout and Stderr are the input and outputs of the command, set before Start.
+	// The outputs are discarded when nil.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	shell *Shell
+	// stop unregisters the cancellation of the session by the context of NewInteractiveSession
+	stop func() bool
+
+	mutex sync.Mutex
+	cmd   *Command
+	// cancelCmd cancels the context of the command, canceled is the cause of the session cancellation
+	cancelCmd context.CancelCauseFunc
+	canceled  error
+	copies    sync.WaitGroup
+	// the reader of the input pipe, closed once the command terminated
+	stdinPipe *io.PipeReader
+	// the writers of the output pipes, closed at the end of the output
+	stdoutPipe *io.PipeWriter
+	stderrPipe *io.PipeWriter
+}
+
+// NewInteractiveSession opens a shell on the remote host for an InteractiveSession,
+// whose command is canceled when ctx is
+func (c *Client) NewInteractiveSession(ctx context.Context) (*InteractiveSession, error) {
+	shell, err := c.CreateShellWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &InteractiveSession{shell: shell}
+	session.stop = context.AfterFunc(ctx, func() {
+		session.cancel(context.Cause(ctx))
+	})
+	return session, nil
+}
+
+// cancel cancels the command of the session, and the one started later, with cause
+func (s *InteractiveSession) cancel(cause error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.canceled = cause
+	if s.cancelCmd != nil {
+		s.cancelCmd(cause)
+	}
+}
+
+var (
+	errSessionStarted    = errors.New("the session is already started")
+	errSessionNotStarted = errors.New("the session isn't started")
+)
+
+// StdinPipe returns a pipe connected to the command input, which is closed
+// (sending the end of file) when Close is called
+func (s *InteractiveSession) StdinPipe() (io.WriteCloser, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Stdin != nil {
+		return nil, errors.New("Stdin is already set")
+	}
+	if s.cmd != nil {
+		return nil, errSessionStarted
+	}
+
+	reader, writer := io.Pipe()
+	s.Stdin, s.stdinPipe = reader, reader
+	return writer, nil
+}
+
+// StdoutPipe returns a pipe connected to the command stdout, closed when the command terminates
+func (s *InteractiveSession) StdoutPipe() (io.Reader, error) {
+	return s.outputPipe(&s.Stdout, &s.stdoutPipe)
+}
+
+// StderrPipe returns a pipe connected to the command stderr, closed when the command terminates
+func (s *InteractiveSession) StderrPipe() (io.Reader, error) {
+	return s.outputPipe(&s.Stderr, &s.stderrPipe)
+}
+
+func (s *InteractiveSession) outputPipe(output *io.Writer, pipe **io.PipeWriter) (io.Reader, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if *output != nil {
+		return nil, errors.New("the output is already set")
+	}
+	if s.cmd != nil {
+		return nil, errSessionStarted
+	}
+
+	reader, writer := io.Pipe()
+	*output, *pipe = writer, writer
+	return reader, nil
+}
+
+// Start runs command in the session shell, without waiting for its termination
+func (s *InteractiveSession) Start(command string) error {
+	return s.StartWithContext(context.Background(), command)
+}
+
+// StartWithContext runs command in the session shell, without waiting for its termination.
+// The command is canceled when ctx or the context of NewInteractiveSession is.
+// The copy of Stdin ends with the command: the input pipe is closed, and another reader is
+// given up on at its next Read.
+func (s *InteractiveSession) StartWithContext(ctx context.Context, command string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cmd != nil {
+		return errSessionStarted
+	}
+	if s.canceled != nil {
+		return s.canceled
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	cmd, err := s.shell.ExecuteWithContext(ctx, command)
+	if err != nil {
+		cancel(nil)
+		return err
+	}
+	s.cmd, s.cancelCmd = cmd, cancel
+
+	if s.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(cmd.Stdin, s.Stdin)
+			_ = cmd.Stdin.Close()
+		}()
+	}
+	s.copy(s.Stdout, cmd.Stdout, s.stdoutPipe)
+	s.copy(s.Stderr, cmd.Stderr, s.stderrPipe)
+
+	stdinPipe := s.stdinPipe
+	s.copies.Add(1)
+	go func() {
+		defer s.copies.Done()
+		<-cmd.done
+		cancel(nil)
+		if stdinPipe != nil {
+			_ = stdinPipe.Close()
+		}
+	}()
+
+	return nil
+}
+
+// copy copies the output of the command to w in the background,
+// closing pipe at the end when w is the writer of an output pipe
+func (s *InteractiveSession) copy(w io.Writer, output *commandReader, pipe *io.PipeWriter) {
+	if w == nil {
+		w = io.Discard
+	}
+	if pipe != w {
+		pipe = nil
+	}
+	s.copies.Add(1)
+	go func() {
+		defer s.copies.Done()
+		_, err := io.Copy(w, output)
+		if pipe != nil {
+			_ = pipe.CloseWithError(err)
+		}
+	}()
+}
+
+// Wait waits for the command termination and the copy of its output,
+// returning its error. The exit code is then given by ExitCode.
+func (s *InteractiveSession) Wait() error {
+	s.mutex.Lock()
+	cmd := s.cmd
+	s.mutex.Unlock()
+	if cmd == nil {
+		return errSessionNotStarted
+	}
+
+	cmd.Wait()
+	s.copies.Wait()
+
+	return cmd.err
+}
+
+// Run runs command in the session shell and waits for its termination
+func (s *InteractiveSession) Run(command string) error {
+	if err := s.Start(command); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+// ExitCode returns the exit code of the command once Wait returned
+func (s *InteractiveSession) ExitCode() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cmd == nil {
+		return 0
+	}
+	return s.cmd.ExitCode()
+}
+
+// Signal sends sig (SignalCtrlC, SignalCtrlBreak or SignalTerminate) to the command
+func (s *InteractiveSession) Signal(sig string) error {
+	s.mutex.Lock()
+	cmd := s.cmd
+	s.mutex.Unlock()
+	if cmd == nil {
+		return errSessionNotStarted
+	}
+
+	return cmd.Signal(sig)
+}
+
+// Close terminates the command if it's still running, and closes the session shell
+func (s *InteractiveSession) Close() error {
+	s.stop()
+	s.mutex.Lock()
+	cmd := s.cmd
+	s.mutex.Unlock()
+
+	if cmd != nil {
+		select {
+		case <-cmd.done:
+		default:
+			_ = cmd.Close()
+		}
+	}
+
+	return s.shell.Close()
+}
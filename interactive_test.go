@@ -0,0 +1,116 @@
+package winrm
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestInteractiveSession(c *C) {
+	client, err := NewClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b")
+	c.Assert(err, IsNil)
+
+	stdinStream := regexp.MustCompile(`<rsp:Stream Name="stdin"[^>]*>([^<]*)</rsp:Stream>`)
+	input := make(chan string, 10)
+	var deleted bool
+	r := &Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionDelete):
+			deleted = true
+			return "", nil
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionSend):
+			if m := stdinStream.FindStringSubmatch(body); m != nil && m[1] != "" {
+				data, _ := base64.StdEncoding.DecodeString(m[1])
+				input <- string(data)
+			}
+			return "", nil
+		case strings.Contains(body, ActionReceive):
+			// the prompt answers the first line it reads
+			return doneOutputResponse("you said "+<-input, 0), nil
+		}
+		return "", nil
+	}
+	client.http = r
+
+	session, err := client.NewInteractiveSession(context.Background())
+	c.Assert(err, IsNil)
+	stdin, err := session.StdinPipe()
+	c.Assert(err, IsNil)
+	stdout, err := session.StdoutPipe()
+	c.Assert(err, IsNil)
+	c.Assert(session.Wait(), ErrorMatches, "the session isn't started")
+
+	c.Assert(session.Start("cmd.exe"), IsNil)
+	c.Assert(session.Start("cmd.exe"), ErrorMatches, "the session is already started")
+	_, err = session.StdoutPipe()
+	c.Assert(err, NotNil)
+
+	_, err = io.WriteString(stdin, "hello\r\n")
+	c.Assert(err, IsNil)
+	output, err := io.ReadAll(stdout)
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, "you said hello\r\n")
+	c.Assert(session.Wait(), IsNil)
+	c.Assert(session.ExitCode(), Equals, 0)
+
+	// the input pipe is closed with the command, releasing the copy of Stdin
+	_, err = io.WriteString(stdin, "bye\r\n")
+	c.Assert(err, Equals, io.ErrClosedPipe)
+	c.Assert(stdin.Close(), IsNil)
+	c.Assert(session.Close(), IsNil)
+	c.Assert(deleted, Equals, true)
+}
+
+func (s *WinRMSuite) TestInteractiveSessionCanceled(c *C) {
+	client, err := NewClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b")
+	c.Assert(err, IsNil)
+	r := &Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return "", errors.New("OperationTimeout")
+		}
+		return "", nil
+	}
+	client.http = r
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session, err := client.NewInteractiveSession(ctx)
+	c.Assert(err, IsNil)
+	stdin, err := session.StdinPipe()
+	c.Assert(err, IsNil)
+	c.Assert(session.Start("cmd.exe"), IsNil)
+
+	// canceling the context of the session cancels its command
+	cancel()
+	c.Assert(errors.Is(session.Wait(), context.Canceled), Equals, true)
+	_, err = io.WriteString(stdin, "dir\r\n")
+	c.Assert(err, Equals, io.ErrClosedPipe)
+	c.Assert(session.Close(), IsNil)
+
+	// and the command started afterwards, the cancellation being asynchronous
+	ctx, cancel = context.WithCancel(context.Background())
+	session, err = client.NewInteractiveSession(ctx)
+	c.Assert(err, IsNil)
+	cancel()
+	if err = session.Start("cmd.exe"); err == nil {
+		err = session.Wait()
+	}
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+	c.Assert(session.Close(), IsNil)
+}
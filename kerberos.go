@@ -1,32 +1,57 @@
 package winrm
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/satendraraj/winrm/soap"
 
 	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
 )
 
 // Settings holds all the information necessary to configure the provider
 type Settings struct {
-	WinRMUsername        string
-	WinRMPassword        string
-	WinRMHost            string
-	WinRMPort            int
-	WinRMProto           string
-	WinRMInsecure        bool
-	KrbRealm             string
-	KrbConfig            string
-	KrbSpn               string
-	KrbCCache            string
+	WinRMUsername string
+	WinRMPassword string
+	WinRMHost     string
+	WinRMPort     int
+	WinRMProto    string
+	WinRMInsecure bool
+	KrbRealm      string
+	KrbConfig     string
+	KrbSpn        string
+	KrbCCache     string
+	// KrbKDC lists the KDCs (host or host:port) of KrbRealm, overriding the ones of KrbConfig,
+	// which can then be left empty
+	KrbKDC []string
+	// KrbKeytab is the path of a keytab authenticating WinRMUsername instead of WinRMPassword
+	KrbKeytab string
+	// KrbTicketLifetime, KrbRenewLifetime and KrbCacheLifetime set the ClientKerberos
+	// TicketLifetime, RenewLifetime and CacheLifetime
+	KrbTicketLifetime time.Duration
+	KrbRenewLifetime  time.Duration
+	KrbCacheLifetime  time.Duration
+	// KrbChannelBinding sets the ClientKerberos ChannelBinding
+	KrbChannelBinding    bool
 	WinRMUseNTLM         bool
 	WinRMPassCredentials bool
 }
@@ -42,87 +67,378 @@ type ClientKerberos struct {
 	SPN       string
 	KrbConf   string
 	KrbCCache string
+	KDC       []string
+	// KeytabPath or Keytab (the content of a keytab file) authenticate Username
+	// with its keys instead of Password
+	KeytabPath string
+	Keytab     []byte
+	// TicketLifetime and RenewLifetime are the lifetimes requested for the tickets,
+	// overriding the ticket_lifetime and renew_lifetime of KrbConf when not zero
+	TicketLifetime time.Duration
+	RenewLifetime  time.Duration
+	// CacheLifetime bounds the time the tickets are reused across requests, the TGT
+	// being renewed before it expires in the meantime. With zero, they are kept until
+	// they can't be renewed anymore; a negative value disables the cache, every request
+	// then running the complete AS and TGS exchanges.
+	CacheLifetime time.Duration
+	// ChannelBinding binds the authentication to the TLS channel with a token derived from
+	// the server certificate (Extended Protection for Authentication), which endpoints with
+	// CbtHardeningLevel set to Strict require. It only applies to HTTPS endpoints: the first
+	// request is sent without credentials to get the certificate, then again authenticated.
+	ChannelBinding bool
+
+	mutex   sync.Mutex
+	session *kerberosSession
+	// bindings is the channel bindings hash of the last server certificate
+	bindings []byte
+}
+
+// kerberosSession is a Kerberos client holding the tickets of a principal and password (key), with the
+// number of requests using it: a replaced session is destroyed once its last request finished
+type kerberosSession struct {
+	client   *client.Client
+	key      string
+	created  time.Time
+	users    int
+	replaced bool
 }
 
 func NewClientKerberos(settings *Settings) *ClientKerberos {
 	return &ClientKerberos{
-		Username:  settings.WinRMUsername,
-		Password:  settings.WinRMPassword,
-		Realm:     settings.KrbRealm,
-		Hostname:  settings.WinRMHost,
-		Port:      settings.WinRMPort,
-		Proto:     settings.WinRMProto,
-		KrbConf:   settings.KrbConfig,
-		KrbCCache: settings.KrbCCache,
-		SPN:       settings.KrbSpn,
+		Username:   settings.WinRMUsername,
+		Password:   settings.WinRMPassword,
+		Realm:      settings.KrbRealm,
+		Hostname:   settings.WinRMHost,
+		Port:       settings.WinRMPort,
+		Proto:      settings.WinRMProto,
+		KrbConf:    settings.KrbConfig,
+		KrbCCache:  settings.KrbCCache,
+		SPN:        settings.KrbSpn,
+		KDC:        settings.KrbKDC,
+		KeytabPath: settings.KrbKeytab,
+
+		TicketLifetime: settings.KrbTicketLifetime,
+		RenewLifetime:  settings.KrbRenewLifetime,
+		CacheLifetime:  settings.KrbCacheLifetime,
+		ChannelBinding: settings.KrbChannelBinding,
+	}
+}
+
+// NewKerberosClient creates a client authenticating to endpoint with Kerberos, configured by settings.
+// The requests go to endpoint whatever the WinRMHost, WinRMPort and WinRMProto of settings,
+// and the username and password are the WinRMUsername and WinRMPassword of settings.
+func NewKerberosClient(endpoint *Endpoint, settings *Settings, params *Parameters) (*Client, error) {
+	if params == nil {
+		params = DefaultParameters
+	}
+
+	transportSettings := *settings
+	params = params.Builder().TransportDecorator(func() Transporter {
+		return NewClientKerberos(&transportSettings)
+	}).Build()
+
+	return NewClientWithParameters(endpoint, settings.WinRMUsername, settings.WinRMPassword, params)
+}
+
+// config loads the Kerberos configuration of KrbConf, or the defaults without it,
+// replacing the KDCs of realm by the KDC field ones
+func (c *ClientKerberos) config(realm string) (*config.Config, error) {
+	cfg := config.New()
+	if c.KrbConf != "" {
+		var err error
+		if cfg, err = config.Load(c.KrbConf); err != nil {
+			return nil, err
+		}
+	}
+	if c.TicketLifetime > 0 {
+		cfg.LibDefaults.TicketLifetime = c.TicketLifetime
+	}
+	if c.RenewLifetime > 0 {
+		cfg.LibDefaults.RenewLifetime = c.RenewLifetime
+	}
+	if len(c.KDC) == 0 {
+		return cfg, nil
+	}
+
+	kdcs := make([]string, 0, len(c.KDC))
+	for _, kdc := range c.KDC {
+		if _, _, err := net.SplitHostPort(kdc); err != nil {
+			kdc = net.JoinHostPort(kdc, "88")
+		}
+		kdcs = append(kdcs, kdc)
+	}
+
+	if cfg.LibDefaults.DefaultRealm == "" {
+		cfg.LibDefaults.DefaultRealm = realm
+	}
+	cfg.LibDefaults.DNSLookupKDC = false
+	for i := range cfg.Realms {
+		if cfg.Realms[i].Realm == realm {
+			cfg.Realms[i].KDC = kdcs
+			return cfg, nil
+		}
 	}
+	cfg.Realms = append(cfg.Realms, config.Realm{Realm: realm, KDC: kdcs})
+
+	return cfg, nil
 }
 
 func (c *ClientKerberos) Transport(endpoint *Endpoint) error {
+	if c.SPN == "" {
+		c.SPN = endpoint.SPN
+	}
 	return c.clientRequest.Transport(endpoint)
 }
 
-func (c *ClientKerberos) Post(clt *Client, request *soap.SoapMessage) (string, error) {
-	cfg, err := config.Load(c.KrbConf)
+// Reauthenticate drops the cached tickets, and closes the idle connections
+func (c *ClientKerberos) Reauthenticate() {
+	c.mutex.Lock()
+	c.dropSession()
+	c.mutex.Unlock()
+
+	c.clientRequest.Reauthenticate()
+}
+
+// dropSession stops caching the current session, destroying it unless requests still use it.
+// The caller must hold the mutex.
+func (c *ClientKerberos) dropSession() {
+	if c.session == nil {
+		return
+	}
+	c.session.replaced = true
+	if c.session.users == 0 {
+		c.session.client.Destroy()
+	}
+	c.session = nil
+}
+
+// acquireSession returns the Kerberos session of username@realm holding the tickets
+// of the previous requests, setting up a new one when there is none, it is too old or
+// the password changed. It must be given back with releaseSession once the request is done.
+func (c *ClientKerberos) acquireSession(username, realm, password string) (*kerberosSession, error) {
+	if c.CacheLifetime < 0 {
+		cfg, err := c.config(realm)
+		if err != nil {
+			return nil, err
+		}
+		kerberosClient, err := c.kerberosClient(username, realm, password, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &kerberosSession{client: kerberosClient, users: 1, replaced: true}, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := username + "@" + realm + "\x00" + password
+	if s := c.session; s != nil && s.key == key && (c.CacheLifetime == 0 || time.Since(s.created) < c.CacheLifetime) {
+		s.users++
+		return s, nil
+	}
+	c.dropSession()
+
+	cfg, err := c.config(realm)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	kerberosClient, err := c.kerberosClient(username, realm, password, cfg)
+	if err != nil {
+		return nil, err
 	}
+	c.session = &kerberosSession{client: kerberosClient, key: key, created: time.Now(), users: 1}
+
+	return c.session, nil
+}
 
-	// setup the kerberos client
-	var kerberosClient *client.Client
-	if len(c.KrbCCache) > 0 {
+// releaseSession gives back a session acquired for a request,
+// destroying it if it was replaced meanwhile and this was its last request
+func (c *ClientKerberos) releaseSession(session *kerberosSession) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if session.users--; session.users == 0 && session.replaced {
+		session.client.Destroy()
+	}
+}
+
+// kerberosClient sets up the Kerberos client from the credentials cache,
+// the keytab or the password, in this order
+func (c *ClientKerberos) kerberosClient(username, realm, password string, cfg *config.Config) (*client.Client, error) {
+	switch {
+	case len(c.KrbCCache) > 0:
 		b, err := os.ReadFile(c.KrbCCache)
 		if err != nil {
-			return "", fmt.Errorf("unable to read ccache file %s: %w", c.KrbCCache, err)
+			return nil, fmt.Errorf("unable to read ccache file %s: %w", c.KrbCCache, err)
 		}
 
 		cc := new(credentials.CCache)
 		err = cc.Unmarshal(b)
 		if err != nil {
-			return "", fmt.Errorf("unable to parse ccache file %s: %w", c.KrbCCache, err)
+			return nil, fmt.Errorf("unable to parse ccache file %s: %w", c.KrbCCache, err)
 		}
-		kerberosClient, err = client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
+		kerberosClient, err := client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
 		if err != nil {
-			return "", fmt.Errorf("unable to create kerberos client from ccache: %w", err)
+			return nil, fmt.Errorf("unable to create kerberos client from ccache: %w", err)
+		}
+		return kerberosClient, nil
+	case len(c.Keytab) > 0 || c.KeytabPath != "":
+		kt := keytab.New()
+		b := c.Keytab
+		if len(b) == 0 {
+			var err error
+			if b, err = os.ReadFile(c.KeytabPath); err != nil {
+				return nil, fmt.Errorf("unable to read keytab file %s: %w", c.KeytabPath, err)
+			}
+		}
+		if err := kt.Unmarshal(b); err != nil {
+			return nil, fmt.Errorf("unable to parse keytab: %w", err)
 		}
-	} else {
-		kerberosClient = client.NewWithPassword(c.Username, c.Realm, c.Password, cfg,
-			client.DisablePAFXFAST(true), client.AssumePreAuthentication(true))
+		return client.NewWithKeytab(username, realm, kt, cfg, client.DisablePAFXFAST(true)), nil
 	}
 
-	//create an http request
-	winrmURL := fmt.Sprintf("%s://%s:%d/wsman", c.Proto, c.Hostname, c.Port)
-	//nolint:noctx
-	winRMRequest, _ := http.NewRequest("POST", winrmURL, strings.NewReader(request.String()))
-	winRMRequest.Header.Add("Content-Type", "application/soap+xml;charset=UTF-8")
+	return client.NewWithPassword(username, realm, password, cfg,
+		client.DisablePAFXFAST(true), client.AssumePreAuthentication(true)), nil
+}
 
-	err = spnego.SetSPNEGOHeader(kerberosClient, winRMRequest, c.SPN)
-	if err != nil {
-		return "", fmt.Errorf("unable to set SPNego Header: %w", err)
+// principal returns the username, realm and password authenticating the requests of clt: the ones
+// of the transport, or of the client when it has a CredentialProvider or the transport has no Username
+func (c *ClientKerberos) principal(ctx context.Context, clt *Client) (username, realm, password string, err error) {
+	username, password = c.Username, c.Password
+	if clt.CredentialProvider != nil || username == "" {
+		if username, password, err = clt.credentials(ctx); err != nil {
+			return "", "", "", fmt.Errorf("getting the credentials: %w", err)
+		}
 	}
+	username, realm = kerberosPrincipal(username, clt.domain)
+	if c.Realm != "" {
+		realm = c.Realm
+	}
+	return username, realm, password, nil
+}
 
-	httpClient := &http.Client{Transport: c.transport}
+// Post authenticates with the Username and Password of the transport, or the credentials of the client
+// when it has a Parameters.CredentialProvider or the transport has no Username
+func (c *ClientKerberos) Post(ctx context.Context, clt *Client, request *soap.SoapMessage) (string, error) {
+	username, realm, password, err := c.principal(ctx, clt)
+	if err != nil {
+		return "", err
+	}
 
-	resp, err := httpClient.Do(winRMRequest)
+	session, err := c.acquireSession(username, realm, password)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
+	defer c.releaseSession(session)
+	kerberosClient := session.client
 
-	if resp.StatusCode != 200 {
-		var bodyMsg string
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			bodyMsg = fmt.Sprintf("Error retrieving the response's body: %s", err)
-		} else {
-			bodyMsg = fmt.Sprintf("Response body:\n%s", string(respBody))
+	httpClient := c.httpClient()
+
+	return post(ctx, clt, request, func(winRMRequest *http.Request) (*http.Response, error) {
+		if c.ChannelBinding && winRMRequest.URL.Scheme == "https" {
+			return c.doWithChannelBinding(kerberosClient, httpClient, winRMRequest)
+		}
+		if err := spnego.SetSPNEGOHeader(kerberosClient, winRMRequest, c.SPN); err != nil {
+			return nil, fmt.Errorf("unable to set SPNego Header: %w", err)
 		}
-		return "", fmt.Errorf("request returned: %d - %s. %s", resp.StatusCode, resp.Status, bodyMsg)
+		return httpClient.Do(winRMRequest)
+	})
+}
+
+// doWithChannelBinding sends req authenticated with a token bound to the certificate of the
+// server. The certificate being only known once connected, a request rejected with the one of
+// the previous requests, or none, is sent again bound to the certificate of the response.
+func (c *ClientKerberos) doWithChannelBinding(kerberosClient *client.Client, httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	c.mutex.Lock()
+	bindings := c.bindings
+	c.mutex.Unlock()
+
+	if bindings != nil {
+		if err := c.setBoundSPNEGOHeader(kerberosClient, req, bindings); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || resp.TLS == nil ||
+		len(resp.TLS.PeerCertificates) == 0 || req.GetBody == nil {
+		return resp, err
+	}
+	serverBindings := channelBindingsHash(resp.TLS.PeerCertificates[0])
+	if bytes.Equal(serverBindings, bindings) {
+		return resp, nil
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	c.mutex.Lock()
+	c.bindings = serverBindings
+	c.mutex.Unlock()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if req.Body, err = req.GetBody(); err != nil {
+		return nil, err
+	}
+	if err := c.setBoundSPNEGOHeader(kerberosClient, req, serverBindings); err != nil {
+		return nil, err
+	}
+	return httpClient.Do(req)
+}
+
+// setBoundSPNEGOHeader sets the SPNEGO Authorization header of req like spnego.SetSPNEGOHeader,
+// the checksum of the authenticator holding the channel bindings hash (RFC 4121 4.1.1)
+func (c *ClientKerberos) setBoundSPNEGOHeader(kerberosClient *client.Client, req *http.Request, bindings []byte) error {
+	spn := c.servicePrincipal(req.URL)
+	ticket, sessionKey, err := kerberosClient.GetServiceTicket(spn)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("unable to get the service ticket of %s: %w", spn, err)
+	}
+	token, _, err := apReqSPNEGOToken(kerberosClient, ticket, sessionKey, gssapi.ContextFlagInteg|gssapi.ContextFlagConf, nil, bindings)
+	if err != nil {
+		return fmt.Errorf("unable to set SPNego Header: %w", err)
+	}
+	req.Header.Set(spnego.HTTPHeaderAuthRequest, "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	return nil
+}
+
+// servicePrincipal returns the SPN of the transport, HTTP/host of u when it has none
+func (c *ClientKerberos) servicePrincipal(u *url.URL) string {
+	if c.SPN != "" {
+		return c.SPN
+	}
+	return "HTTP/" + strings.TrimSuffix(u.Hostname(), ".")
+}
+
+// apReqSPNEGOToken returns the SPNEGO token of an AP-REQ for ticket with the GSS-API flags and the
+// AP options, the checksum of its authenticator holding the channel bindings hash when not nil.
+// The authenticator is returned for its sequence number.
+func apReqSPNEGOToken(kerberosClient *client.Client, ticket messages.Ticket, sessionKey types.EncryptionKey,
+	flags int, apOptions []int, bindings []byte) ([]byte, types.Authenticator, error) {
+	krb5Token, err := spnego.NewKRB5TokenAPREQ(kerberosClient, ticket, sessionKey, []int{flags}, nil)
+	if err != nil {
+		return nil, types.Authenticator{}, err
+	}
+
+	authenticator, err := types.NewAuthenticator(kerberosClient.Credentials.Domain(), kerberosClient.Credentials.CName())
+	if err != nil {
+		return nil, types.Authenticator{}, err
+	}
+	checksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(checksum, 16)
+	copy(checksum[4:20], bindings)
+	binary.LittleEndian.PutUint32(checksum[20:], uint32(flags))
+	authenticator.Cksum = types.Checksum{CksumType: chksumtype.GSSAPI, Checksum: checksum}
+	if krb5Token.APReq, err = messages.NewAPReq(ticket, sessionKey, authenticator); err != nil {
+		return nil, types.Authenticator{}, err
+	}
+	for _, option := range apOptions {
+		types.SetFlag(&krb5Token.APReq.APOptions, option)
+	}
+
+	var negTokenInit spnego.NegTokenInit
+	negTokenInit.MechTypes = append(negTokenInit.MechTypes, gssapi.OIDKRB5.OID())
+	if negTokenInit.MechTokenBytes, err = krb5Token.Marshal(); err != nil {
+		return nil, types.Authenticator{}, err
 	}
-	return string(body), err
+	token := spnego.SPNEGOToken{Init: true, NegTokenInit: negTokenInit}
+	b, err := token.Marshal()
+	return b, authenticator, err
 }
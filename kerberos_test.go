@@ -0,0 +1,217 @@
+package winrm
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestKerberosConfigKDC(c *C) {
+	transport := &ClientKerberos{KDC: []string{"dc1.corp.example.com", "dc2.corp.example.com:1088"}}
+	cfg, err := transport.config("CORP.EXAMPLE.COM")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.LibDefaults.DefaultRealm, Equals, "CORP.EXAMPLE.COM")
+	_, kdcs, err := cfg.GetKDCs("CORP.EXAMPLE.COM", true)
+	c.Assert(err, IsNil)
+	c.Assert(kdcs, HasLen, 2)
+	c.Assert(kdcs[1]+" "+kdcs[2], Matches, `(dc1.corp.example.com:88 dc2.corp.example.com:1088|dc2.corp.example.com:1088 dc1.corp.example.com:88)`)
+}
+
+func (s *WinRMSuite) TestNewKerberosClient(c *C) {
+	endpoint := NewEndpoint("srv-win", 5986, true, false, nil, nil, nil, 0)
+	client, err := NewKerberosClient(endpoint, &Settings{
+		WinRMUsername: "alice",
+		WinRMPassword: "s3cr3t",
+		KrbRealm:      "CORP.EXAMPLE.COM",
+		KrbKDC:        []string{"dc1"},
+	}, nil)
+	c.Assert(err, IsNil)
+
+	transport, ok := client.http.(*ClientKerberos)
+	c.Assert(ok, Equals, true)
+	c.Assert(transport.KDC, DeepEquals, []string{"dc1"})
+	c.Assert(client.username, Equals, "alice")
+
+	// the realm of the transport replaces the one of the username
+	transport.Username = "alice@corp.example.org"
+	username, realm, _, err := transport.principal(context.Background(), client)
+	c.Assert(err, IsNil)
+	c.Assert(username, Equals, "alice")
+	c.Assert(realm, Equals, "CORP.EXAMPLE.COM")
+}
+
+func (s *WinRMSuite) TestKerberosEndpointSPN(c *C) {
+	endpoint := NewEndpoint("winrm-lb.corp.example.com", 5985, false, false, nil, nil, nil, 0)
+	endpoint.SPN = "HTTP/srv-win.corp.example.com"
+
+	transport := &ClientKerberos{}
+	c.Assert(transport.Transport(endpoint), IsNil)
+	c.Assert(transport.SPN, Equals, "HTTP/srv-win.corp.example.com")
+
+	transport = &ClientKerberos{SPN: "HTTP/other.corp.example.com"}
+	c.Assert(transport.Transport(endpoint), IsNil)
+	c.Assert(transport.SPN, Equals, "HTTP/other.corp.example.com")
+}
+
+func (s *WinRMSuite) TestKerberosKeytab(c *C) {
+	kt := keytab.New()
+	c.Assert(kt.AddEntry("svc-deploy", "CORP.EXAMPLE.COM", "s3cr3t", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96), IsNil)
+	b, err := kt.Marshal()
+	c.Assert(err, IsNil)
+
+	transport := &ClientKerberos{Keytab: b}
+	cfg, err := transport.config("CORP.EXAMPLE.COM")
+	c.Assert(err, IsNil)
+	kerberosClient, err := transport.kerberosClient("svc-deploy", "CORP.EXAMPLE.COM", "", cfg)
+	c.Assert(err, IsNil)
+	c.Assert(kerberosClient.Credentials.HasKeytab(), Equals, true)
+	c.Assert(kerberosClient.Credentials.HasPassword(), Equals, false)
+
+	path := filepath.Join(c.MkDir(), "svc-deploy.keytab")
+	c.Assert(os.WriteFile(path, b, 0o600), IsNil)
+	transport = NewClientKerberos(&Settings{KrbKeytab: path})
+	kerberosClient, err = transport.kerberosClient("svc-deploy", "CORP.EXAMPLE.COM", "", cfg)
+	c.Assert(err, IsNil)
+	c.Assert(kerberosClient.Credentials.HasKeytab(), Equals, true)
+
+	transport = &ClientKerberos{KeytabPath: filepath.Join(c.MkDir(), "missing.keytab")}
+	_, err = transport.kerberosClient("svc-deploy", "CORP.EXAMPLE.COM", "", cfg)
+	c.Assert(err, ErrorMatches, "unable to read keytab file .*")
+}
+
+func (s *WinRMSuite) TestKerberosTicketCache(c *C) {
+	transport := NewClientKerberos(&Settings{
+		WinRMPassword:     "s3cr3t",
+		KrbKDC:            []string{"dc1"},
+		KrbTicketLifetime: 2 * time.Hour,
+		KrbRenewLifetime:  24 * time.Hour,
+	})
+	cfg, err := transport.config("CORP.EXAMPLE.COM")
+	c.Assert(err, IsNil)
+	c.Assert(cfg.LibDefaults.TicketLifetime, Equals, 2*time.Hour)
+	c.Assert(cfg.LibDefaults.RenewLifetime, Equals, 24*time.Hour)
+
+	acquire := func(username string) *kerberosSession {
+		session, err := transport.acquireSession(username, "CORP.EXAMPLE.COM", "s3cr3t")
+		c.Assert(err, IsNil)
+		transport.releaseSession(session)
+		return session
+	}
+
+	first := acquire("alice")
+	c.Assert(acquire("alice"), Equals, first)
+
+	other := acquire("bob")
+	c.Assert(other, Not(Equals), first)
+	c.Assert(first.client.Credentials.UserName(), Equals, "")
+
+	transport.Reauthenticate()
+	c.Assert(acquire("bob"), Not(Equals), other)
+
+	transport.CacheLifetime = time.Nanosecond
+	first = acquire("bob")
+	time.Sleep(time.Millisecond)
+	c.Assert(acquire("bob"), Not(Equals), first)
+
+	transport.CacheLifetime = -1
+	first = acquire("bob")
+	c.Assert(acquire("bob"), Not(Equals), first)
+	c.Assert(first.client.Credentials.UserName(), Equals, "")
+}
+
+func (s *WinRMSuite) TestKerberosSessionInUse(c *C) {
+	transport := NewClientKerberos(&Settings{WinRMPassword: "s3cr3t", KrbKDC: []string{"dc1"}})
+
+	session, err := transport.acquireSession("alice", "CORP.EXAMPLE.COM", "s3cr3t")
+	c.Assert(err, IsNil)
+
+	// a request still uses the session, which is only destroyed once released
+	transport.Reauthenticate()
+	c.Assert(session.client.Credentials.UserName(), Equals, "alice")
+	next, err := transport.acquireSession("alice", "CORP.EXAMPLE.COM", "s3cr3t")
+	c.Assert(err, IsNil)
+	c.Assert(next, Not(Equals), session)
+
+	transport.releaseSession(session)
+	c.Assert(session.client.Credentials.UserName(), Equals, "")
+	transport.releaseSession(next)
+	c.Assert(next.client.Credentials.UserName(), Equals, "alice")
+}
+
+func (s *WinRMSuite) TestKerberosCredentialProvider(c *C) {
+	settings := &Settings{
+		WinRMUsername: "alice",
+		WinRMPassword: "old",
+		KrbRealm:      "CORP.EXAMPLE.COM",
+		KrbKDC:        []string{"127.0.0.1:1"},
+	}
+	provider := CredentialProviderFunc(func(ctx context.Context) (string, string, error) {
+		return "alice", "rotated", nil
+	})
+	params := NewParametersBuilder().CredentialProvider(provider).Build()
+	client, err := NewKerberosClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), settings, params)
+	c.Assert(err, IsNil)
+
+	// no KDC answers, but the session was set up with the provider credentials
+	_, err = client.CreateShell()
+	c.Assert(err, NotNil)
+	transport := client.http.(*ClientKerberos)
+	c.Assert(transport.session, NotNil)
+	c.Assert(transport.session.key, Equals, "alice@CORP.EXAMPLE.COM\x00rotated")
+}
+
+func (s *WinRMSuite) TestKerberosChannelBindingToken(c *C) {
+	kerberosClient := client.NewWithPassword("alice", "CORP.EXAMPLE.COM", "s3cr3t", config.New())
+	ticket := messages.Ticket{
+		TktVNO:  5,
+		Realm:   "CORP.EXAMPLE.COM",
+		SName:   types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/srv-win"),
+		EncPart: types.EncryptedData{EType: etypeID.AES256_CTS_HMAC_SHA1_96, Cipher: []byte{1}},
+	}
+	sessionKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	cert := &x509.Certificate{Raw: []byte("certificate"), SignatureAlgorithm: x509.SHA384WithRSA}
+	bindings := channelBindingsHash(cert)
+
+	token, _, err := apReqSPNEGOToken(kerberosClient, ticket, sessionKey, gssapi.ContextFlagInteg|gssapi.ContextFlagConf, nil, bindings)
+	c.Assert(err, IsNil)
+	var spnegoToken spnego.SPNEGOToken
+	c.Assert(spnegoToken.Unmarshal(token), IsNil)
+	var krb5Token spnego.KRB5Token
+	c.Assert(krb5Token.Unmarshal(spnegoToken.NegTokenInit.MechTokenBytes), IsNil)
+	plain, err := crypto.DecryptEncPart(krb5Token.APReq.EncryptedAuthenticator, sessionKey, keyusage.AP_REQ_AUTHENTICATOR)
+	c.Assert(err, IsNil)
+	var authenticator types.Authenticator
+	c.Assert(authenticator.Unmarshal(plain), IsNil)
+
+	// the checksum holds the MD5 of the gss_channel_bindings_struct
+	// with the tls-server-end-point application data
+	certHash := sha512.Sum384(cert.Raw)
+	applicationData := append([]byte("tls-server-end-point:"), certHash[:]...)
+	structure := make([]byte, 20, 20+len(applicationData))
+	binary.LittleEndian.PutUint32(structure[16:], uint32(len(applicationData)))
+	expected := md5.Sum(append(structure, applicationData...))
+	checksum := authenticator.Cksum.Checksum
+	c.Assert(authenticator.Cksum.CksumType, Equals, int32(chksumtype.GSSAPI))
+	c.Assert(binary.LittleEndian.Uint32(checksum), Equals, uint32(16))
+	c.Assert(checksum[4:20], DeepEquals, expected[:])
+	c.Assert(binary.LittleEndian.Uint32(checksum[20:]), Equals, uint32(gssapi.ContextFlagInteg|gssapi.ContextFlagConf))
+}
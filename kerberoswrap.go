@@ -0,0 +1,197 @@
+package winrm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/crypto/etype"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// The flags of the RFC 4121 wrap tokens
+const (
+	wrapSentByAcceptor = 0x01
+	wrapSealed         = 0x02
+	wrapAcceptorSubkey = 0x04
+)
+
+// kerberosContext is the Kerberos security context of a connection authenticated with mutual
+// authentication, sealing the messages with the RFC 4121 wrap tokens of the Windows SSPI
+type kerberosContext struct {
+	// key seals the messages: the subkey of the server, or the session key of the ticket
+	key            types.EncryptionKey
+	acceptorSubkey bool
+
+	mutex   sync.Mutex
+	sendSeq uint64
+}
+
+// newKerberosContext returns the SPNEGO token requesting the mutual authentication and the
+// confidentiality of a security context for spn, which accept completes with the server answer
+func newKerberosContext(kerberosClient *client.Client, spn string) ([]byte, *kerberosContext, error) {
+	ticket, sessionKey, err := kerberosClient.GetServiceTicket(spn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get the service ticket of %s: %w", spn, err)
+	}
+	contextFlags := gssapi.ContextFlagMutual | gssapi.ContextFlagReplay | gssapi.ContextFlagSequence |
+		gssapi.ContextFlagConf | gssapi.ContextFlagInteg
+	token, authenticator, err := apReqSPNEGOToken(kerberosClient, ticket, sessionKey, contextFlags,
+		[]int{flags.APOptionMutualRequired}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, &kerberosContext{key: sessionKey, sendSeq: uint64(authenticator.SeqNumber)}, nil
+}
+
+// accept completes the security context with the AP-REP of the Negotiate WWW-Authenticate
+// header of resp, taking the subkey of the server when it has one
+func (k *kerberosContext) accept(resp *http.Response) error {
+	var token []byte
+	for _, value := range resp.Header.Values("WWW-Authenticate") {
+		if encoded, ok := strings.CutPrefix(value, "Negotiate "); ok {
+			var err error
+			if token, err = base64.StdEncoding.DecodeString(strings.TrimSpace(encoded)); err != nil {
+				return fmt.Errorf("decoding the mutual authentication token: %w", err)
+			}
+		}
+	}
+	if token == nil {
+		return errors.New("the server didn't answer the mutual authentication")
+	}
+
+	var spnegoToken spnego.SPNEGOToken
+	if err := spnegoToken.Unmarshal(token); err == nil && spnegoToken.Resp {
+		token = spnegoToken.NegTokenResp.ResponseToken
+	}
+	var krb5Token spnego.KRB5Token
+	if err := krb5Token.Unmarshal(token); err != nil {
+		return err
+	}
+	if !krb5Token.IsAPRep() {
+		return errors.New("the mutual authentication token isn't an AP-REP")
+	}
+	plain, err := crypto.DecryptEncPart(krb5Token.APRep.EncPart, k.key, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return fmt.Errorf("decrypting the AP-REP: %w", err)
+	}
+	var encPart messages.EncAPRepPart
+	if err := encPart.Unmarshal(plain); err != nil {
+		return err
+	}
+	if len(encPart.Subkey.KeyValue) > 0 {
+		k.key, k.acceptorSubkey = encPart.Subkey, true
+	}
+	return nil
+}
+
+// wrap seals message, returning the token header with the part of the encrypted data Windows
+// expects in it as signature, and the rest as sealed message
+func (k *kerberosContext) wrap(message []byte) ([]byte, []byte, error) {
+	k.mutex.Lock()
+	seq := k.sendSeq
+	k.sendSeq++
+	k.mutex.Unlock()
+
+	tokenFlags := byte(wrapSealed)
+	if k.acceptorSubkey {
+		tokenFlags |= wrapAcceptorSubkey
+	}
+	return wrapToken(k.key, tokenFlags, seq, message, keyusage.GSSAPI_INITIATOR_SEAL)
+}
+
+// unwrap returns the message sealed by the server
+func (k *kerberosContext) unwrap(signature, sealed []byte) ([]byte, error) {
+	if len(signature) > 2 && signature[2]&wrapSentByAcceptor == 0 {
+		return nil, errors.New("the wrap token wasn't sent by the server")
+	}
+	return unwrapToken(k.key, signature, sealed, keyusage.GSSAPI_ACCEPTOR_SEAL)
+}
+
+// sealingEtype returns the encryption type of key, which must be an AES one:
+// the RC4 and DES wrap tokens (RFC 4757, RFC 1964) aren't implemented
+func sealingEtype(key types.EncryptionKey) (etype.EType, error) {
+	switch key.KeyType {
+	case etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96,
+		etypeID.AES128_CTS_HMAC_SHA256_128, etypeID.AES256_CTS_HMAC_SHA384_192:
+		return crypto.GetEtype(key.KeyType)
+	}
+	return nil, fmt.Errorf("%w: kerberos encryption type %d", ErrUnsupportedEncryption, key.KeyType)
+}
+
+// wrapToken seals message in an RFC 4121 wrap token. The encrypted data, confounder, message
+// and token header copy followed by the checksum, is rotated so that the header copy and the
+// checksum come first (RRC): with the confounder, they are sent with the token header.
+func wrapToken(key types.EncryptionKey, tokenFlags byte, seq uint64, message []byte, usage uint32) ([]byte, []byte, error) {
+	et, err := sealingEtype(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the encrypted copy of the header has its EC and RRC zero, no filler being needed by AES
+	header := []byte{0x05, 0x04, tokenFlags, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(header[8:], seq)
+	plain := append(append(make([]byte, 0, len(message)+len(header)), message...), header...)
+	_, encrypted, err := et.EncryptMessage(key.KeyValue, plain, usage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rrc := len(encrypted) - et.GetConfounderByteSize() - len(message)
+	binary.BigEndian.PutUint16(header[6:], uint16(rrc))
+	rotated := append(append(make([]byte, 0, len(encrypted)), encrypted[len(encrypted)-rrc:]...), encrypted[:len(encrypted)-rrc]...)
+	split := len(rotated) - len(message)
+	return append(header, rotated[:split]...), rotated[split:], nil
+}
+
+// unwrapToken returns the message sealed in the RFC 4121 wrap token made of signature,
+// the token header and the start of the encrypted data, and of sealed, the rest of it
+func unwrapToken(key types.EncryptionKey, signature, sealed []byte, usage uint32) ([]byte, error) {
+	if len(signature) < 16 || signature[0] != 0x05 || signature[1] != 0x04 || signature[3] != 0xff {
+		return nil, errors.New("invalid kerberos wrap token")
+	}
+	if signature[2]&wrapSealed == 0 {
+		return nil, errors.New("the kerberos wrap token isn't sealed")
+	}
+	et, err := sealingEtype(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ec := int(binary.BigEndian.Uint16(signature[4:]))
+	rrc := int(binary.BigEndian.Uint16(signature[6:]))
+	encrypted := append(append(make([]byte, 0, len(signature)-16+len(sealed)), signature[16:]...), sealed...)
+	if len(encrypted) == 0 {
+		return nil, errors.New("empty kerberos wrap token")
+	}
+	// Windows rotates the encrypted data by RRC + EC
+	shift := (rrc + ec) % len(encrypted)
+	encrypted = append(append(make([]byte, 0, len(encrypted)), encrypted[shift:]...), encrypted[:shift]...)
+
+	plain, err := et.DecryptMessage(key.KeyValue, encrypted, usage)
+	if err != nil {
+		return nil, fmt.Errorf("unsealing the kerberos wrap token: %w", err)
+	}
+	if len(plain) < ec+16 {
+		return nil, errors.New("kerberos wrap token too short")
+	}
+	// the header copy is the one of the token with a zero RRC
+	headerCopy := plain[len(plain)-16:]
+	if !bytes.Equal(headerCopy[:6], signature[:6]) || !bytes.Equal(headerCopy[8:], signature[8:16]) {
+		return nil, errors.New("the kerberos wrap token header was tampered with")
+	}
+	return plain[:len(plain)-16-ec], nil
+}
@@ -0,0 +1,114 @@
+package winrm
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	. "gopkg.in/check.v1"
+)
+
+func aes256Key(c *C) types.EncryptionKey {
+	key := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	_, err := rand.Read(key.KeyValue)
+	c.Assert(err, IsNil)
+	return key
+}
+
+func (s *WinRMSuite) TestKerberosWrapToken(c *C) {
+	key := aes256Key(c)
+	security := &kerberosContext{key: key, acceptorSubkey: true, sendSeq: 42}
+	message := []byte("<s:Envelope>the SOAP request</s:Envelope>")
+
+	// the signature holds the token header, the rotated header copy and checksum, and the confounder
+	signature, sealed, err := security.wrap(message)
+	c.Assert(err, IsNil)
+	c.Assert(signature, HasLen, 16+28+16)
+	c.Assert(sealed, HasLen, len(message))
+	c.Assert(signature[2], Equals, byte(wrapSealed|wrapAcceptorSubkey))
+	c.Assert(binary.BigEndian.Uint16(signature[6:]), Equals, uint16(28))
+	c.Assert(binary.BigEndian.Uint64(signature[8:]), Equals, uint64(42))
+	unsealed, err := unwrapToken(key, signature, sealed, keyusage.GSSAPI_INITIATOR_SEAL)
+	c.Assert(err, IsNil)
+	c.Assert(string(unsealed), Equals, string(message))
+
+	// the next message gets the next sequence number
+	signature, _, err = security.wrap(message)
+	c.Assert(err, IsNil)
+	c.Assert(binary.BigEndian.Uint64(signature[8:]), Equals, uint64(43))
+
+	reply := []byte("<s:Envelope>the SOAP response</s:Envelope>")
+	signature, sealed, err = wrapToken(key, wrapSentByAcceptor|wrapSealed|wrapAcceptorSubkey, 7, reply, keyusage.GSSAPI_ACCEPTOR_SEAL)
+	c.Assert(err, IsNil)
+	unsealed, err = security.unwrap(signature, sealed)
+	c.Assert(err, IsNil)
+	c.Assert(string(unsealed), Equals, string(reply))
+
+	sealed[0] ^= 1
+	_, err = security.unwrap(signature, sealed)
+	c.Assert(err, NotNil)
+
+	// a token of the client isn't accepted as an answer
+	signature, sealed, err = security.wrap(message)
+	c.Assert(err, IsNil)
+	_, err = security.unwrap(signature, sealed)
+	c.Assert(err, NotNil)
+
+	_, _, err = wrapToken(types.EncryptionKey{KeyType: etypeID.RC4_HMAC, KeyValue: make([]byte, 16)}, wrapSealed, 0, message, keyusage.GSSAPI_INITIATOR_SEAL)
+	c.Assert(err, ErrorMatches, ".*not supported.*")
+}
+
+func (s *WinRMSuite) TestKerberosMutualAuthentication(c *C) {
+	sessionKey, subkey := aes256Key(c), aes256Key(c)
+
+	// the AP-REP of the server, in a SPNEGO NegTokenResp
+	encPart, err := asn1.Marshal(messages.EncAPRepPart{CTime: time.Now().UTC().Truncate(time.Second), Subkey: subkey, SequenceNumber: 7})
+	c.Assert(err, IsNil)
+	encrypted, err := crypto.GetEncryptedData(asn1tools.AddASNAppTag(encPart, asnAppTag.EncAPRepPart), sessionKey, keyusage.AP_REP_ENCPART, 0)
+	c.Assert(err, IsNil)
+	apRep, err := asn1.Marshal(messages.APRep{PVNO: 5, MsgType: msgtype.KRB_AP_REP, EncPart: encrypted})
+	c.Assert(err, IsNil)
+	oid, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	c.Assert(err, IsNil)
+	krb5Token := append(append(oid, 0x02, 0x00), asn1tools.AddASNAppTag(apRep, asnAppTag.APREP)...)
+	negTokenResp := spnego.NegTokenResp{SupportedMech: gssapi.OIDKRB5.OID(), ResponseToken: asn1tools.AddASNAppTag(krb5Token, 0)}
+	token, err := negTokenResp.Marshal()
+	c.Assert(err, IsNil)
+
+	security := &kerberosContext{key: sessionKey}
+	resp := &http.Response{Header: http.Header{}}
+	c.Assert(security.accept(resp), ErrorMatches, ".*didn't answer the mutual authentication.*")
+	resp.Header.Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	c.Assert(security.accept(resp), IsNil)
+	c.Assert(security.key.KeyValue, DeepEquals, subkey.KeyValue)
+	c.Assert(security.acceptorSubkey, Equals, true)
+
+	// the AP-REP is encrypted with the session key of the ticket
+	security = &kerberosContext{key: aes256Key(c)}
+	c.Assert(security.accept(resp), ErrorMatches, "decrypting the AP-REP.*")
+}
+
+func (s *WinRMSuite) TestNewKerberosEncryption(c *C) {
+	encryption, err := NewEncryption("kerberos")
+	c.Assert(err, IsNil)
+	c.Assert(encryption.kerberos, NotNil)
+	c.Assert(encryption.kerberos.KrbConf, Equals, "/etc/krb5.conf")
+	c.Assert(string(encryption.protocolString), Equals, "application/HTTP-SPNEGO-session-encrypted")
+
+	encryption = NewKerberosEncryption(&Settings{KrbRealm: "CORP.EXAMPLE.COM", KrbKDC: []string{"dc1.corp.example.com"}})
+	c.Assert(encryption.Transport(NewEndpoint("srv-win", 5985, false, false, nil, nil, nil, 0)), IsNil)
+	c.Assert(encryption.kerberos.Realm, Equals, "CORP.EXAMPLE.COM")
+}
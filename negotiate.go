@@ -0,0 +1,194 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/satendraraj/winrm/soap"
+)
+
+// NegotiateTransport selects the strongest authentication scheme offered by the server
+// in the WWW-Authenticate headers of its 401 answer: Kerberos when configured,
+// then NTLM, then Basic. The scheme is chosen on the first request and kept afterwards,
+// ErrUnsupportedAuth being returned when the server offers none of them.
+type NegotiateTransport struct {
+	// Kerberos is used when the server offers Negotiate or Kerberos, it is optional
+	Kerberos *ClientKerberos
+
+	ntlm  *ClientNTLM
+	basic *clientRequest
+
+	mutex    sync.Mutex
+	scheme   string
+	selected Transporter
+}
+
+// NewNegotiateTransport returns a NegotiateTransport, kerberos being nil when it isn't configured
+func NewNegotiateTransport(kerberos *ClientKerberos) *NegotiateTransport {
+	return &NegotiateTransport{Kerberos: kerberos}
+}
+
+// Transport prepares the transport of every candidate scheme
+func (t *NegotiateTransport) Transport(endpoint *Endpoint) error {
+	t.ntlm = &ClientNTLM{}
+	t.basic = &clientRequest{}
+	if err := t.ntlm.Transport(endpoint); err != nil {
+		return err
+	}
+	if err := t.basic.Transport(endpoint); err != nil {
+		return err
+	}
+	if t.Kerberos != nil {
+		return t.Kerberos.Transport(endpoint)
+	}
+	return nil
+}
+
+// Scheme returns the selected authentication scheme, empty until the first request
+func (t *NegotiateTransport) Scheme() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.scheme
+}
+
+// Reauthenticate forgets the selected scheme, which is chosen again on the next request
+func (t *NegotiateTransport) Reauthenticate() {
+	t.mutex.Lock()
+	selected := t.selected
+	t.scheme, t.selected = "", nil
+	t.mutex.Unlock()
+
+	if reauthenticator, ok := selected.(Reauthenticator); ok {
+		reauthenticator.Reauthenticate()
+	}
+}
+
+// CloseIdleConnections closes the connections of every candidate scheme kept open for the next requests
+func (t *NegotiateTransport) CloseIdleConnections() {
+	if t.ntlm != nil {
+		t.ntlm.CloseIdleConnections()
+		t.basic.CloseIdleConnections()
+	}
+	if t.Kerberos != nil {
+		t.Kerberos.CloseIdleConnections()
+	}
+}
+
+// Post sends request with the selected scheme, selecting it first if needed.
+// The lock isn't held during the probe: concurrent first requests each probe the server,
+// the first selection being kept.
+func (t *NegotiateTransport) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	t.mutex.Lock()
+	selected := t.selected
+	t.mutex.Unlock()
+
+	if selected == nil {
+		schemes, err := t.challenge(ctx, client)
+		if err != nil {
+			return "", err
+		}
+		scheme, chosen := t.choose(schemes)
+		if chosen == nil {
+			offered := strings.Join(schemes, ", ")
+			if offered == "" {
+				offered = "none"
+			}
+			return "", fmt.Errorf("%w: server offers %s", ErrUnsupportedAuth, offered)
+		}
+
+		t.mutex.Lock()
+		if t.selected == nil {
+			t.scheme, t.selected = scheme, chosen
+		}
+		selected = t.selected
+		t.mutex.Unlock()
+	}
+
+	return selected.Post(ctx, client, request)
+}
+
+// challenge sends an unauthenticated request, decorated like the others,
+// and returns the schemes of the answer, in lower case.
+// A server not requiring authentication offers none.
+func (t *NegotiateTransport) challenge(ctx context.Context, client *Client) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", client.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("impossible to create http request %w", err)
+	}
+	req.Header.Set("Content-Type", soapXML+";charset=UTF-8")
+	if err := client.decorateRequest(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.basic.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, nil
+	}
+	return authSchemes(resp.Header.Values("WWW-Authenticate")), nil
+}
+
+// authSchemes returns the schemes of the challenges of the WWW-Authenticate header values,
+// in lower case. A value can combine several challenges separated by commas, which also
+// separate the parameters of a challenge, like Negotiate, Basic realm="WSMAN", charset="UTF-8".
+func authSchemes(values []string) []string {
+	var schemes []string
+	for _, value := range values {
+		for _, element := range splitUnquoted(value, ',') {
+			fields := strings.Fields(element)
+			if len(fields) == 0 || strings.Contains(fields[0], "=") || (len(fields) > 1 && strings.HasPrefix(fields[1], "=")) {
+				// empty, or a parameter of the previous challenge
+				continue
+			}
+			schemes = append(schemes, strings.ToLower(fields[0]))
+		}
+	}
+	return schemes
+}
+
+// splitUnquoted splits s around the separators that aren't in a quoted string
+func splitUnquoted(s string, separator byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == separator:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// choose returns the strongest of schemes the transport supports
+func (t *NegotiateTransport) choose(schemes []string) (string, Transporter) {
+	offered := make(map[string]bool, len(schemes))
+	for _, scheme := range schemes {
+		offered[scheme] = true
+	}
+
+	switch {
+	case t.Kerberos != nil && (offered["negotiate"] || offered["kerberos"]):
+		return "kerberos", t.Kerberos
+	case offered["negotiate"] || offered["ntlm"]:
+		return "ntlm", t.ntlm
+	case offered["basic"]:
+		return "basic", t.basic
+	}
+	return "", nil
+}
@@ -0,0 +1,83 @@
+package winrm
+
+import (
+	"errors"
+	"net/http"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestNegotiateTransport(c *C) {
+	schemes := []string{"Basic realm=\"WSMAN\""}
+	var probes []string
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok {
+			probes = append(probes, r.Header.Get("X-Tenant"))
+			for _, scheme := range schemes {
+				w.Header().Add("WWW-Authenticate", scheme)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	transport := NewNegotiateTransport(nil)
+	params := NewParametersBuilder().
+		TransportDecorator(func() Transporter { return transport }).
+		RequestDecorator(func(req *http.Request) error {
+			req.Header.Set("X-Tenant", "acme")
+			return nil
+		}).
+		Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(transport.Scheme(), Equals, "basic")
+	// the probe is decorated like the requests
+	c.Assert(probes, DeepEquals, []string{"acme"})
+
+	schemes = []string{"Digest realm=\"WSMAN\""}
+	transport = NewNegotiateTransport(nil)
+	client, err = NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrUnsupportedAuth), Equals, true)
+	c.Assert(err, ErrorMatches, ".*server offers digest")
+
+	// Basic isn't assumed when the server offers nothing
+	schemes = nil
+	transport = NewNegotiateTransport(nil)
+	client, err = NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrUnsupportedAuth), Equals, true)
+	c.Assert(err, ErrorMatches, ".*server offers none")
+}
+
+func (s *WinRMSuite) TestNegotiateTransportChoose(c *C) {
+	transport := NewNegotiateTransport(nil)
+	c.Assert(transport.Transport(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)), IsNil)
+
+	scheme, _ := transport.choose([]string{"negotiate", "basic"})
+	c.Assert(scheme, Equals, "ntlm")
+
+	transport.Kerberos = &ClientKerberos{}
+	scheme, selected := transport.choose([]string{"basic", "negotiate"})
+	c.Assert(scheme, Equals, "kerberos")
+	c.Assert(selected, Equals, Transporter(transport.Kerberos))
+	scheme, _ = transport.choose([]string{"ntlm", "basic"})
+	c.Assert(scheme, Equals, "ntlm")
+}
+
+func (s *WinRMSuite) TestAuthSchemes(c *C) {
+	c.Assert(authSchemes(nil), HasLen, 0)
+	c.Assert(authSchemes([]string{"Negotiate", "Basic realm=\"WSMAN\""}), DeepEquals, []string{"negotiate", "basic"})
+	c.Assert(authSchemes([]string{"Negotiate, NTLM"}), DeepEquals, []string{"negotiate", "ntlm"})
+	c.Assert(authSchemes([]string{`Basic realm="a, b", charset="UTF-8", Negotiate dG9rZW4=`}), DeepEquals, []string{"basic", "negotiate"})
+	c.Assert(authSchemes([]string{`Digest realm="x\", y", qop = "auth", Kerberos`}), DeepEquals, []string{"digest", "kerberos"})
+}
@@ -0,0 +1,125 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HopMethod selects how a jump host runs a command on the final target
+type HopMethod int
+
+const (
+	// HopWinRS runs the command with the winrs.exe client of the jump host,
+	// which forwards the output streams and the exit code
+	HopWinRS HopMethod = iota
+	// HopInvokeCommand runs the command with PowerShell's Invoke-Command,
+	// which also works when credentials contain characters cmd.exe can't quote
+	HopInvokeCommand
+)
+
+// Hop describes the second host reached from the jump host the client is connected to.
+// Without Username the jump host connects with its own identity, which needs
+// Kerberos delegation or CredSSP to be configured for the target.
+// The credentials are part of the command line run on the jump host, where
+// they are visible to process auditing.
+type Hop struct {
+	Host     string
+	Port     int
+	HTTPS    bool
+	Username string
+	Password string
+	Method   HopMethod
+}
+
+// NestedCommand returns the command line to run on the jump host
+// to execute command on hop.Host
+func NestedCommand(hop *Hop, command string) (string, error) {
+	if hop.Host == "" {
+		return "", errors.New("nested execution needs a target host")
+	}
+
+	switch hop.Method {
+	case HopWinRS:
+		return winrsCommand(hop, command)
+	case HopInvokeCommand:
+		encoded := Powershell(invokeCommandScript(hop, command))
+		if encoded == "" {
+			return "", ErrCommandEncoding
+		}
+		return encoded, nil
+	}
+
+	return "", fmt.Errorf("unknown hop method %d", hop.Method)
+}
+
+// winrsCommand builds a winrs.exe invocation, whose quoted arguments
+// can't hold double quotes nor percent signs which cmd.exe would expand.
+// The command is escaped so that the cmd.exe of the jump host passes it as is
+// to winrs, its &, |, redirections and %VAR% being interpreted on the target.
+func winrsCommand(hop *Hop, command string) (string, error) {
+	target := hop.Host
+	if strings.Contains(target, ":") && !strings.HasPrefix(target, "[") {
+		target = "[" + target + "]"
+	}
+	if hop.Port != 0 {
+		target += ":" + strconv.Itoa(hop.Port)
+	}
+	if hop.HTTPS {
+		target = "https://" + target
+	}
+
+	args := []string{"-r:" + target}
+	if hop.Username != "" {
+		args = append(args, "-u:"+hop.Username, "-p:"+hop.Password)
+	}
+
+	var line strings.Builder
+	line.WriteString("winrs")
+	for _, arg := range args {
+		if strings.ContainsAny(arg, `"%`) {
+			return "", errors.New(`winrs arguments can't contain '"' or '%', use HopInvokeCommand`)
+		}
+		fmt.Fprintf(&line, ` "%s"`, arg)
+	}
+	line.WriteString(" " + cmdEscape(command))
+
+	return line.String(), nil
+}
+
+// invokeCommandScript builds the PowerShell script running command on hop.Host
+// through Invoke-Command, forwarding its output and exit code
+func invokeCommandScript(hop *Hop, command string) string {
+	var script strings.Builder
+	script.WriteString("$ErrorActionPreference = 'Stop';")
+	fmt.Fprintf(&script, "$options = @{ ComputerName = %s; ArgumentList = %s };",
+		psQuote(hop.Host), psQuote(command))
+	if hop.Port != 0 {
+		fmt.Fprintf(&script, "$options.Port = %d;", hop.Port)
+	}
+	if hop.HTTPS {
+		script.WriteString("$options.UseSSL = $true;")
+	}
+	if hop.Username != "" {
+		fmt.Fprintf(&script, "$options.Credential = New-Object System.Management.Automation.PSCredential(%s, (ConvertTo-SecureString %s -AsPlainText -Force));",
+			psQuote(hop.Username), psQuote(hop.Password))
+	}
+	script.WriteString("$code = Invoke-Command @options -ScriptBlock { param($command) cmd.exe /c $command | Out-Host; $LASTEXITCODE };")
+	script.WriteString("exit $code")
+
+	return script.String()
+}
+
+// RunNestedWithContext runs command on hop.Host through the host the client is
+// connected to, for targets only reachable from that jump host.
+// If the context is canceled, the command on the jump host is canceled.
+func (c *Client) RunNestedWithContext(ctx context.Context, hop *Hop, command string) (string, string, int, error) {
+	nested, err := NestedCommand(hop, command)
+	if err != nil {
+		return "", "", 1, err
+	}
+
+	return c.RunCmdWithContext(ctx, nested)
+}
@@ -0,0 +1,78 @@
+package winrm
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/satendraraj/winrm/soap"
+	"golang.org/x/text/encoding/unicode"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestNestedCommandWinRS(c *C) {
+	command, err := NestedCommand(&Hop{Host: "db01", Username: `CORP\svc`, Password: "p&ss w0rd"}, "ipconfig /all")
+	c.Assert(err, IsNil)
+	c.Assert(command, Equals, `winrs "-r:db01" "-u:CORP\svc" "-p:p&ss w0rd" ipconfig /all`)
+
+	command, err = NestedCommand(&Hop{Host: "fe80::1", Port: 5986, HTTPS: true}, "hostname")
+	c.Assert(err, IsNil)
+	c.Assert(command, Equals, `winrs "-r:https://[fe80::1]:5986" hostname`)
+
+	// the jump host passes the operators and variables on to the target
+	command, err = NestedCommand(&Hop{Host: "db01"}, `dir & type "a b.txt" | find "x" > %TEMP%\out`)
+	c.Assert(err, IsNil)
+	c.Assert(command, Equals, `winrs "-r:db01" dir ^& type ^"a b.txt^" ^| find ^"x^" ^> ^%TEMP^%\out`)
+
+	_, err = NestedCommand(&Hop{Host: "db01", Username: "svc", Password: `100%"`}, "hostname")
+	c.Assert(err, ErrorMatches, ".*use HopInvokeCommand.*")
+
+	_, err = NestedCommand(&Hop{}, "hostname")
+	c.Assert(err, NotNil)
+}
+
+func (s *WinRMSuite) TestNestedCommandInvokeCommand(c *C) {
+	hop := &Hop{Host: "db01", Username: "svc", Password: `it's 100%"`, HTTPS: true, Method: HopInvokeCommand}
+	script := invokeCommandScript(hop, `echo 'hi'`)
+	c.Assert(script, Contains, "ComputerName = 'db01'")
+	c.Assert(script, Contains, "ArgumentList = 'echo ''hi'''")
+	c.Assert(script, Contains, "ConvertTo-SecureString 'it''s 100%\"' -AsPlainText")
+	c.Assert(script, Contains, "$options.UseSSL = $true;")
+	c.Assert(strings.HasSuffix(script, "exit $code"), Equals, true)
+
+	c.Assert(psQuote("it’s"), Equals, "'it’’s'")
+
+	command, err := NestedCommand(hop, `echo 'hi'`)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(command, "powershell.exe -EncodedCommand "), Equals, true)
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(command, "powershell.exe -EncodedCommand "))
+	c.Assert(err, IsNil)
+	utf8, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().Bytes(decoded)
+	c.Assert(err, IsNil)
+	c.Assert(string(utf8), Contains, script)
+}
+
+func (s *WinRMSuite) TestRunNestedWithContext(c *C) {
+	client, err := NewClient(NewEndpoint("jump", 5985, false, false, nil, nil, nil, 0), "a", "b")
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch {
+		case strings.Contains(message.String(), ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(message.String(), ActionCommand):
+			c.Assert(message.String(), Contains, `<![CDATA[winrs "-r:db01" hostname]]>`)
+			return executeCommandResponse, nil
+		case strings.Contains(message.String(), ActionReceive):
+			return doneOutputResponse("db01", 0), nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	stdout, _, code, err := client.RunNestedWithContext(context.Background(), &Hop{Host: "db01"}, "hostname")
+	c.Assert(err, IsNil)
+	c.Assert(code, Equals, 0)
+	c.Assert(stdout, Equals, "db01")
+}
@@ -0,0 +1,36 @@
+package winrm
+
+// newlineNormalizer replaces the CRLF line endings of a byte stream with LF, keeping
+// the lone CR of progress indicators. It keeps its state between calls so a CRLF
+// split across several Receive responses is still replaced.
+type newlineNormalizer struct {
+	pendingCR bool
+}
+
+// normalize returns data with its CRLF replaced with LF
+func (n *newlineNormalizer) normalize(data []byte) []byte {
+	out := make([]byte, 0, len(data)+1)
+	for _, b := range data {
+		if n.pendingCR {
+			n.pendingCR = false
+			if b != '\n' {
+				out = append(out, '\r')
+			}
+		}
+		if b == '\r' {
+			n.pendingCR = true
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// flush returns the CR held at the end of the stream
+func (n *newlineNormalizer) flush() []byte {
+	if !n.pendingCR {
+		return nil
+	}
+	n.pendingCR = false
+	return []byte{'\r'}
+}
@@ -0,0 +1,49 @@
+package winrm
+
+import (
+	"context"
+	"strings"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestNewlineNormalizer(c *C) {
+	n := &newlineNormalizer{}
+	c.Assert(string(n.normalize([]byte("line 1\r\nline 2\r\n"))), Equals, "line 1\nline 2\n")
+	c.Assert(string(n.normalize([]byte("10%\r20%\r\n"))), Equals, "10%\r20%\n")
+	c.Assert(n.flush(), IsNil)
+}
+
+func (s *WinRMSuite) TestNewlineNormalizerSplitCRLF(c *C) {
+	n := &newlineNormalizer{}
+	c.Assert(string(n.normalize([]byte("line 1\r"))), Equals, "line 1")
+	c.Assert(string(n.normalize([]byte("\nline 2\r"))), Equals, "\nline 2")
+	c.Assert(string(n.flush()), Equals, "\r")
+}
+
+func (s *WinRMSuite) TestNewlineNormalizerFlushLimited(c *C) {
+	params := NewParametersBuilder().NormalizeNewlines(true).OutputLimit(3).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return doneOutputResponse("abc\r", 0), nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	// the CR held until the end of the output goes over the limit too
+	result, err := client.RunWithContextWithResult(context.Background(), "progress.exe")
+	c.Assert(err, IsNil)
+	c.Assert(result.Stdout, Equals, "abc\n[output truncated: 1 bytes dropped]\n")
+	c.Assert(result.StdoutDropped, Equals, int64(1))
+}
@@ -1,17 +1,90 @@
 package winrm
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 
 	"github.com/Azure/go-ntlmssp"
+	bodgitntlmssp "github.com/bodgit/ntlmssp"
+	ntlmhttp "github.com/bodgit/ntlmssp/http"
 	"github.com/satendraraj/winrm/soap"
 )
 
 // ClientNTLM provides a transport via NTLMv2
 type ClientNTLM struct {
 	clientRequest
+	// ChannelBinding sends a channel binding token derived from the server certificate
+	// (Extended Protection for Authentication), which endpoints with CbtHardeningLevel
+	// set to Strict require. It only applies to HTTPS endpoints.
+	ChannelBinding bool
+
+	// base is the transport before the NTLM negotiation is added
+	base http.RoundTripper
+	// bound keeps the clients authenticated with channel binding
+	bound *ntlmBoundClients
+}
+
+// ntlmBoundClients keeps the NTLM clients authenticated with channel binding whose connection
+// is idle, so that the next requests are sent on it without a new handshake
+type ntlmBoundClients struct {
+	mutex sync.Mutex
+	// key identifies the credentials the idle clients authenticated with
+	key  string
+	idle []*ntlmhttp.Client
+}
+
+// get takes an idle client authenticated with the credentials key, nil if there is none
+func (b *ntlmBoundClients) get(key string) *ntlmhttp.Client {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.key != key {
+		b.key, b.idle = key, nil
+	}
+	n := len(b.idle)
+	if n == 0 {
+		return nil
+	}
+	httpClient := b.idle[n-1]
+	b.idle = b.idle[:n-1]
+	return httpClient
+}
+
+// put gives back a client authenticated with the credentials key once its connection is idle
+func (b *ntlmBoundClients) put(key string, httpClient *ntlmhttp.Client) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.key == key {
+		b.idle = append(b.idle, httpClient)
+	}
+}
+
+// reset forgets the idle clients, the next requests running a new handshake
+func (b *ntlmBoundClients) reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.idle = nil
+}
+
+// releasingBody gives back the client a response was received with once its body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
 }
 
 // Transport creates the wrapped NTLM transport
@@ -19,28 +92,121 @@ func (c *ClientNTLM) Transport(endpoint *Endpoint) error {
 	if err := c.clientRequest.Transport(endpoint); err != nil {
 		return err
 	}
-	c.clientRequest.transport = &ntlmssp.Negotiator{RoundTripper: c.clientRequest.transport}
+	c.base = c.clientRequest.transport
+	c.clientRequest.setTransport(&ntlmssp.Negotiator{RoundTripper: c.base})
+	c.clientRequest.ntlm = true
+	c.bound = &ntlmBoundClients{}
 	return nil
 }
 
+// Reauthenticate closes the NTLM authenticated connections
+func (c *ClientNTLM) Reauthenticate() {
+	c.CloseIdleConnections()
+}
+
+// CloseIdleConnections closes the connections kept open for the next requests
+func (c *ClientNTLM) CloseIdleConnections() {
+	if c.bound != nil {
+		c.bound.reset()
+	}
+	closeIdleConnections(c.base)
+}
+
 // Post make post to the winrm soap service (forwarded to clientRequest implementation)
-func (c ClientNTLM) Post(client *Client, request *soap.SoapMessage) (string, error) {
-	return c.clientRequest.Post(client, request)
+func (c ClientNTLM) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	if c.ChannelBinding && strings.HasPrefix(client.url, "https:") {
+		return c.postWithChannelBinding(ctx, client, request)
+	}
+	return c.clientRequest.Post(ctx, client, request)
+}
+
+// postWithChannelBinding authenticates request with an NTLM handshake bound to the TLS channel
+// the request is sent on. The connection stays authenticated: the following requests are sent
+// on it without a new handshake, one being run again when the server closed it.
+func (c ClientNTLM) postWithChannelBinding(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	username, password, err := client.credentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting the credentials: %w", err)
+	}
+	user, domain := splitUsername(qualifiedUsername(username, client.domain))
+	bound := c.bound
+	if bound == nil {
+		bound = &ntlmBoundClients{}
+	}
+	key := user + "\x00" + domain + "\x00" + password
+
+	return post(ctx, client, request, func(req *http.Request) (*http.Response, error) {
+		var err error
+		httpClient := bound.get(key)
+		reused := httpClient != nil
+		if !reused {
+			if httpClient, err = c.boundClient(user, domain, password); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := httpClient.Do(req)
+		if err == nil && reused && resp.StatusCode == http.StatusUnauthorized && req.GetBody != nil {
+			// the authenticated connection was closed, the request is sent again with a handshake
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+			if httpClient, err = c.boundClient(user, domain, password); err != nil {
+				return nil, err
+			}
+			resp, err = httpClient.Do(req)
+		}
+		if err != nil || resp.StatusCode == http.StatusUnauthorized {
+			return resp, err
+		}
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { bound.put(key, httpClient) }}
+		return resp, nil
+	})
+}
+
+// boundClient creates an HTTP client running the NTLM handshake with channel binding
+func (c ClientNTLM) boundClient(user, domain, password string) (*ntlmhttp.Client, error) {
+	ntlmClient, err := bodgitntlmssp.NewClient(bodgitntlmssp.SetUserInfo(user, password),
+		bodgitntlmssp.SetDomain(domain), bodgitntlmssp.SetVersion(bodgitntlmssp.DefaultVersion()))
+	if err != nil {
+		return nil, err
+	}
+	return ntlmhttp.NewClient(&http.Client{Transport: c.base, CheckRedirect: noRedirect}, ntlmClient, ntlmhttp.SendCBT(true))
+}
+
+// NewClientNTLMWithDialContext creates a NTLM transport opening its connections with dialContext
+func NewClientNTLMWithDialContext(dialContext func(ctx context.Context, network, addr string) (net.Conn, error)) *ClientNTLM {
+	return &ClientNTLM{
+		clientRequest: clientRequest{
+			dialContext: dialContext,
+		},
+	}
 }
 
 // NewClientNTLMWithDial NewClientNTLMWithDial
 func NewClientNTLMWithDial(dial func(network, addr string) (net.Conn, error)) *ClientNTLM {
 	return &ClientNTLM{
-		clientRequest{
+		clientRequest: clientRequest{
 			dial: dial,
 		},
 	}
 }
 
+// NewClientNTLMWithRoundTripper creates a NTLM transport sending the requests with roundTripper,
+// whose settings replace the TLS, proxy, dial and timeout settings of the endpoint
+func NewClientNTLMWithRoundTripper(roundTripper http.RoundTripper) *ClientNTLM {
+	return &ClientNTLM{
+		clientRequest: clientRequest{
+			roundTripper: roundTripper,
+		},
+	}
+}
+
 // NewClientNTLMWithProxyFunc NewClientNTLMWithProxyFunc
 func NewClientNTLMWithProxyFunc(proxyfunc func(req *http.Request) (*url.URL, error)) *ClientNTLM {
 	return &ClientNTLM{
-		clientRequest{
+		clientRequest: clientRequest{
 			proxyfunc: proxyfunc,
 		},
 	}
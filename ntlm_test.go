@@ -1,7 +1,16 @@
 package winrm
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 
 	"net"
 	"time"
@@ -18,8 +27,7 @@ func (s *WinRMSuite) TestHttpNTLMRequest(c *C) {
 	defer ts.Close()
 	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
 
-	params := DefaultParameters
-	params.TransportDecorator = func() Transporter { return &ClientNTLM{} }
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return &ClientNTLM{} }).Build()
 	client, err := NewClientWithParameters(endpoint, "test", "test", params)
 
 	c.Assert(err, IsNil)
@@ -47,11 +55,146 @@ func (s *WinRMSuite) TestHttpNTLMViaCustomDialerRequest(c *C) {
 	defer ts.Close()
 	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
 
-	params := DefaultParameters
-	params.TransportDecorator = func() Transporter { return NewClientNTLMWithDial(dial) }
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return NewClientNTLMWithDial(dial) }).Build()
 	client, err := NewClientWithParameters(endpoint, "test", "test", params)
 	c.Assert(err, IsNil)
 	_, err = client.CreateShell()
 	c.Assert(err, IsNil)
 	c.Assert(usedCustomDialer, Equals, true)
 }
+
+// challengeMessage is the NTLM challenge of the MS-NLMP examples
+var challengeMessage = []byte{
+	0x4e, 0x54, 0x4c, 0x4d, 0x53, 0x53, 0x50, 0x00, 0x02, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x0c, 0x00,
+	0x38, 0x00, 0x00, 0x00, 0x37, 0x82, 0x8a, 0x82, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x24, 0x00, 0x24, 0x00, 0x44, 0x00, 0x00, 0x00,
+	0x06, 0x00, 0x70, 0x17, 0x00, 0x00, 0x00, 0x0f, 0x53, 0x00, 0x65, 0x00, 0x72, 0x00, 0x76, 0x00,
+	0x65, 0x00, 0x72, 0x00, 0x02, 0x00, 0x0c, 0x00, 0x44, 0x00, 0x6f, 0x00, 0x6d, 0x00, 0x61, 0x00,
+	0x69, 0x00, 0x6e, 0x00, 0x01, 0x00, 0x0c, 0x00, 0x53, 0x00, 0x65, 0x00, 0x72, 0x00, 0x76, 0x00,
+	0x65, 0x00, 0x72, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+func (s *WinRMSuite) TestClientNTLMChannelBinding(c *C) {
+	var authenticate []byte
+	// the connections authenticated by a handshake, like the ones of IIS and WinRM
+	authenticated := map[string]bool{}
+	handshakes := 0
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Negotiate "))
+		c.Assert(err, IsNil)
+		switch {
+		case authenticated[r.RemoteAddr] && len(token) == 0:
+			// the server closes the connection once its keep-alive is over
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Content-Type", "application/soap+xml")
+			_, _ = w.Write([]byte(createShellResponse))
+		case len(token) < 12:
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+		case token[8] == 1:
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(challengeMessage))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			authenticate = token
+			authenticated[r.RemoteAddr] = true
+			handshakes++
+			w.Header().Set("Content-Type", "application/soap+xml")
+			_, _ = w.Write([]byte(createShellResponse))
+		}
+	}))
+	defer ts.Close()
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return &ClientNTLM{ChannelBinding: true} }).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, true, true, nil, nil, nil, 0), `Domain\User`, "Password", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(authenticate, NotNil)
+
+	// the next requests go through the authenticated connection,
+	// a new handshake being run when it was closed
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(handshakes, Equals, 1)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(handshakes, Equals, 2)
+
+	// the NTLMv2 response holds the MD5 of the gss_channel_bindings_struct
+	// with the tls-server-end-point application data
+	certHash := sha256.Sum256(ts.Certificate().Raw)
+	applicationData := append([]byte("tls-server-end-point:"), certHash[:]...)
+	bindings := make([]byte, 20, 20+len(applicationData))
+	binary.LittleEndian.PutUint32(bindings[16:], uint32(len(applicationData)))
+	bindings = append(bindings, applicationData...)
+	expected := md5.Sum(bindings)
+	c.Assert(bytes.Contains(authenticate, expected[:]), Equals, true)
+}
+
+func (s *WinRMSuite) TestEncryptionConcurrentRequests(c *C) {
+	var mutex sync.Mutex
+	// the connections authenticated by a handshake, and the requests being served
+	authenticated := map[string]bool{}
+	serving, maxServing, sealed := 0, 0, 0
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		serving++
+		maxServing = max(maxServing, serving)
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			serving--
+			mutex.Unlock()
+		}()
+		// lets a concurrent request show up
+		time.Sleep(5 * time.Millisecond)
+
+		_, _ = io.Copy(io.Discard, r.Body)
+		token, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Negotiate "))
+		switch {
+		case strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/encrypted"):
+			mutex.Lock()
+			// the sealed request goes through the connection of its handshake
+			c.Check(authenticated[r.RemoteAddr], Equals, true)
+			sealed++
+			mutex.Unlock()
+			w.Header().Set("Content-Type", "application/soap+xml")
+			_, _ = w.Write([]byte(createShellResponse))
+		case len(token) < 12:
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+		case token[8] == 1:
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(challengeMessage))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			mutex.Lock()
+			authenticated[r.RemoteAddr] = true
+			mutex.Unlock()
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	params := NewParametersBuilder().TransportDecorator(func() Transporter {
+		encryption, _ := NewEncryption("ntlm")
+		return encryption
+	}).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), `Domain\User`, "Password", params)
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.CreateShell()
+			c.Check(err, IsNil)
+		}()
+	}
+	wg.Wait()
+	c.Assert(sealed, Equals, 4)
+	c.Assert(maxServing, Equals, 1)
+}
@@ -1,6 +1,38 @@
 package winrm
 
-import "net"
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
+	"golang.org/x/text/encoding"
+)
+
+// Compatibility selects the protocol quirks applied for a kind of WS-Management server
+type Compatibility int
+
+const (
+	// CompatibilityWindows targets the Windows WinRM service
+	CompatibilityWindows Compatibility = iota
+	// CompatibilityOMI targets OMI and other open-source WS-Management servers,
+	// relaxing response parsing and leaving out the WinRS specific options
+	CompatibilityOMI
+)
+
+// PostFunc sends a SOAP request and returns the SOAP response
+type PostFunc func(ctx context.Context, request *soap.SoapMessage) (string, error)
+
+// Middleware wraps the SOAP exchanges: it returns a PostFunc doing its work around
+// next, like logging, metrics or modifying the request, and calling next to go on
+type Middleware func(next PostFunc) PostFunc
+
+// RateLimiter throttles the requests sent to the server: Wait blocks until a request
+// is allowed, or fails when the context is done first
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
 
 // Parameters struct defines
 // metadata information and http transport config
@@ -10,6 +42,102 @@ type Parameters struct {
 	EnvelopeSize       int
 	TransportDecorator func() Transporter
 	Dial               func(network, addr string) (net.Conn, error)
+	// DialContext opens the connections of the default transport instead of Dial,
+	// the context being the one of the request the connection is opened for
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// OutputLimit caps the number of bytes kept for each of the stdout and
+	// stderr streams of a command, zero means unlimited
+	OutputLimit int
+	// StripANSI removes the ANSI/VT escape sequences (colors, cursor moves, window titles)
+	// emitted by console applications from the command output
+	StripANSI bool
+	// Compatibility adjusts requests and response parsing for non Windows servers
+	Compatibility Compatibility
+	// ResourceURI overrides the resource URI of the shells, defaults to the cmd shell
+	ResourceURI string
+	// ProtocolVersion, when set, is sent as the protocolversion option of the
+	// shells created, the server refusing them if it can't comply
+	ProtocolVersion string
+	// ShellPoolSize, when set, makes the Run helpers keep up to this number of
+	// idle shells to reuse them instead of creating a shell for each command
+	ShellPoolSize int
+	// ShellPoolIdleTimeout is how long a pooled shell is kept unused, one minute by default
+	ShellPoolIdleTimeout time.Duration
+	// QuotaQueue, when set, makes operations rejected because of the server quotas
+	// wait and retry instead of failing with ErrQuotaExceeded
+	QuotaQueue *QuotaQueue
+	// RetryPolicy, when set, makes the requests failing with a transient error
+	// (connection reset, 503, quota fault...) be sent again after a backoff
+	RetryPolicy *RetryPolicy
+	// RateLimiter, when set, is waited for before each request sent to the server, so that
+	// tools fanning out operations stay below the MaxConcurrentOperationsPerUser quota.
+	// A *rate.Limiter of golang.org/x/time/rate can be used.
+	RateLimiter RateLimiter
+	// Middlewares wrap every SOAP exchange with the transport, the first one being the
+	// outermost. The retries and quota waits happen around them, each attempt going through them.
+	Middlewares []Middleware
+	// RequestTimeout, when set, bounds each request, from its sending to the end of its
+	// response. It must exceed the Timeout operation timeout, the server holding the
+	// output requests of a silent command up to it.
+	RequestTimeout time.Duration
+	// CommandTimeout, when set, is the deadline of the commands run by the Run helpers,
+	// Exec and Session when their context has none
+	CommandTimeout time.Duration
+	// Compression accepts gzip or deflate compressed responses, and gzips the requests once the
+	// server advertised it accepts them (Accept-Encoding response header), reducing the bandwidth
+	// of large inputs and outputs. A compressed request failing with a 400, 415 or 500 status is
+	// sent again in clear, and so are the next ones when it then succeeds.
+	// The Encryption transport doesn't compress its requests.
+	Compression bool
+	// CredentialProvider, when set, supplies the username and password of each request
+	// instead of the ones given to the client constructor
+	CredentialProvider CredentialProvider
+	// RequestDecorator, when set, is called with each HTTP request before it is sent,
+	// to add headers or tracing tokens; the transport authentication is added afterwards.
+	// An error aborts the request.
+	RequestDecorator func(*http.Request) error
+	// AllowInsecureBasic lets the Basic authentication send the credentials in clear over
+	// plain HTTP. Without it, such requests fail with an *InsecureBasicError: use HTTPS,
+	// or an NTLM, Kerberos or Encryption transport.
+	AllowInsecureBasic bool
+	// FollowRedirects makes the requests answered with a redirection (307 and the like, sent
+	// by some WinRM gateways) be posted again to its Location, authenticated again by the
+	// transport. Only the redirections to the same host and port, or to HTTPS on the same host,
+	// are followed so that the credentials never go to another server or in clear.
+	// Without it, a redirection fails with a *RedirectError.
+	FollowRedirects bool
+	// CircuitBreaker, when set, stops sending requests to the endpoint after consecutive
+	// failures, so that fanned out operations fail fast with ErrCircuitOpen on a dead host
+	CircuitBreaker *CircuitBreaker
+	// ExitError makes the Run helpers return an *ExitError, holding the end of the stderr
+	// output, when the command exits with a non-zero code
+	ExitError bool
+	// KeepAlive, when set, makes the shell of a running command be pinged with a lightweight
+	// Get request at this interval, so that servers don't reap it during multi-hour commands
+	// whose output polling stalls
+	KeepAlive time.Duration
+	// ReceiveInterval is the time waited between the output requests of a command, each one
+	// being held by the server until there is output or the Timeout operation timeout elapses.
+	// Zero sends them back to back.
+	ReceiveInterval time.Duration
+	// ReceiveMaxInterval, when above ReceiveInterval, makes the time waited double after each
+	// output request receiving nothing, up to ReceiveMaxInterval, and go back to ReceiveInterval
+	// on output: chatty commands are polled fast while quiet ones load the server less
+	ReceiveMaxInterval time.Duration
+	// MaxOutput, when set, caps the stdout and stderr bytes received for a command together:
+	// going over it stops receiving and terminates the command, whose error is then
+	// ErrOutputLimitExceeded. Unlike with OutputLimit, a runaway command doesn't run on.
+	MaxOutput int
+	// OutputEncoding, when set, is the encoding the stdout and stderr of the commands are
+	// decoded from to UTF-8, like the one of the host OEM codepage for localized Windows
+	// console programs: see CodepageEncoding and Client.DetectCodepage
+	OutputEncoding encoding.Encoding
+	// NormalizeNewlines replaces the CRLF line endings of the command output with LF,
+	// for parsers and diffs running on Unix
+	NormalizeNewlines bool
+	// AutoReconnect makes a Session whose shell was reaped by the server (reboot, idle timeout)
+	// since its previous command create it again and run the command in the new one
+	AutoReconnect bool
 }
 
 // DefaultParameters return constant config
@@ -25,3 +153,233 @@ func NewParameters(timeout, locale string, envelopeSize int) *Parameters {
 		EnvelopeSize: envelopeSize,
 	}
 }
+
+// clone returns a copy of p, its Middlewares slice and its QuotaQueue, RetryPolicy and
+// CircuitBreaker settings being copied too: clients keep such a copy so later changes to p
+// don't affect them. The functions and interfaces, like RateLimiter, are shared.
+func (p *Parameters) clone() *Parameters {
+	clone := *p
+	clone.Middlewares = append([]Middleware(nil), p.Middlewares...)
+	if p.QuotaQueue != nil {
+		queue := *p.QuotaQueue
+		clone.QuotaQueue = &queue
+	}
+	if p.RetryPolicy != nil {
+		policy := *p.RetryPolicy
+		clone.RetryPolicy = &policy
+	}
+	if p.CircuitBreaker != nil {
+		breaker := *p.CircuitBreaker
+		clone.CircuitBreaker = &breaker
+	}
+	return &clone
+}
+
+// Builder returns a ParametersBuilder starting from a copy of p,
+// so shared values like DefaultParameters can be derived without being modified
+func (p *Parameters) Builder() *ParametersBuilder {
+	return &ParametersBuilder{params: *p.clone()}
+}
+
+// ParametersBuilder builds Parameters, each Build returning a new copy
+type ParametersBuilder struct {
+	params Parameters
+}
+
+// NewParametersBuilder returns a ParametersBuilder starting from DefaultParameters
+func NewParametersBuilder() *ParametersBuilder {
+	return DefaultParameters.Builder()
+}
+
+// Timeout sets the WS-Management operation timeout, like "PT60S"
+func (b *ParametersBuilder) Timeout(timeout string) *ParametersBuilder {
+	b.params.Timeout = timeout
+	return b
+}
+
+// Locale sets the locale of the requests, like "en-US"
+func (b *ParametersBuilder) Locale(locale string) *ParametersBuilder {
+	b.params.Locale = locale
+	return b
+}
+
+// EnvelopeSize sets the maximum envelope size
+func (b *ParametersBuilder) EnvelopeSize(size int) *ParametersBuilder {
+	b.params.EnvelopeSize = size
+	return b
+}
+
+// TransportDecorator sets the function creating the Transporter
+func (b *ParametersBuilder) TransportDecorator(decorator func() Transporter) *ParametersBuilder {
+	b.params.TransportDecorator = decorator
+	return b
+}
+
+// Dial sets the dial function of the default transport
+func (b *ParametersBuilder) Dial(dial func(network, addr string) (net.Conn, error)) *ParametersBuilder {
+	b.params.Dial = dial
+	return b
+}
+
+// OutputLimit sets Parameters.OutputLimit
+func (b *ParametersBuilder) OutputLimit(limit int) *ParametersBuilder {
+	b.params.OutputLimit = limit
+	return b
+}
+
+// StripANSI sets Parameters.StripANSI
+func (b *ParametersBuilder) StripANSI(strip bool) *ParametersBuilder {
+	b.params.StripANSI = strip
+	return b
+}
+
+// Compatibility sets Parameters.Compatibility
+func (b *ParametersBuilder) Compatibility(compatibility Compatibility) *ParametersBuilder {
+	b.params.Compatibility = compatibility
+	return b
+}
+
+// ResourceURI sets Parameters.ResourceURI
+func (b *ParametersBuilder) ResourceURI(uri string) *ParametersBuilder {
+	b.params.ResourceURI = uri
+	return b
+}
+
+// ProtocolVersion sets Parameters.ProtocolVersion
+func (b *ParametersBuilder) ProtocolVersion(version string) *ParametersBuilder {
+	b.params.ProtocolVersion = version
+	return b
+}
+
+// ShellPool sets Parameters.ShellPoolSize and Parameters.ShellPoolIdleTimeout
+func (b *ParametersBuilder) ShellPool(size int, idleTimeout time.Duration) *ParametersBuilder {
+	b.params.ShellPoolSize = size
+	b.params.ShellPoolIdleTimeout = idleTimeout
+	return b
+}
+
+// DialContext sets Parameters.DialContext
+func (b *ParametersBuilder) DialContext(dialContext func(ctx context.Context, network, addr string) (net.Conn, error)) *ParametersBuilder {
+	b.params.DialContext = dialContext
+	return b
+}
+
+// QuotaQueue sets Parameters.QuotaQueue
+func (b *ParametersBuilder) QuotaQueue(queue *QuotaQueue) *ParametersBuilder {
+	b.params.QuotaQueue = queue
+	return b
+}
+
+// RetryPolicy sets Parameters.RetryPolicy
+func (b *ParametersBuilder) RetryPolicy(policy *RetryPolicy) *ParametersBuilder {
+	b.params.RetryPolicy = policy
+	return b
+}
+
+// RateLimiter sets Parameters.RateLimiter
+func (b *ParametersBuilder) RateLimiter(limiter RateLimiter) *ParametersBuilder {
+	b.params.RateLimiter = limiter
+	return b
+}
+
+// Middlewares appends middlewares to Parameters.Middlewares
+func (b *ParametersBuilder) Middlewares(middlewares ...Middleware) *ParametersBuilder {
+	b.params.Middlewares = append(b.params.Middlewares[:len(b.params.Middlewares):len(b.params.Middlewares)], middlewares...)
+	return b
+}
+
+// RequestTimeout sets Parameters.RequestTimeout
+func (b *ParametersBuilder) RequestTimeout(timeout time.Duration) *ParametersBuilder {
+	b.params.RequestTimeout = timeout
+	return b
+}
+
+// CommandTimeout sets Parameters.CommandTimeout
+func (b *ParametersBuilder) CommandTimeout(timeout time.Duration) *ParametersBuilder {
+	b.params.CommandTimeout = timeout
+	return b
+}
+
+// Compression sets Parameters.Compression
+func (b *ParametersBuilder) Compression(compression bool) *ParametersBuilder {
+	b.params.Compression = compression
+	return b
+}
+
+// CredentialProvider sets Parameters.CredentialProvider
+func (b *ParametersBuilder) CredentialProvider(provider CredentialProvider) *ParametersBuilder {
+	b.params.CredentialProvider = provider
+	return b
+}
+
+// RequestDecorator sets Parameters.RequestDecorator
+func (b *ParametersBuilder) RequestDecorator(decorator func(*http.Request) error) *ParametersBuilder {
+	b.params.RequestDecorator = decorator
+	return b
+}
+
+// AllowInsecureBasic sets Parameters.AllowInsecureBasic
+func (b *ParametersBuilder) AllowInsecureBasic(allow bool) *ParametersBuilder {
+	b.params.AllowInsecureBasic = allow
+	return b
+}
+
+// FollowRedirects sets Parameters.FollowRedirects
+func (b *ParametersBuilder) FollowRedirects(follow bool) *ParametersBuilder {
+	b.params.FollowRedirects = follow
+	return b
+}
+
+// CircuitBreaker sets Parameters.CircuitBreaker
+func (b *ParametersBuilder) CircuitBreaker(breaker *CircuitBreaker) *ParametersBuilder {
+	b.params.CircuitBreaker = breaker
+	return b
+}
+
+// ExitError sets Parameters.ExitError
+func (b *ParametersBuilder) ExitError(exitError bool) *ParametersBuilder {
+	b.params.ExitError = exitError
+	return b
+}
+
+// KeepAlive sets Parameters.KeepAlive
+func (b *ParametersBuilder) KeepAlive(interval time.Duration) *ParametersBuilder {
+	b.params.KeepAlive = interval
+	return b
+}
+
+// ReceiveInterval sets Parameters.ReceiveInterval and Parameters.ReceiveMaxInterval
+func (b *ParametersBuilder) ReceiveInterval(interval, maxInterval time.Duration) *ParametersBuilder {
+	b.params.ReceiveInterval = interval
+	b.params.ReceiveMaxInterval = maxInterval
+	return b
+}
+
+// MaxOutput sets Parameters.MaxOutput
+func (b *ParametersBuilder) MaxOutput(limit int) *ParametersBuilder {
+	b.params.MaxOutput = limit
+	return b
+}
+
+// OutputEncoding sets Parameters.OutputEncoding
+func (b *ParametersBuilder) OutputEncoding(enc encoding.Encoding) *ParametersBuilder {
+	b.params.OutputEncoding = enc
+	return b
+}
+
+// NormalizeNewlines sets Parameters.NormalizeNewlines
+func (b *ParametersBuilder) NormalizeNewlines(normalize bool) *ParametersBuilder {
+	b.params.NormalizeNewlines = normalize
+	return b
+}
+
+// AutoReconnect sets Parameters.AutoReconnect
+func (b *ParametersBuilder) AutoReconnect(reconnect bool) *ParametersBuilder {
+	b.params.AutoReconnect = reconnect
+	return b
+}
+
+// Build returns new Parameters, later calls to the builder don't affect them
+func (b *ParametersBuilder) Build() *Parameters {
+	return b.params.clone()
+}
@@ -17,3 +17,32 @@ func (s *WinRMSuite) TestParameters(c *C) {
 	c.Assert(params.Timeout, Equals, "PT120S")
 	c.Assert(params.EnvelopeSize, Equals, 128)
 }
+
+func (s *WinRMSuite) TestParametersBuilder(c *C) {
+	params := NewParametersBuilder().Timeout("PT10S").OutputLimit(42).StripANSI(true).Build()
+	c.Assert(params.Timeout, Equals, "PT10S")
+	c.Assert(params.Locale, Equals, "en-US")
+	c.Assert(params.OutputLimit, Equals, 42)
+	c.Assert(params.StripANSI, Equals, true)
+	c.Assert(DefaultParameters.Timeout, Equals, "PT60S")
+	c.Assert(DefaultParameters.OutputLimit, Equals, 0)
+
+	derived := params.Builder().Locale("fr-FR").Build()
+	c.Assert(derived.Locale, Equals, "fr-FR")
+	c.Assert(derived.OutputLimit, Equals, 42)
+	c.Assert(params.Locale, Equals, "en-US")
+}
+
+func (s *WinRMSuite) TestClientCopiesParameters(c *C) {
+	params := NewParametersBuilder().RetryPolicy(&RetryPolicy{MaxAttempts: 3}).Build()
+	params.Middlewares = make([]Middleware, 0, 1)
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+
+	params.Timeout = "PT1S"
+	params.RetryPolicy.MaxAttempts = 5
+	_ = append(params.Middlewares, nil)
+	c.Assert(client.Timeout, Equals, "PT60S")
+	c.Assert(client.RetryPolicy.MaxAttempts, Equals, 3)
+	c.Assert(client.Middlewares[:cap(client.Middlewares)], HasLen, 0)
+}
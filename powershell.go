@@ -2,6 +2,7 @@ package winrm
 
 import (
 	"encoding/base64"
+	"strings"
 
 	"golang.org/x/text/encoding/unicode"
 )
@@ -25,3 +26,10 @@ func Powershell(psCmd string) string {
 	// Specify powershell.exe to run encoded command
 	return "powershell.exe -EncodedCommand " + psCmd
 }
+
+// psQuote returns s as a PowerShell single-quoted string literal,
+// doubling the quotes (PowerShell also treats the typographic ones as quotes)
+func psQuote(s string) string {
+	return "'" + strings.NewReplacer("'", "''", "‘", "‘‘", "’", "’’",
+		"‚", "‚‚", "‛", "‛‛").Replace(s) + "'"
+}
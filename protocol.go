@@ -0,0 +1,70 @@
+package winrm
+
+// WS-Management actions
+const (
+	ActionCreate    = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	ActionDelete    = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	ActionGet       = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Get"
+	ActionPut       = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Put"
+	ActionEnumerate = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate"
+	ActionPull      = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull"
+	ActionRelease   = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Release"
+	ActionCommand   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	ActionReceive   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	ActionSend      = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	ActionSignal    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+	// ActionDisconnect, ActionReconnect and ActionConnect are the robust connection
+	// operations of WinRM 3.0
+	ActionDisconnect = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Disconnect"
+	ActionReconnect  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Reconnect"
+	ActionConnect    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Connect"
+
+	// ActionCommandResponse is the action of the response to an ActionCommand request
+	ActionCommandResponse = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandResponse"
+)
+
+// Resource URIs
+const (
+	ResourceURICmdShell   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+	ResourceURIPowerShell = "http://schemas.microsoft.com/powershell/Microsoft.PowerShell"
+	ResourceURIConfig     = "http://schemas.microsoft.com/wbem/wsman/1/config"
+	// ResourceURIWMICIMv2 is the prefix of the root/cimv2 WMI classes, like ResourceURIWMICIMv2 + "Win32_Service"
+	ResourceURIWMICIMv2 = "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/"
+)
+
+// Signal codes sent to running commands
+const (
+	SignalTerminate = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	SignalCtrlC     = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/ctrl_c"
+	SignalCtrlBreak = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/ctrl_break"
+)
+
+// Command states reported in Receive responses
+const (
+	CommandStateDone    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+	CommandStateRunning = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Running"
+	CommandStatePending = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Pending"
+)
+
+// Well-known WSManFault codes, as found in the Code attribute of the f:WSManFault element
+const (
+	// FaultAccessDenied is returned when the user isn't allowed to use the resource
+	FaultAccessDenied = "5"
+	// FaultOperationAborted is returned for a Receive whose shell or command has been closed
+	FaultOperationAborted = "995"
+	// FaultOperationTimeout is returned for a Receive when no output was produced in time
+	FaultOperationTimeout = "2150858793"
+	// FaultShellNotFound is returned when the ShellId selector doesn't match any shell,
+	// like after the shell expired or the service restarted
+	FaultShellNotFound = "2150858843"
+	// FaultQuotaMaxShells is returned when the user already has MaxShellsPerUser shells open
+	FaultQuotaMaxShells = "2150859173"
+	// FaultQuotaMaxOperations is returned when the user already runs MaxConcurrentOperationsPerUser operations
+	FaultQuotaMaxOperations = "2150859174"
+)
+
+// FilterDialectWQL is the dialect of the WQL enumeration filters
+const FilterDialectWQL = "http://schemas.microsoft.com/wbem/wsman/1/WQL"
+
+// anonymousAddress is the reply address of the requests
+const anonymousAddress = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
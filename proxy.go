@@ -0,0 +1,187 @@
+package winrm
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-ntlmssp"
+)
+
+// ntlmProxyDialer opens connections tunneled through an HTTP proxy
+// with CONNECT, authenticating to the proxy with NTLM
+type ntlmProxyDialer struct {
+	proxy    *url.URL
+	user     string
+	password string
+	dial     func(network, addr string) (net.Conn, error)
+}
+
+// bufferedConn is a net.Conn whose first bytes were already read in a buffer
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// NewNTLMProxyDial returns a dial function tunneling connections through the HTTP proxy
+// at proxyURL with CONNECT requests, performing the NTLM (or Negotiate/NTLM) handshake the
+// proxy asks for with the given credentials (DOMAIN\user or user@domain).
+// The connection to the proxy itself is opened with dial, or a default dialer if nil.
+func NewNTLMProxyDial(proxyURL *url.URL, user, password string, dial func(network, addr string) (net.Conn, error)) func(network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial
+	}
+	d := &ntlmProxyDialer{
+		proxy:    proxyURL,
+		user:     user,
+		password: password,
+		dial:     dial,
+	}
+	return d.Dial
+}
+
+// noProxy disables the environment proxy for transports already tunneling through one
+func noProxy(*http.Request) (*url.URL, error) {
+	return nil, nil
+}
+
+// NewClientWithNTLMProxy creates a basic auth transport reaching the WinRM server
+// through an HTTP proxy requiring NTLM authentication
+func NewClientWithNTLMProxy(proxyURL *url.URL, user, password string) *clientRequest {
+	return &clientRequest{
+		dial:      NewNTLMProxyDial(proxyURL, user, password, nil),
+		proxyfunc: noProxy,
+	}
+}
+
+// NewClientNTLMWithNTLMProxy creates a NTLM transport reaching the WinRM server
+// through an HTTP proxy requiring NTLM authentication
+func NewClientNTLMWithNTLMProxy(proxyURL *url.URL, user, password string) *ClientNTLM {
+	return &ClientNTLM{
+		clientRequest: clientRequest{
+			dial:      NewNTLMProxyDial(proxyURL, user, password, nil),
+			proxyfunc: noProxy,
+		},
+	}
+}
+
+// Dial connects to addr through the proxy
+func (d *ntlmProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.dial(network, d.proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to proxy %s: %w", d.proxy.Host, err)
+	}
+
+	tunnel, err := d.connect(conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tunnel, nil
+}
+
+// connect establishes the CONNECT tunnel on conn, authenticating if needed
+func (d *ntlmProxyDialer) connect(conn net.Conn, addr string) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	user, domain, domainNeeded := ntlmssp.GetDomain(d.user)
+
+	negotiate, err := ntlmssp.NewNegotiateMessage(domain, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.roundTrip(conn, reader, addr, "NTLM "+base64.StdEncoding.EncodeToString(negotiate))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		scheme, challenge, err := proxyChallenge(resp)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Close {
+			return nil, errors.New("proxy closed the connection during the NTLM handshake")
+		}
+
+		authenticate, err := ntlmssp.ProcessChallenge(challenge, user, d.password, domainNeeded)
+		if err != nil {
+			return nil, fmt.Errorf("processing proxy NTLM challenge: %w", err)
+		}
+
+		resp, err = d.roundTrip(conn, reader, addr, scheme+" "+base64.StdEncoding.EncodeToString(authenticate))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy CONNECT to %s failed: %s", addr, resp.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// roundTrip sends a CONNECT request with the given Proxy-Authorization
+// and reads the proxy response
+func (d *ntlmProxyDialer) roundTrip(conn net.Conn, reader *bufio.Reader, addr, authorization string) (*http.Response, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	req.Header.Set("Proxy-Authorization", authorization)
+	req.Header.Set("Proxy-Connection", "Keep-Alive")
+
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("writing CONNECT request: %w", err)
+	}
+
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading CONNECT response: %w", err)
+	}
+	// a successful CONNECT response has no body, the tunnel starts right after it
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	return resp, nil
+}
+
+// proxyChallenge extracts the NTLM challenge from a 407 response
+func proxyChallenge(resp *http.Response) (string, []byte, error) {
+	for _, header := range resp.Header.Values("Proxy-Authenticate") {
+		scheme, data, found := strings.Cut(header, " ")
+		if !found || (!strings.EqualFold(scheme, "NTLM") && !strings.EqualFold(scheme, "Negotiate")) {
+			continue
+		}
+		challenge, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+		if err != nil {
+			return "", nil, fmt.Errorf("decoding proxy NTLM challenge: %w", err)
+		}
+		return scheme, challenge, nil
+	}
+
+	return "", nil, errors.New("proxy requires authentication but offered no NTLM challenge")
+}
@@ -0,0 +1,97 @@
+package winrm
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+// ntlmChallengeMessage is a minimal NTLM CHALLENGE message
+func ntlmChallengeMessage() []byte {
+	msg := make([]byte, 48)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[20:], 0x00000201) // unicode, NTLM
+	copy(msg[24:], "01234567")
+	return msg
+}
+
+// startNTLMProxy runs a CONNECT proxy requiring NTLM authentication,
+// recording the Proxy-Authorization headers it receives
+func startNTLMProxy(c *C) (net.Listener, *[]string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	var authorizations []string
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					req, err := http.ReadRequest(reader)
+					if err != nil {
+						return
+					}
+					authorization := req.Header.Get("Proxy-Authorization")
+					authorizations = append(authorizations, authorization)
+					data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "NTLM "))
+					if len(data) < 12 || binary.LittleEndian.Uint32(data[8:]) != 3 {
+						challenge := base64.StdEncoding.EncodeToString(ntlmChallengeMessage())
+						_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM "+challenge+"\r\nContent-Length: 0\r\n\r\n")
+						continue
+					}
+					target, err := net.Dial("tcp", req.Host)
+					if err != nil {
+						_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+						return
+					}
+					defer target.Close()
+					_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+					go func() { _, _ = io.Copy(target, reader) }()
+					_, _ = io.Copy(conn, target)
+					return
+				}
+			}(conn)
+		}
+	}()
+
+	return listener, &authorizations
+}
+
+func (s *WinRMSuite) TestNTLMProxy(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(response))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	proxy, authorizations := startNTLMProxy(c)
+	defer proxy.Close()
+	proxyURL := &url.URL{Scheme: "http", Host: proxy.Addr().String()}
+
+	params := NewParameters("PT60S", "en-US", 153600)
+	params.AllowInsecureBasic = true
+	params.TransportDecorator = func() Transporter { return NewClientWithNTLMProxy(proxyURL, "CORP\\proxyuser", "secret") }
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "test", "test", params)
+	c.Assert(err, IsNil)
+
+	shell, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Assert(*authorizations, HasLen, 2)
+	c.Assert((*authorizations)[1], Matches, "NTLM .+")
+}
@@ -0,0 +1,253 @@
+package winrm
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pywinrm default timeouts, read_timeout_sec must be above operation_timeout_sec
+const (
+	pywinrmReadTimeout      = 30
+	pywinrmOperationTimeout = 20
+)
+
+// NewClientWithPywinrmOptions creates a client from a target and options named after
+// pywinrm and the Ansible winrm connection plugin, to ease the migration of existing
+// inventories. The target is either a host name, a host:port pair or an URL like pywinrm
+// accepts. Option names can carry the "ansible_winrm_" prefix.
+//
+// Supported options are transport (basic, plaintext, ntlm, kerberos, certificate, ssl),
+// server_cert_validation (validate, ignore), read_timeout_sec, operation_timeout_sec,
+// scheme, port, path, ca_trust_path, cert_pem, cert_key_pem, message_encryption,
+// kerberos_hostname_override and realm. Unknown options are reported as an error.
+// Like in pywinrm, message_encryption auto, the default, encrypts the ntlm and kerberos
+// messages over http, where never is refused. KRB5_CONFIG sets the Kerberos configuration.
+// The basic and plaintext transports, the default one included, set
+// Parameters.AllowInsecureBasic as they explicitly allow Basic over http.
+func NewClientWithPywinrmOptions(target, user, password string, options map[string]string) (*Client, error) {
+	opts := make(map[string]string, len(options))
+	for name, value := range options {
+		name = strings.TrimPrefix(name, "ansible_winrm_")
+		switch name {
+		case "transport", "server_cert_validation", "read_timeout_sec", "operation_timeout_sec",
+			"scheme", "port", "path", "ca_trust_path", "cert_pem", "cert_key_pem",
+			"message_encryption", "kerberos_hostname_override", "realm":
+		default:
+			return nil, fmt.Errorf("unsupported option %q", name)
+		}
+		opts[name] = value
+	}
+
+	transport := strings.ToLower(opts["transport"])
+	if transport == "" {
+		transport = "plaintext"
+	}
+
+	scheme := opts["scheme"]
+	if scheme == "" {
+		scheme = "http"
+		if transport == "ssl" || transport == "certificate" {
+			scheme = "https"
+		}
+	}
+
+	host, port, err := parsePywinrmTarget(target, scheme)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(target, "://") {
+		if value, ok := opts["port"]; ok {
+			if port, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid port %q: %w", value, err)
+			}
+		}
+	}
+	if host.Scheme != "" {
+		scheme = host.Scheme
+	}
+	if port == 0 {
+		port = 5985
+		if scheme == "https" {
+			port = 5986
+		}
+	}
+
+	readTimeout, err := pywinrmSeconds(opts, "read_timeout_sec", pywinrmReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	operationTimeout, err := pywinrmSeconds(opts, "operation_timeout_sec", pywinrmOperationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if readTimeout <= operationTimeout {
+		return nil, fmt.Errorf("read_timeout_sec (%d) must exceed operation_timeout_sec (%d)", readTimeout, operationTimeout)
+	}
+
+	endpoint := NewEndpoint(host.Hostname(), port, scheme == "https", false, nil, nil, nil, time.Duration(readTimeout)*time.Second)
+	if path, ok := opts["path"]; ok {
+		endpoint.Path = path
+	} else if host.Path != "" {
+		endpoint.Path = host.RequestURI()
+	}
+
+	switch validation := strings.ToLower(opts["server_cert_validation"]); validation {
+	case "", "validate":
+	case "ignore":
+		endpoint.Insecure = true
+	default:
+		return nil, fmt.Errorf("invalid server_cert_validation %q", validation)
+	}
+
+	if path := opts["ca_trust_path"]; path != "" {
+		if endpoint.CACert, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("reading ca_trust_path: %w", err)
+		}
+	}
+
+	params := NewParameters(fmt.Sprintf("PT%dS", operationTimeout), DefaultParameters.Locale, DefaultParameters.EnvelopeSize)
+
+	encryption := opts["message_encryption"]
+	switch encryption {
+	case "", "auto", "always", "never":
+	default:
+		return nil, fmt.Errorf("invalid message_encryption %q", encryption)
+	}
+
+	switch transport {
+	case "basic", "plaintext", "ssl":
+		if encryption == "always" {
+			return nil, fmt.Errorf("message_encryption is not available with the %s transport", transport)
+		}
+		// like in pywinrm, basic and plaintext send the credentials in clear over http
+		params.AllowInsecureBasic = transport != "ssl"
+	case "ntlm":
+		encrypt, err := pywinrmEncrypts(encryption, transport, scheme)
+		if err != nil {
+			return nil, err
+		}
+		if encrypt {
+			params.TransportDecorator = func() Transporter {
+				encryption, _ := NewEncryption("ntlm")
+				return encryption
+			}
+		} else {
+			params.TransportDecorator = func() Transporter { return &ClientNTLM{} }
+		}
+	case "kerberos":
+		spnHost := host.Hostname()
+		if override := opts["kerberos_hostname_override"]; override != "" {
+			spnHost = override
+		}
+		realm := opts["realm"]
+		username := user
+		if i := strings.LastIndex(user, "@"); i >= 0 {
+			username = user[:i]
+			if realm == "" {
+				realm = strings.ToUpper(user[i+1:])
+			}
+		}
+		settings := &Settings{
+			WinRMUsername: username,
+			WinRMPassword: password,
+			WinRMHost:     host.Hostname(),
+			WinRMPort:     port,
+			WinRMProto:    scheme,
+			WinRMInsecure: endpoint.Insecure,
+			KrbRealm:      realm,
+			KrbConfig:     pywinrmKrbConfig(),
+			KrbSpn:        "HTTP/" + spnHost,
+		}
+		encrypt, err := pywinrmEncrypts(encryption, transport, scheme)
+		if err != nil {
+			return nil, err
+		}
+		if encrypt {
+			params.TransportDecorator = func() Transporter { return NewKerberosEncryption(settings) }
+		} else {
+			params.TransportDecorator = func() Transporter { return NewClientKerberos(settings) }
+		}
+	case "certificate":
+		if endpoint.Cert, err = os.ReadFile(opts["cert_pem"]); err != nil {
+			return nil, fmt.Errorf("reading cert_pem: %w", err)
+		}
+		if endpoint.Key, err = os.ReadFile(opts["cert_key_pem"]); err != nil {
+			return nil, fmt.Errorf("reading cert_key_pem: %w", err)
+		}
+		params.TransportDecorator = func() Transporter { return &ClientAuthRequest{} }
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", transport)
+	}
+
+	return NewClientWithParameters(endpoint, user, password, params)
+}
+
+// pywinrmEncrypts tells if the ntlm and kerberos transports encrypt the messages for the given
+// message_encryption: always, or over http with auto, the default. never is refused over http,
+// where the servers reject the unencrypted messages unless AllowUnencrypted is set.
+func pywinrmEncrypts(encryption, transport, scheme string) (bool, error) {
+	switch encryption {
+	case "always":
+		return true, nil
+	case "never":
+		if scheme == "http" {
+			return false, fmt.Errorf("message_encryption never can't be used with the %s transport over http", transport)
+		}
+		return false, nil
+	}
+	return scheme == "http", nil
+}
+
+// pywinrmKrbConfig returns the Kerberos configuration file, KRB5_CONFIG like for the MIT library
+// pywinrm relies on, /etc/krb5.conf by default
+func pywinrmKrbConfig() string {
+	if path := os.Getenv("KRB5_CONFIG"); path != "" {
+		return path
+	}
+	return "/etc/krb5.conf"
+}
+
+// parsePywinrmTarget splits a pywinrm target into its host and port (0 if absent)
+func parsePywinrmTarget(target, scheme string) (*url.URL, int, error) {
+	raw := target
+	if !strings.Contains(raw, "://") {
+		raw = scheme + "://" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	if parsed.Hostname() == "" {
+		return nil, 0, fmt.Errorf("invalid target %q: missing host", target)
+	}
+	if !strings.Contains(target, "://") {
+		parsed.Scheme = ""
+	}
+
+	var port int
+	if _, rawPort, err := net.SplitHostPort(parsed.Host); err == nil {
+		if port, err = strconv.Atoi(rawPort); err != nil {
+			return nil, 0, fmt.Errorf("invalid target %q: %w", target, err)
+		}
+	}
+
+	return parsed, port, nil
+}
+
+// pywinrmSeconds reads an integer number of seconds option
+func pywinrmSeconds(opts map[string]string, name string, defaultValue int) (int, error) {
+	value, ok := opts[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return seconds, nil
+}
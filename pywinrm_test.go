@@ -0,0 +1,115 @@
+package winrm
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestPywinrmOptionsDefaults(c *C) {
+	client, err := NewClientWithPywinrmOptions("winhost", "Administrator", "secret", nil)
+	c.Assert(err, IsNil)
+	c.Assert(client.url, Equals, "http://winhost:5985/wsman")
+	c.Assert(client.Timeout, Equals, "PT20S")
+	_, ok := client.http.(*clientRequest)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *WinRMSuite) TestPywinrmOptionsPlaintextRequest(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	target := host + ":" + strconv.Itoa(port)
+
+	// the default plaintext transport sends Basic over http
+	client, err := NewClientWithPywinrmOptions(target, "Administrator", "secret", nil)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	client, err = NewClientWithPywinrmOptions(target, "Administrator", "secret", map[string]string{"transport": "basic"})
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+}
+
+func (s *WinRMSuite) TestPywinrmOptionsAnsible(c *C) {
+	client, err := NewClientWithPywinrmOptions("winhost", "Administrator", "secret", map[string]string{
+		"ansible_winrm_transport":              "ntlm",
+		"ansible_winrm_scheme":                 "https",
+		"ansible_winrm_server_cert_validation": "ignore",
+		"ansible_winrm_read_timeout_sec":       "120",
+		"ansible_winrm_operation_timeout_sec":  "100",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(client.url, Equals, "https://winhost:5986/wsman")
+	c.Assert(client.Timeout, Equals, "PT100S")
+	_, ok := client.http.(*ClientNTLM)
+	c.Assert(ok, Equals, true)
+}
+
+func (s *WinRMSuite) TestPywinrmOptionsMessageEncryption(c *C) {
+	// auto, the default, encrypts ntlm over http
+	client, err := NewClientWithPywinrmOptions("winhost", "Administrator", "secret", map[string]string{"transport": "ntlm"})
+	c.Assert(err, IsNil)
+	encryption, ok := client.http.(*Encryption)
+	c.Assert(ok, Equals, true)
+	c.Assert(encryption.protocol, Equals, "ntlm")
+
+	client, err = NewClientWithPywinrmOptions("winhost", "Administrator", "secret", map[string]string{"transport": "ntlm", "message_encryption": "auto", "scheme": "https"})
+	c.Assert(err, IsNil)
+	_, ok = client.http.(*ClientNTLM)
+	c.Assert(ok, Equals, true)
+
+	previous, set := os.LookupEnv("KRB5_CONFIG")
+	c.Assert(os.Setenv("KRB5_CONFIG", "/opt/krb5.conf"), IsNil)
+	defer func() {
+		if set {
+			_ = os.Setenv("KRB5_CONFIG", previous)
+		} else {
+			_ = os.Unsetenv("KRB5_CONFIG")
+		}
+	}()
+	client, err = NewClientWithPywinrmOptions("winhost", "Administrator@corp.example.com", "secret", map[string]string{"transport": "kerberos"})
+	c.Assert(err, IsNil)
+	encryption, ok = client.http.(*Encryption)
+	c.Assert(ok, Equals, true)
+	c.Assert(encryption.kerberos.KrbConf, Equals, "/opt/krb5.conf")
+
+	_, err = NewClientWithPywinrmOptions("winhost", "Administrator", "secret", map[string]string{"transport": "ntlm", "message_encryption": "never"})
+	c.Assert(err, ErrorMatches, "message_encryption never can't be used with the ntlm transport over http")
+	_, err = NewClientWithPywinrmOptions("winhost", "Administrator", "secret", map[string]string{"transport": "ntlm", "message_encryption": "sometimes"})
+	c.Assert(err, ErrorMatches, `invalid message_encryption "sometimes"`)
+}
+
+func (s *WinRMSuite) TestPywinrmOptionsURLTarget(c *C) {
+	client, err := NewClientWithPywinrmOptions("https://winhost:15986/wsman", "Administrator", "secret", map[string]string{
+		"transport": "basic",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(client.url, Equals, "https://winhost:15986/wsman")
+}
+
+func (s *WinRMSuite) TestPywinrmOptionsErrors(c *C) {
+	_, err := NewClientWithPywinrmOptions("winhost", "u", "p", map[string]string{"read_timeout_sec": "10", "operation_timeout_sec": "20"})
+	c.Assert(err, ErrorMatches, "read_timeout_sec .* must exceed operation_timeout_sec .*")
+	_, err = NewClientWithPywinrmOptions("winhost", "u", "p", map[string]string{"transport": "credssp"})
+	c.Assert(err, ErrorMatches, `unsupported transport "credssp"`)
+	_, err = NewClientWithPywinrmOptions("winhost", "u", "p", map[string]string{"ansible_winrm_unknown": "x"})
+	c.Assert(err, ErrorMatches, `unsupported option "unknown"`)
+}
+
+func (s *WinRMSuite) TestPywinrmOptionsPath(c *C) {
+	client, err := NewClientWithPywinrmOptions("https://gateway/hosts/web01/wsman?x=1", "Administrator", "secret", nil)
+	c.Assert(err, IsNil)
+	c.Assert(client.url, Equals, "https://gateway:5986/hosts/web01/wsman?x=1")
+
+	client, err = NewClientWithPywinrmOptions("gateway", "Administrator", "secret", map[string]string{"path": "/custom"})
+	c.Assert(err, IsNil)
+	c.Assert(client.url, Equals, "http://gateway:5985/custom")
+}
@@ -0,0 +1,92 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// defaultQuotaRetryInterval is the delay between two attempts of an operation
+// rejected because of the server quotas when QuotaQueue.RetryInterval isn't set
+const defaultQuotaRetryInterval = time.Second
+
+// QuotaQueue configures how operations rejected by the server because of its
+// MaxShellsPerUser or MaxConcurrentOperationsPerUser quotas wait client-side.
+// The waiting operations aren't served in order: each one is attempted again every
+// RetryInterval, the first finding free quota on the server going through.
+type QuotaQueue struct {
+	// MaxQueued is the number of rejected operations allowed to wait for quota at the same time,
+	// the ones rejected while MaxQueued are waiting failing right away with ErrQuotaExceeded.
+	// It doesn't bound the operations sent, only the waiting ones. Zero means no limit.
+	MaxQueued int
+	// MaxWait is how long an operation waits for quota before failing,
+	// zero meaning until its context is done
+	MaxWait time.Duration
+	// RetryInterval is the delay between two attempts, one second by default
+	RetryInterval time.Duration
+}
+
+type quotaQueue struct {
+	QuotaQueue
+	waiting int32
+	retries int64
+}
+
+func newQuotaQueue(config *QuotaQueue) *quotaQueue {
+	queue := &quotaQueue{QuotaQueue: *config}
+	if queue.RetryInterval <= 0 {
+		queue.RetryInterval = defaultQuotaRetryInterval
+	}
+	return queue
+}
+
+// isQuotaFault tells if err is the fault of an operation rejected because of a server quota
+func isQuotaFault(err error) bool {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.FaultCode() {
+	case FaultQuotaMaxShells, FaultQuotaMaxOperations:
+		return true
+	}
+	return false
+}
+
+// wait retries post until it isn't rejected because of the quotas anymore,
+// err being the quota fault of the first attempt
+func (q *quotaQueue) wait(ctx context.Context, err error, post func() (string, error)) (string, error) {
+	if waiting := atomic.AddInt32(&q.waiting, 1); q.MaxQueued > 0 && int(waiting) > q.MaxQueued {
+		atomic.AddInt32(&q.waiting, -1)
+		return "", fmt.Errorf("%w, %d operations already queued: %w", ErrQuotaExceeded, q.MaxQueued, err)
+	}
+	defer atomic.AddInt32(&q.waiting, -1)
+
+	var deadline time.Time
+	if q.MaxWait > 0 {
+		deadline = time.Now().Add(q.MaxWait)
+	}
+
+	for {
+		if !deadline.IsZero() && time.Now().Add(q.RetryInterval).After(deadline) {
+			return "", fmt.Errorf("%w after waiting %s: %w", ErrQuotaExceeded, q.MaxWait, err)
+		}
+
+		timer := time.NewTimer(q.RetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+
+		atomic.AddInt64(&q.retries, 1)
+		var response string
+		response, err = post()
+		if err == nil || !isQuotaFault(err) {
+			return response, err
+		}
+	}
+}
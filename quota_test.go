@@ -0,0 +1,114 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func quotaFault(code string) error {
+	return &HTTPError{
+		StatusCode: http.StatusInternalServerError,
+		Body:       fmt.Sprintf(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><s:Fault><s:Detail><f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="%s" Machine="windows-host"><f:Message>The WS-Management service cannot process the request. This user is allowed a maximum number of 30 concurrent shells, which has been exceeded.</f:Message></f:WSManFault></s:Detail></s:Fault></s:Body></s:Envelope>`, code),
+	}
+}
+
+func quotaClient(c *C, queue *QuotaQueue, rejections int) (*Client, *int) {
+	params := NewParametersBuilder().QuotaQueue(queue).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+
+	attempts := 0
+	r := &Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		attempts++
+		if attempts <= rejections {
+			return "", quotaFault(FaultQuotaMaxShells)
+		}
+		return createShellResponse, nil
+	}
+	client.http = r
+	return client, &attempts
+}
+
+func (s *WinRMSuite) TestQuotaFaultWithoutQueue(c *C) {
+	client, attempts := quotaClient(c, nil, 1)
+
+	_, err := client.CreateShell()
+	c.Assert(errors.Is(err, ErrQuotaExceeded), Equals, true)
+	var httpErr *HTTPError
+	c.Assert(errors.As(err, &httpErr), Equals, true)
+	c.Assert(httpErr.FaultCode(), Equals, FaultQuotaMaxShells)
+	c.Assert(*attempts, Equals, 1)
+}
+
+func (s *WinRMSuite) TestQuotaQueueRetries(c *C) {
+	client, attempts := quotaClient(c, &QuotaQueue{RetryInterval: time.Millisecond}, 3)
+
+	shell, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Assert(*attempts, Equals, 4)
+}
+
+func (s *WinRMSuite) TestQuotaQueueLimits(c *C) {
+	client, _ := quotaClient(c, &QuotaQueue{RetryInterval: 5 * time.Millisecond, MaxWait: 20 * time.Millisecond}, 100)
+	_, err := client.CreateShell()
+	c.Assert(errors.Is(err, ErrQuotaExceeded), Equals, true)
+	c.Assert(err, ErrorMatches, "server quota exceeded after waiting 20ms.*")
+
+	client, attempts := quotaClient(c, &QuotaQueue{MaxQueued: 1}, 100)
+	client.quota.waiting = 1
+	_, err = client.CreateShell()
+	c.Assert(errors.Is(err, ErrQuotaExceeded), Equals, true)
+	c.Assert(*attempts, Equals, 1)
+
+	client, _ = quotaClient(c, &QuotaQueue{}, 100)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.CreateShellWithContext(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+}
+
+type tokenLimiter struct {
+	tokens int
+	waits  int
+}
+
+func (l *tokenLimiter) Wait(ctx context.Context) error {
+	l.waits++
+	if l.tokens == 0 {
+		return errors.New("rate: Wait(n=1) would exceed context deadline")
+	}
+	l.tokens--
+	return nil
+}
+
+func (s *WinRMSuite) TestRateLimiter(c *C) {
+	limiter := &tokenLimiter{tokens: 2}
+	params := NewParametersBuilder().RateLimiter(limiter).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+
+	posts := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		posts++
+		return createShellResponse, nil
+	}
+	client.http = &r
+
+	for i := 0; i < 2; i++ {
+		_, err = client.CreateShell()
+		c.Assert(err, IsNil)
+	}
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, "rate: .*")
+	c.Assert(limiter.waits, Equals, 3)
+	c.Assert(posts, Equals, 2)
+}
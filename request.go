@@ -2,6 +2,11 @@ package winrm
 
 import (
 	"encoding/base64"
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/satendraraj/winrm/soap"
@@ -16,25 +21,91 @@ func defaultHeaders(message *soap.SoapMessage, url string, params *Parameters) *
 	return message.
 		Header().
 		To(url).
-		ReplyTo("http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous").
+		ReplyTo(anonymousAddress).
 		MaxEnvelopeSize(params.EnvelopeSize).
 		Id(genUUID()).
 		Locale(params.Locale).
 		Timeout(params.Timeout)
 }
 
+// shellResourceURI returns the resource URI of the shells created with params
+func shellResourceURI(params *Parameters) string {
+	if params.ResourceURI != "" {
+		return params.ResourceURI
+	}
+	return ResourceURICmdShell
+}
+
+// winrsOptions returns the WinRS specific options to send, which are
+// left out when talking to servers not implementing them
+func winrsOptions(params *Parameters, options ...*soap.HeaderOption) []soap.HeaderOption {
+	if params.Compatibility == CompatibilityOMI {
+		return nil
+	}
+	result := make([]soap.HeaderOption, 0, len(options))
+	for _, option := range options {
+		result = append(result, *option)
+	}
+	return result
+}
+
+// winrsBool formats b as the WinRS options expect it
+func winrsBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// escapeXML escapes s for an XML attribute value, the DOM writing them as is
+func escapeXML(s string) string {
+	var escaped strings.Builder
+	_ = xml.EscapeText(&escaped, []byte(s))
+	return escaped.String()
+}
+
+// cdata wraps s in a CDATA section for an element content, the DOM writing it as is.
+// A "]]>" in s, which would end the section, is split across two sections.
+func cdata(s string) string {
+	return "<![CDATA[" + strings.ReplaceAll(s, "]]>", "]]]]><![CDATA[>") + "]]>"
+}
+
+// xsDuration formats d as an xs:duration, like PT90S
+func xsDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
+
 // NewOpenShellRequest makes a new soap request
 func NewOpenShellRequest(uri string, params *Parameters) *soap.SoapMessage {
+	return NewOpenShellRequestWithOptions(uri, params, nil)
+}
+
+// NewOpenShellRequestWithOptions makes a shell creation request with the settings of options,
+// which can be nil
+func NewOpenShellRequestWithOptions(uri string, params *Parameters, options *ShellOptions) *soap.SoapMessage {
 	if params == nil {
 		params = DefaultParameters
 	}
+	if options == nil {
+		options = &ShellOptions{}
+	}
+
+	codepage := options.Codepage
+	if codepage == 0 {
+		codepage = CodepageUTF8
+	}
+	headerOptions := winrsOptions(params,
+		soap.NewHeaderOption("WINRS_NOPROFILE", winrsBool(options.NoProfile)),
+		soap.NewHeaderOption("WINRS_CODEPAGE", strconv.Itoa(codepage)))
+	if params.ProtocolVersion != "" {
+		headerOptions = append(headerOptions, *soap.NewMustComplyHeaderOption("protocolversion", params.ProtocolVersion))
+	}
 
 	message := soap.NewMessage()
 	defaultHeaders(message, uri, params).
-		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Create").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
-		AddOption(soap.NewHeaderOption("WINRS_NOPROFILE", "FALSE")).
-		AddOption(soap.NewHeaderOption("WINRS_CODEPAGE", "65001")).
+		Action(ActionCreate).
+		ResourceURI(shellResourceURI(params)).
+		Options(headerOptions).
 		Build()
 
 	body := message.CreateBodyElement("Shell", soap.DOM_NS_WIN_SHELL)
@@ -43,6 +114,27 @@ func NewOpenShellRequest(uri string, params *Parameters) *soap.SoapMessage {
 	output := message.CreateElement(body, "OutputStreams", soap.DOM_NS_WIN_SHELL)
 	output.SetContent("stdout stderr")
 
+	if len(options.Env) > 0 {
+		names := make([]string, 0, len(options.Env))
+		for name := range options.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		environment := message.CreateElement(body, "Environment", soap.DOM_NS_WIN_SHELL)
+		for _, name := range names {
+			variable := message.CreateElement(environment, "Variable", soap.DOM_NS_WIN_SHELL)
+			variable.SetAttr("Name", escapeXML(name))
+			variable.SetContent(cdata(options.Env[name]))
+		}
+	}
+	if options.Lifetime > 0 {
+		message.CreateElement(body, "Lifetime", soap.DOM_NS_WIN_SHELL).SetContent(xsDuration(options.Lifetime))
+	}
+	if options.IdleTimeOut > 0 {
+		message.CreateElement(body, "IdleTimeOut", soap.DOM_NS_WIN_SHELL).SetContent(xsDuration(options.IdleTimeOut))
+	}
+
 	return message
 }
 
@@ -53,9 +145,27 @@ func NewDeleteShellRequest(uri, shellID string, params *Parameters) *soap.SoapMe
 	}
 	message := soap.NewMessage()
 	defaultHeaders(message, uri, params).
-		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete").
+		Action(ActionDelete).
+		ShellId(shellID).
+		ResourceURI(shellResourceURI(params)).
+		Build()
+
+	message.NewBody()
+
+	return message
+}
+
+// NewGetShellRequest makes a request getting the properties of a shell, which fails
+// with FaultShellNotFound when it doesn't exist anymore
+func NewGetShellRequest(uri, shellID string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action(ActionGet).
 		ShellId(shellID).
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		ResourceURI(shellResourceURI(params)).
 		Build()
 
 	message.NewBody()
@@ -63,29 +173,96 @@ func NewDeleteShellRequest(uri, shellID string, params *Parameters) *soap.SoapMe
 	return message
 }
 
+// NewDisconnectRequest makes a request disconnecting the client from a shell, whose commands
+// go on running with their output buffered by the server, which keeps the shell idleTimeout
+// (its IdleTimeOut when zero) waiting for a reconnection
+func NewDisconnectRequest(uri, shellID string, idleTimeout time.Duration, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action(ActionDisconnect).
+		ShellId(shellID).
+		ResourceURI(shellResourceURI(params)).
+		Build()
+
+	body := message.CreateBodyElement("Disconnect", soap.DOM_NS_WIN_SHELL)
+	if idleTimeout > 0 {
+		message.CreateElement(body, "IdleTimeOut", soap.DOM_NS_WIN_SHELL).SetContent(xsDuration(idleTimeout))
+	}
+
+	return message
+}
+
+// NewReconnectRequest makes a request reconnecting the client which disconnected from a shell
+func NewReconnectRequest(uri, shellID string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action(ActionReconnect).
+		ShellId(shellID).
+		ResourceURI(shellResourceURI(params)).
+		Build()
+
+	message.CreateBodyElement("Reconnect", soap.DOM_NS_WIN_SHELL)
+
+	return message
+}
+
+// NewConnectRequest makes a request connecting a new client to a disconnected shell
+func NewConnectRequest(uri, shellID string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action(ActionConnect).
+		ShellId(shellID).
+		ResourceURI(shellResourceURI(params)).
+		Build()
+
+	// the ShellId selector identifies the shell, ConnectType has no attribute for it
+	message.CreateBodyElement("Connect", soap.DOM_NS_WIN_SHELL)
+
+	return message
+}
+
 // NewExecuteCommandRequest exec command on specific shellID
 func NewExecuteCommandRequest(uri, shellID, command string, arguments []string, params *Parameters) *soap.SoapMessage {
+	return NewExecuteCommandRequestWithOptions(uri, shellID, command, &ExecuteOptions{
+		Args:             arguments,
+		ConsoleModeStdin: true,
+	}, params)
+}
+
+// NewExecuteCommandRequestWithOptions makes a command request with the given arguments
+// and WinRS options. options.Env and options.Dir must already be part of command.
+func NewExecuteCommandRequestWithOptions(uri, shellID, command string, options *ExecuteOptions, params *Parameters) *soap.SoapMessage {
 	if params == nil {
 		params = DefaultParameters
 	}
 	message := soap.NewMessage()
 	defaultHeaders(message, uri, params).
-		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		Action(ActionCommand).
+		ResourceURI(shellResourceURI(params)).
 		ShellId(shellID).
-		AddOption(soap.NewHeaderOption("WINRS_CONSOLEMODE_STDIN", "TRUE")).
-		AddOption(soap.NewHeaderOption("WINRS_SKIP_CMD_SHELL", "FALSE")).
+		Options(winrsOptions(params,
+			soap.NewHeaderOption("WINRS_CONSOLEMODE_STDIN", winrsBool(options.ConsoleModeStdin)),
+			soap.NewHeaderOption("WINRS_SKIP_CMD_SHELL", winrsBool(options.SkipCmdShell)))).
 		Build()
 
 	body := message.CreateBodyElement("CommandLine", soap.DOM_NS_WIN_SHELL)
 
 	// ensure special characters like & don't mangle the request XML
-	command = "<![CDATA[" + command + "]]>"
+	command = cdata(command)
 	commandElement := message.CreateElement(body, "Command", soap.DOM_NS_WIN_SHELL)
 	commandElement.SetContent(command)
 
-	for _, arg := range arguments {
-		arg = "<![CDATA[" + arg + "]]>"
+	for _, arg := range options.Args {
+		arg = cdata(arg)
 		argumentsElement := message.CreateElement(body, "Arguments", soap.DOM_NS_WIN_SHELL)
 		argumentsElement.SetContent(arg)
 	}
@@ -100,8 +277,8 @@ func NewGetOutputRequest(uri, shellID, commandID, streams string, params *Parame
 	}
 	message := soap.NewMessage()
 	defaultHeaders(message, uri, params).
-		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		Action(ActionReceive).
+		ResourceURI(shellResourceURI(params)).
 		ShellId(shellID).
 		Build()
 
@@ -121,8 +298,8 @@ func NewSendInputRequest(uri, shellID, commandID string, input []byte, eof bool,
 	message := soap.NewMessage()
 
 	defaultHeaders(message, uri, params).
-		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		Action(ActionSend).
+		ResourceURI(shellResourceURI(params)).
 		ShellId(shellID).
 		Build()
 
@@ -141,21 +318,86 @@ func NewSendInputRequest(uri, shellID, commandID string, input []byte, eof bool,
 
 // NewSignalRequest NewSignalRequest
 func NewSignalRequest(uri string, shellID string, commandID string, params *Parameters) *soap.SoapMessage {
+	return NewSignalRequestWithCode(uri, shellID, commandID, SignalTerminate, params)
+}
+
+// NewSignalRequestWithCode makes a request sending the signal code (SignalCtrlC...) to a command
+func NewSignalRequestWithCode(uri, shellID, commandID, code string, params *Parameters) *soap.SoapMessage {
 	if params == nil {
 		params = DefaultParameters
 	}
 	message := soap.NewMessage()
 
 	defaultHeaders(message, uri, params).
-		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		Action(ActionSignal).
+		ResourceURI(shellResourceURI(params)).
 		ShellId(shellID).
 		Build()
 
 	signal := message.CreateBodyElement("Signal", soap.DOM_NS_WIN_SHELL)
 	signal.SetAttr("CommandId", commandID)
-	code := message.CreateElement(signal, "Code", soap.DOM_NS_WIN_SHELL)
-	code.SetContent("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate")
+	message.CreateElement(signal, "Code", soap.DOM_NS_WIN_SHELL).SetContent(code)
+
+	return message
+}
+
+// NewIdentifyRequest makes a WS-Management Identify request
+func NewIdentifyRequest() *soap.SoapMessage {
+	message := soap.NewMessage()
+	message.Header().Build()
+	message.CreateBodyElement("Identify", soap.DOM_NS_WSMAN_ID)
+
+	return message
+}
+
+// enumerationMaxElements is the number of items asked for in each enumeration response,
+// the server sends less of them if they don't fit in the envelope
+const enumerationMaxElements = 32000
+
+// NewEnumerateRequest makes a WS-Enumeration Enumerate request of resourceURI,
+// with an optional WQL filter
+func NewEnumerateRequest(uri, resourceURI, filter string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+
+	defaultHeaders(message, uri, params).
+		Action(ActionEnumerate).
+		ResourceURI(resourceURI).
+		Build()
+
+	enumerate := message.CreateBodyElement("Enumerate", soap.DOM_NS_ENUM)
+	message.CreateElement(enumerate, "OptimizeEnumeration", soap.DOM_NS_WSMAN_DMTF)
+	maxElements := message.CreateElement(enumerate, "MaxElements", soap.DOM_NS_WSMAN_DMTF)
+	maxElements.SetContent(strconv.Itoa(enumerationMaxElements))
+	if filter != "" {
+		filterElement := message.CreateElement(enumerate, "Filter", soap.DOM_NS_WSMAN_DMTF)
+		filterElement.SetAttr("Dialect", FilterDialectWQL)
+		filterElement.SetContent(cdata(filter))
+	}
+
+	return message
+}
+
+// NewPullRequest makes a WS-Enumeration Pull request continuing the enumeration
+// identified by enumerationContext
+func NewPullRequest(uri, resourceURI, enumerationContext string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+
+	defaultHeaders(message, uri, params).
+		Action(ActionPull).
+		ResourceURI(resourceURI).
+		Build()
+
+	pull := message.CreateBodyElement("Pull", soap.DOM_NS_ENUM)
+	contextElement := message.CreateElement(pull, "EnumerationContext", soap.DOM_NS_ENUM)
+	contextElement.SetContent(enumerationContext)
+	maxElements := message.CreateElement(pull, "MaxElements", soap.DOM_NS_ENUM)
+	maxElements.SetContent(strconv.Itoa(enumerationMaxElements))
 
 	return message
 }
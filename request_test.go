@@ -3,12 +3,13 @@ package winrm
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ChrisTrenkamp/goxpath"
 	"github.com/ChrisTrenkamp/goxpath/tree"
 	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
 	"github.com/masterzen/simplexml/dom"
-	"github.com/masterzen/winrm/soap"
+	"github.com/satendraraj/winrm/soap"
 	. "gopkg.in/check.v1"
 )
 
@@ -19,6 +20,16 @@ type WinRMSuite struct{}
 
 var _ = Suite(&WinRMSuite{})
 
+// SetUpSuite lets the Basic authentication of the clients built from DefaultParameters reach
+// the plain HTTP test servers, TestAllowInsecureBasic checking the refusal
+func (s *WinRMSuite) SetUpSuite(c *C) {
+	DefaultParameters.AllowInsecureBasic = true
+}
+
+func (s *WinRMSuite) TearDownSuite(c *C) {
+	DefaultParameters.AllowInsecureBasic = false
+}
+
 func (s *WinRMSuite) TestOpenShellRequest(c *C) {
 	openShell := NewOpenShellRequest("http://localhost", nil)
 	defer openShell.Free()
@@ -103,6 +114,82 @@ func (s *WinRMSuite) TestSignalRequest(c *C) {
 	assertXPath(c, request.Doc(), "//rsp:Signal[@CommandId=\"COMMANDID\"]/rsp:Code", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate")
 }
 
+func (s *WinRMSuite) TestOpenShellRequestWithProtocolVersion(c *C) {
+	params := NewParameters("PT60S", "en-US", 153600)
+	params.ProtocolVersion = "2.3"
+	openShell := NewOpenShellRequest("http://localhost", params)
+	defer openShell.Free()
+
+	assertXPath(c, openShell.Doc(), "//w:Option[@Name=\"protocolversion\"][@MustComply=\"true\"]", "2.3")
+}
+
+func (s *WinRMSuite) TestOpenShellRequestWithEnv(c *C) {
+	openShell := NewOpenShellRequestWithOptions("http://localhost", nil, &ShellOptions{
+		Env: map[string]string{"DEPLOY_ENV": "prod & staging", `A"B`: "1", "MARKER": "a]]>b]]>"},
+	})
+	defer openShell.Free()
+
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:Environment/rsp:Variable[@Name=\"DEPLOY_ENV\"]", "prod & staging")
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:Environment/rsp:Variable[@Name='A\"B']", "1")
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:Environment/rsp:Variable[@Name=\"MARKER\"]", "a]]>b]]>")
+
+	openShell = NewOpenShellRequestWithOptions("http://localhost", nil, nil)
+	defer openShell.Free()
+	assertXPathNil(c, openShell.Doc(), "//rsp:Environment")
+	assertXPathNil(c, openShell.Doc(), "//rsp:IdleTimeOut")
+	assertXPathNil(c, openShell.Doc(), "//rsp:Lifetime")
+}
+
+func (s *WinRMSuite) TestOpenShellRequestWithTimeouts(c *C) {
+	openShell := NewOpenShellRequestWithOptions("http://localhost", nil, &ShellOptions{
+		IdleTimeOut: 2 * time.Hour,
+		Lifetime:    1500 * time.Millisecond,
+	})
+	defer openShell.Free()
+
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:IdleTimeOut", "PT7200S")
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:Lifetime", "PT1.5S")
+}
+
+func (s *WinRMSuite) TestOpenShellRequestCodepage(c *C) {
+	openShell := NewOpenShellRequest("http://localhost", nil)
+	defer openShell.Free()
+	assertXPath(c, openShell.Doc(), "//w:Option[@Name=\"WINRS_CODEPAGE\"]", "65001")
+
+	openShell = NewOpenShellRequestWithOptions("http://localhost", nil, &ShellOptions{Codepage: 850})
+	defer openShell.Free()
+	assertXPath(c, openShell.Doc(), "//w:Option[@Name=\"WINRS_CODEPAGE\"]", "850")
+}
+
+func (s *WinRMSuite) TestOpenShellRequestNoProfile(c *C) {
+	openShell := NewOpenShellRequest("http://localhost", nil)
+	defer openShell.Free()
+	assertXPath(c, openShell.Doc(), "//w:Option[@Name=\"WINRS_NOPROFILE\"]", "FALSE")
+
+	openShell = NewOpenShellRequestWithOptions("http://localhost", nil, &ShellOptions{NoProfile: true})
+	defer openShell.Free()
+	assertXPath(c, openShell.Doc(), "//w:Option[@Name=\"WINRS_NOPROFILE\"]", "TRUE")
+
+	request := NewExecuteCommandRequestWithOptions("http://localhost", "SHELLID", "whoami.exe", &ExecuteOptions{SkipCmdShell: true}, nil)
+	defer request.Free()
+	assertXPath(c, request.Doc(), "//w:Option[@Name=\"WINRS_SKIP_CMD_SHELL\"]", "TRUE")
+}
+
+func (s *WinRMSuite) TestOMICompatibilityRequests(c *C) {
+	params := NewParameters("PT60S", "en-US", 153600)
+	params.Compatibility = CompatibilityOMI
+	params.ResourceURI = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/shell"
+
+	openShell := NewOpenShellRequest("http://localhost", params)
+	defer openShell.Free()
+	assertXPath(c, openShell.Doc(), "//w:ResourceURI", "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/shell")
+	assertXPathNil(c, openShell.Doc(), "//w:OptionSet")
+
+	request := NewExecuteCommandRequest("http://localhost", "SHELLID", "ls", nil, params)
+	defer request.Free()
+	assertXPathNil(c, request.Doc(), "//w:Option[@Name=\"WINRS_CONSOLEMODE_STDIN\"]")
+}
+
 func assertXPath(c *C, doc *dom.Document, request string, expected string) {
 	nodes, err := parseXPath(doc, request)
 
@@ -149,3 +236,25 @@ func parseXPath(doc *dom.Document, request string) (tree.NodeSet, error) {
 	}
 	return nodes, nil
 }
+
+func (s *WinRMSuite) TestExecuteCommandWithOptionsRequest(c *C) {
+	options := &ExecuteOptions{Args: []string{"/all"}, SkipCmdShell: true}
+	request := NewExecuteCommandRequestWithOptions("http://localhost", "SHELLID", "ipconfig.exe", options, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//w:Option[@Name=\"WINRS_CONSOLEMODE_STDIN\"]", "FALSE")
+	assertXPath(c, request.Doc(), "//w:Option[@Name=\"WINRS_SKIP_CMD_SHELL\"]", "TRUE")
+	assertXPath(c, request.Doc(), "//rsp:CommandLine/rsp:Command", "ipconfig.exe")
+	assertXPath(c, request.Doc(), "//rsp:CommandLine/rsp:Arguments", "/all")
+}
+
+func (s *WinRMSuite) TestEnumerateRequest(c *C) {
+	request := NewEnumerateRequest("http://localhost", "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*", "SELECT * FROM Win32_Service", nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*")
+	assertXPath(c, request.Doc(), "//n:Enumerate/w:MaxElements", "32000")
+	assertXPath(c, request.Doc(), "//n:Enumerate/w:Filter/@Dialect", "http://schemas.microsoft.com/wbem/wsman/1/WQL")
+	assertXPath(c, request.Doc(), "//n:Enumerate/w:Filter", "SELECT * FROM Win32_Service")
+}
@@ -2,6 +2,7 @@ package winrm
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -72,7 +73,12 @@ func ParseOpenShellResponse(response string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return first(doc, "//w:Selector[@Name='ShellId']")
+	shellID, err := first(doc, "//w:Selector[@Name='ShellId']")
+	if err != nil || shellID != "" {
+		return shellID, err
+	}
+	// some servers (like OMI) only return the id in the Shell body
+	return first(doc, "//rsp:Shell/rsp:ShellId")
 }
 
 // ParseExecuteCommandResponse ParseExecuteCommandResponse
@@ -94,7 +100,7 @@ func ParseExecuteCommandResponse(response string) (commandId string, err error)
 	}
 
 	switch action {
-	case "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandResponse":
+	case ActionCommandResponse:
 		commandId, err = first(doc, "//rsp:CommandId")
 		if err != nil {
 			return "", fmt.Errorf("finding command id: %w", err)
@@ -127,7 +133,7 @@ func ParseSlurpOutputErrResponse(response string, stdout, stderr io.Writer) (boo
 		stderr.Write(content)
 	}
 
-	ended, _ := any(doc, "//*[@State='http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done']")
+	ended, _ := any(doc, "//*[@State='"+CommandStateDone+"']")
 
 	if ended {
 		finished = ended
@@ -157,7 +163,7 @@ func ParseSlurpOutputResponse(response string, stream io.Writer, streamType stri
 		_, _ = stream.Write(content)
 	}
 
-	ended, _ := any(doc, "//*[@State='http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done']")
+	ended, _ := any(doc, "//*[@State='"+CommandStateDone+"']")
 
 	if ended {
 		finished = ended
@@ -171,3 +177,114 @@ func ParseSlurpOutputResponse(response string, stream io.Writer, streamType stri
 
 	return finished, exitCode, err
 }
+
+// OutputChunk is the decoded content of one stream element of a Receive response
+type OutputChunk struct {
+	// Stream is "stdout" or "stderr"
+	Stream string
+	Data   []byte
+}
+
+// ParseOutputChunksResponse parses a Receive response into its output chunks,
+// in the order the server sent them, like ParseSlurpOutputErrResponse otherwise
+func ParseOutputChunksResponse(response string) ([]OutputChunk, bool, int, error) {
+	chunks, state, exitCode, err := parseReceiveResponse(response)
+	return chunks, state == CommandStateDone, exitCode, err
+}
+
+// parseReceiveResponse parses a Receive response into its output chunks, the command state
+// it reports (like CommandStateRunning), empty if none, and the exit code of a finished command
+func parseReceiveResponse(response string) ([]OutputChunk, string, int, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	var chunks []OutputChunk
+	nodes, _ := xPath(doc, "//rsp:Stream")
+	for _, node := range nodes {
+		content, _ := base64.StdEncoding.DecodeString(node.ResValue())
+		if len(content) == 0 {
+			continue
+		}
+		var stream string
+		if element, ok := node.(tree.Elem); ok {
+			stream = tree.GetAttrValOrEmpty(element, "Name", "")
+		}
+		chunks = append(chunks, OutputChunk{Stream: stream, Data: content})
+	}
+
+	var exitCode int
+	state, _ := first(doc, "//rsp:CommandState/@State")
+	if state == CommandStateDone {
+		if exitBool, _ := any(doc, "//rsp:ExitCode"); exitBool {
+			exit, _ := first(doc, "//rsp:ExitCode")
+			exitCode, _ = strconv.Atoi(exit)
+		}
+	}
+
+	return chunks, state, exitCode, nil
+}
+
+// ParseIdentifyResponse ParseIdentifyResponse
+func ParseIdentifyResponse(response string) (*ServerInfo, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, _ := any(doc, "//wsmid:IdentifyResponse"); !ok {
+		return nil, errors.New("invalid identify response")
+	}
+
+	info := &ServerInfo{}
+	info.ProtocolVersion, _ = first(doc, "//wsmid:IdentifyResponse/wsmid:ProtocolVersion")
+	info.ProductVendor, _ = first(doc, "//wsmid:IdentifyResponse/wsmid:ProductVendor")
+	info.ProductVersion, _ = first(doc, "//wsmid:IdentifyResponse/wsmid:ProductVersion")
+
+	// Windows reports something like "OS: 10.0.17763 SP: 0.0 Stack: 3.0"
+	if i := strings.Index(info.ProductVersion, "Stack:"); i >= 0 {
+		fields := strings.Fields(info.ProductVersion[i+len("Stack:"):])
+		if len(fields) > 0 {
+			info.StackVersion = fields[0]
+		}
+	}
+
+	return info, nil
+}
+
+// ParseEnumerateResponse parses an Enumerate or Pull response, returning the
+// items as XML documents, the context to pull the next ones and whether
+// the enumeration is over
+func ParseEnumerateResponse(response string) (items []string, enumerationContext string, endOfSequence bool, err error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	// optimized enumerations return the first items in a w:Items element
+	for _, xpath := range []string{"//n:Items/*", "//w:Items/*"} {
+		nodes, err := xPath(doc, xpath)
+		if err != nil {
+			return nil, "", false, err
+		}
+		for _, node := range nodes {
+			item, err := goxpath.MarshalStr(node)
+			if err != nil {
+				return nil, "", false, fmt.Errorf("marshaling enumeration item: %w", err)
+			}
+			items = append(items, item)
+		}
+	}
+
+	enumerationContext, err = first(doc, "//n:EnumerationContext")
+	if err != nil {
+		return nil, "", false, err
+	}
+	endOfSequence, err = any(doc, "//n:EndOfSequence | //w:EndOfSequence")
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	return items, enumerationContext, endOfSequence, nil
+}
@@ -3,6 +3,7 @@ package winrm
 import (
 	"bytes"
 	"errors"
+	"strings"
 
 	. "gopkg.in/check.v1"
 )
@@ -17,6 +18,13 @@ func (s *WinRMSuite) TestOpenShellResponse(c *C) {
 	c.Assert("67A74734-DD32-4F10-89DE-49A060483810", Equals, shellID)
 }
 
+func (s *WinRMSuite) TestOpenShellResponseWithoutSelector(c *C) {
+	response := strings.Replace(createShellResponse, `<w:Selector Name="ShellId">67A74734-DD32-4F10-89DE-49A060483810</w:Selector>`, "", 1)
+	shellID, err := ParseOpenShellResponse(response)
+	c.Assert(err, IsNil)
+	c.Assert(shellID, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+}
+
 func (s *WinRMSuite) TestExecuteCommandResponse(c *C) {
 	response := executeCommandResponse
 
@@ -84,3 +92,34 @@ func (s *WinRMSuite) TestDoneSlurpOutputResponse(c *C) {
 	c.Assert("", Equals, stdout.String())
 	c.Assert("", Equals, stderr.String())
 }
+
+func (s *WinRMSuite) TestParseOutputChunksResponse(c *C) {
+	chunks, finished, _, err := ParseOutputChunksResponse(outputResponse)
+	c.Assert(err, IsNil)
+	c.Assert(finished, Equals, false)
+	c.Assert(chunks, DeepEquals, []OutputChunk{
+		{Stream: "stdout", Data: []byte("That's all folks!!!")},
+		{Stream: "stderr", Data: []byte("This is stderr, I'm pretty sure!")},
+	})
+
+	chunks, finished, code, err := ParseOutputChunksResponse(doneCommandResponse)
+	c.Assert(err, IsNil)
+	c.Assert(finished, Equals, true)
+	c.Assert(code, Equals, 123)
+	c.Assert(chunks, HasLen, 0)
+}
+
+func (s *WinRMSuite) TestParseEnumerateResponse(c *C) {
+	items, enumerationContext, end, err := ParseEnumerateResponse(enumerateResponse)
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 1)
+	c.Assert(items[0], Contains, ">WinRM</Name>")
+	c.Assert(enumerationContext, Equals, "uuid:5A8B7C10-0000-0000-0000-000000000001")
+	c.Assert(end, Equals, false)
+
+	items, enumerationContext, end, err = ParseEnumerateResponse(pullResponse)
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 1)
+	c.Assert(enumerationContext, Equals, "")
+	c.Assert(end, Equals, true)
+}
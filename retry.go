@@ -0,0 +1,113 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryMultiplier     = 2
+)
+
+// RetryPolicy configures how the SOAP requests failing with a transient error are sent again.
+// A request whose answer was lost is sent again too, so a command can then run twice.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent at most, the first one included.
+	// Requests aren't retried when it is below 2.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, 500ms by default
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts, 30s by default
+	MaxBackoff time.Duration
+	// Multiplier is the growth of the delay after each attempt, 2 by default
+	Multiplier float64
+	// Jitter randomizes the delays by up to this fraction of them (0.2 for ±20%),
+	// so that clients failing together don't retry together
+	Jitter float64
+	// RetryOn tells if a failed request should be sent again, IsTransient by default
+	RetryOn func(err error) bool
+}
+
+// IsTransient tells if err is worth retrying: a connection failure, reset or timeout,
+// a response cut short, a 502, 503 or 504 status, or a server quota fault.
+// Authentication, TLS verification and SOAP faults are not.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return isQuotaFault(err)
+	}
+
+	if errors.Is(err, ErrPartialResponse) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// backoff returns the delay before the attempt following the given one
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial, maximum, multiplier := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if initial <= 0 {
+		initial = defaultRetryInitialBackoff
+	}
+	if maximum <= 0 {
+		maximum = defaultRetryMaxBackoff
+	}
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	delay := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(maximum))
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// do calls send until it succeeds, fails with an error not to retry, or the attempts are exhausted
+func (p *RetryPolicy) do(ctx context.Context, send func() (string, error)) (string, error) {
+	retryOn := p.RetryOn
+	if retryOn == nil {
+		retryOn = IsTransient
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, err := send()
+		if err == nil || attempt >= p.MaxAttempts || !retryOn(err) {
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf("after %d attempts: %w", attempt, err)
+			}
+			return response, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package winrm
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestRetryPolicy(c *C) {
+	requests, failures := 0, 2
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	params := NewParametersBuilder().RetryPolicy(policy).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(requests, Equals, 3)
+
+	// the attempts are exhausted
+	requests, failures = 0, 5
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, "after 3 attempts: http error 503.*")
+	c.Assert(requests, Equals, 3)
+
+	// errors the predicate rejects aren't retried
+	requests = 0
+	client.RetryPolicy.RetryOn = func(error) bool { return false }
+	_, err = client.CreateShell()
+	c.Assert(err, ErrorMatches, "http error 503.*")
+	c.Assert(requests, Equals, 1)
+
+	// the backoff stops when the context is done
+	requests = 0
+	client.RetryPolicy.RetryOn, client.RetryPolicy.InitialBackoff = nil, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.CreateShellWithContext(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+	c.Assert(requests, Equals, 1)
+}
+
+func (s *WinRMSuite) TestRetryBackoff(c *C) {
+	policy := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	c.Assert(policy.backoff(1), Equals, 100*time.Millisecond)
+	c.Assert(policy.backoff(2), Equals, 200*time.Millisecond)
+	c.Assert(policy.backoff(4), Equals, 800*time.Millisecond)
+	c.Assert(policy.backoff(5), Equals, time.Second)
+
+	policy.Jitter = 0.2
+	for i := 0; i < 20; i++ {
+		delay := policy.backoff(2)
+		c.Assert(delay >= 160*time.Millisecond && delay <= 240*time.Millisecond, Equals, true)
+	}
+
+	c.Assert((&RetryPolicy{}).backoff(1), Equals, 500*time.Millisecond)
+}
+
+func (s *WinRMSuite) TestIsTransient(c *C) {
+	c.Assert(IsTransient(nil), Equals, false)
+	c.Assert(IsTransient(&HTTPError{StatusCode: http.StatusServiceUnavailable}), Equals, true)
+	c.Assert(IsTransient(&HTTPError{StatusCode: http.StatusUnauthorized}), Equals, false)
+	c.Assert(IsTransient(fmt.Errorf("unknown error %w", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")})), Equals, true)
+	c.Assert(IsTransient(fmt.Errorf("unknown error %w", io.EOF)), Equals, true)
+	c.Assert(IsTransient(fmt.Errorf("%w: connection closed after 12 bytes", ErrPartialResponse)), Equals, true)
+	c.Assert(IsTransient(context.DeadlineExceeded), Equals, false)
+	c.Assert(IsTransient(x509.UnknownAuthorityError{}), Equals, false)
+}
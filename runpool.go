@@ -0,0 +1,166 @@
+package winrm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultShellPoolIdleTimeout is how long an idle pooled shell is kept
+// when Parameters.ShellPoolIdleTimeout isn't set
+const defaultShellPoolIdleTimeout = time.Minute
+
+// ShellPoolStats reports how the shells used by the Run helpers were obtained
+type ShellPoolStats struct {
+	// Hits counts the commands run in a reused shell
+	Hits int64
+	// Misses counts the commands for which a shell had to be created
+	Misses int64
+	// Idle is the number of shells currently kept open
+	Idle int
+}
+
+// HitRate returns the proportion of commands run in a reused shell
+func (s ShellPoolStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// runPool keeps a bounded number of idle shells for the Run helpers when
+// Parameters.ShellPoolSize is set, closing the ones unused for longer than idleTimeout.
+// Unlike a ShellPool, it doesn't limit the shells in use: it only saves creating a shell
+// per command. Both check the shells idle for long with healthy before handing them out.
+type runPool struct {
+	client      *Client
+	size        int
+	idleTimeout time.Duration
+	// healthCheckAfter is the idle time after which a shell is checked before being reused
+	healthCheckAfter time.Duration
+
+	mutex  sync.Mutex
+	idle   []idleShell
+	hits   int64
+	misses int64
+}
+
+func newRunPool(client *Client, size int, idleTimeout time.Duration) *runPool {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultShellPoolIdleTimeout
+	}
+	return &runPool{
+		client:           client,
+		size:             size,
+		idleTimeout:      idleTimeout,
+		healthCheckAfter: defaultShellPoolHealthCheckAfter,
+	}
+}
+
+// get returns the most recently used idle shell that is alive, or creates a new one
+func (p *runPool) get(ctx context.Context) (*Shell, error) {
+	for {
+		p.mutex.Lock()
+		expired := p.expire()
+		var idle idleShell
+		if n := len(p.idle); n > 0 {
+			idle = p.idle[n-1]
+			p.idle = p.idle[:n-1]
+		}
+		p.mutex.Unlock()
+
+		for _, s := range expired {
+			_ = s.Close()
+		}
+		if idle.shell == nil {
+			break
+		}
+		if idle.healthy(ctx, p.healthCheckAfter) {
+			p.mutex.Lock()
+			p.hits++
+			p.mutex.Unlock()
+			return idle.shell, nil
+		}
+	}
+
+	p.mutex.Lock()
+	p.misses++
+	p.mutex.Unlock()
+
+	return p.client.CreateShellWithContext(ctx)
+}
+
+// put gives back a shell after a successful command, closing it if the pool is full
+func (p *runPool) put(shell *Shell) {
+	p.mutex.Lock()
+	if len(p.idle) < p.size {
+		p.idle = append(p.idle, idleShell{shell: shell, since: time.Now()})
+		shell = nil
+	}
+	p.mutex.Unlock()
+
+	if shell != nil {
+		_ = shell.Close()
+	}
+}
+
+// expire removes the shells idle for too long, which must then be closed.
+// The caller must hold the mutex.
+func (p *runPool) expire() []*Shell {
+	var expired []*Shell
+	deadline := time.Now().Add(-p.idleTimeout)
+	kept := p.idle[:0]
+	for _, idle := range p.idle {
+		if idle.since.Before(deadline) {
+			expired = append(expired, idle.shell)
+			continue
+		}
+		kept = append(kept, idle)
+	}
+	p.idle = kept
+	return expired
+}
+
+// close closes all the idle shells
+func (p *runPool) close() error {
+	p.mutex.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mutex.Unlock()
+
+	var err error
+	for _, i := range idle {
+		if closeErr := i.shell.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (p *runPool) stats() ShellPoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return ShellPoolStats{Hits: p.hits, Misses: p.misses, Idle: len(p.idle)}
+}
+
+// ShellPoolStats returns the statistics of the shell pool used by the Run helpers,
+// which are all zero when Parameters.ShellPoolSize isn't set
+func (c *Client) ShellPoolStats() ShellPoolStats {
+	if c.pool == nil {
+		return ShellPoolStats{}
+	}
+	return c.pool.stats()
+}
+
+// Close releases the resources held by the client, like the shells kept
+// by the shell pool and the idle connections of the transport.
+// The client can still be used afterwards.
+func (c *Client) Close() error {
+	var err error
+	if c.pool != nil {
+		err = c.pool.close()
+	}
+	closeIdleConnections(c.http)
+
+	return err
+}
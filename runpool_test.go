@@ -0,0 +1,129 @@
+package winrm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestShellPoolReusesShells(c *C) {
+	var created, deleted int
+	params := NewParametersBuilder().ShellPool(2, 0).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+	client.http = poolRequester(&created, &deleted)
+
+	for i := 0; i < 3; i++ {
+		stdout, _, code, err := client.RunCmdWithContext(context.Background(), "hostname")
+		c.Assert(err, IsNil)
+		c.Assert(code, Equals, 0)
+		c.Assert(stdout, Equals, "ok")
+	}
+
+	c.Assert(created, Equals, 1)
+	c.Assert(deleted, Equals, 0)
+	stats := client.ShellPoolStats()
+	c.Assert(stats, Equals, ShellPoolStats{Hits: 2, Misses: 1, Idle: 1})
+	c.Assert(stats.HitRate() > 0.66, Equals, true)
+
+	c.Assert(client.Close(), IsNil)
+	c.Assert(deleted, Equals, 1)
+	c.Assert(client.ShellPoolStats().Idle, Equals, 0)
+}
+
+func (s *WinRMSuite) TestShellPoolExpiresIdleShells(c *C) {
+	var created, deleted int
+	params := NewParametersBuilder().ShellPool(2, 10*time.Millisecond).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+	client.http = poolRequester(&created, &deleted)
+
+	_, _, _, err = client.RunCmdWithContext(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+	time.Sleep(20 * time.Millisecond)
+	_, _, _, err = client.RunCmdWithContext(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+
+	c.Assert(created, Equals, 2)
+	c.Assert(deleted, Equals, 1)
+	c.Assert(client.ShellPoolStats(), Equals, ShellPoolStats{Hits: 0, Misses: 2, Idle: 1})
+}
+
+func (s *WinRMSuite) TestShellPoolReplacesDeletedShells(c *C) {
+	var created, deleted, commands int
+	params := NewParametersBuilder().ShellPool(2, 0).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+	r := poolRequester(&created, &deleted)
+	run := r.http
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), ActionCommand) {
+			commands++
+			// the server deleted the shell after the first command
+			if commands == 2 {
+				return "", &HTTPError{StatusCode: 500, Body: `<f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="2150858843"/>`}
+			}
+		}
+		return run(client, message)
+	}
+	client.http = r
+
+	for i := 0; i < 2; i++ {
+		stdout, _, code, err := client.RunCmdWithContext(context.Background(), "hostname")
+		c.Assert(err, IsNil)
+		c.Assert(code, Equals, 0)
+		c.Assert(stdout, Equals, "ok")
+	}
+	c.Assert(commands, Equals, 3)
+	c.Assert(created, Equals, 2)
+	c.Assert(client.DebugSnapshot().OpenShells, Equals, int64(1))
+	c.Assert(client.ShellPoolStats().Idle, Equals, 1)
+}
+
+func (s *WinRMSuite) TestShellPoolDisabled(c *C) {
+	var created, deleted int
+	client, err := NewClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b")
+	c.Assert(err, IsNil)
+	client.http = poolRequester(&created, &deleted)
+
+	_, _, _, err = client.RunCmdWithContext(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 1)
+	c.Assert(deleted, Equals, 1)
+	c.Assert(client.ShellPoolStats(), Equals, ShellPoolStats{})
+}
+
+func (s *WinRMSuite) TestShellPoolChecksIdleShells(c *C) {
+	var created, deleted, pings int
+	params := NewParametersBuilder().ShellPool(2, 0).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b", params)
+	c.Assert(err, IsNil)
+	client.pool.healthCheckAfter = 10 * time.Millisecond
+	r := poolRequester(&created, &deleted)
+	run := r.http
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), ActionGet) {
+			pings++
+			return "", &HTTPError{StatusCode: 500, Body: `<f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="2150858843"/>`}
+		}
+		return run(client, message)
+	}
+	client.http = r
+
+	_, _, _, err = client.RunCmdWithContext(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+	_, _, _, err = client.RunCmdWithContext(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+	c.Assert(pings, Equals, 0)
+
+	// the shell idle for long is checked, and replaced as the server deleted it
+	time.Sleep(20 * time.Millisecond)
+	_, _, _, err = client.RunCmdWithContext(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+	c.Assert(pings, Equals, 1)
+	c.Assert(created, Equals, 2)
+	c.Assert(client.ShellPoolStats(), Equals, ShellPoolStats{Hits: 1, Misses: 2, Idle: 1})
+}
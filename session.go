@@ -0,0 +1,175 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// SessionOption configures a Session created by Client.NewSession
+type SessionOption func(*Session)
+
+// WithPersistentState makes the PowerShell scripts run by a Session share their state:
+// the variables, environment variables and current location left by a script are
+// saved in a state file of the remote temporary directory, and dot-sourced
+// before the next script. Each script still runs in its own powershell.exe process,
+// so this only approximates an interactive session: objects are restored through
+// CLIXML serialization, and functions, modules and aliases are not carried over.
+// The state handling takes about 3400 of the 8191 characters of a cmd.exe command line,
+// leaving about 1800 characters to each script once encoded, see Session.RunPS.
+func WithPersistentState() SessionOption {
+	return func(s *Session) {
+		s.stateFile = "winrm-session-" + uuid.Must(uuid.NewV4()).String() + ".ps1"
+	}
+}
+
+// WithShellOptions creates the session shell with options, e.g. to set environment variables
+// for all the session commands
+func WithShellOptions(options ShellOptions) SessionOption {
+	return func(s *Session) {
+		s.shellOptions = options
+	}
+}
+
+// Session runs several commands in the same remote shell, which is kept open until Close
+type Session struct {
+	client       *Client
+	shell        *Shell
+	stateFile    string
+	shellOptions ShellOptions
+}
+
+// NewSession opens a shell on the remote host and returns a Session running commands in it
+func (c *Client) NewSession(ctx context.Context, options ...SessionOption) (*Session, error) {
+	session := &Session{client: c}
+	for _, option := range options {
+		option(session)
+	}
+
+	shell, err := c.CreateShellWithOptions(ctx, session.shellOptions)
+	if err != nil {
+		return nil, err
+	}
+	session.shell = shell
+
+	return session, nil
+}
+
+// Shell returns the remote shell of the session
+func (s *Session) Shell() *Shell {
+	return s.shell
+}
+
+// Run runs a cmd.exe command line in the session shell and waits for its termination
+func (s *Session) Run(ctx context.Context, command string) (*CommandResult, error) {
+	var outWriter, errWriter bytes.Buffer
+	cmd, err := s.run(ctx, command, &outWriter, &errWriter, nil)
+	if cmd == nil {
+		return nil, err
+	}
+
+	return newCommandResult(cmd, &outWriter, &errWriter), err
+}
+
+// RunWithInput runs a cmd.exe command line in the session shell, writing its output to stdout
+// and stderr and feeding it stdin, which can be nil, then waits for its termination
+func (s *Session) RunWithInput(ctx context.Context, command string, stdout, stderr io.Writer, stdin io.Reader) (int, error) {
+	cmd, err := s.run(ctx, command, stdout, stderr, stdin)
+	if cmd == nil {
+		return 1, err
+	}
+
+	return cmd.ExitCode(), err
+}
+
+// run runs command in the session shell. With Parameters.AutoReconnect, a shell the server
+// reaped since the previous command is created again and the command sent once more,
+// which is safe since it wasn't started.
+func (s *Session) run(ctx context.Context, command string, stdout, stderr io.Writer, stdin io.Reader) (*Command, error) {
+	cmd, err := s.shell.run(ctx, command, nil, stdout, stderr, stdin)
+	if cmd != nil || !s.client.AutoReconnect || !isShellNotFound(err) {
+		return cmd, err
+	}
+
+	// the reaped shell can't be closed anymore
+	s.shell.forget()
+	shell, err := s.client.CreateShellWithOptions(ctx, s.shellOptions)
+	if err != nil {
+		return nil, fmt.Errorf("creating the reaped session shell again: %w", err)
+	}
+	s.shell = shell
+
+	return s.shell.run(ctx, command, nil, stdout, stderr, stdin)
+}
+
+// RunPS runs a PowerShell script in the session shell and waits for its termination.
+// With WithPersistentState, the script starts from the state left by the previous one.
+// The script is passed encoded on the powershell.exe command line, which cmd.exe limits
+// to 8191 characters: a longer one fails without being sent, it has to be split, or
+// copied to a remote file and run from there.
+func (s *Session) RunPS(ctx context.Context, script string) (*CommandResult, error) {
+	if s.stateFile != "" {
+		script = persistentStateScript(s.stateFile, script)
+	}
+
+	command := Powershell(script)
+	if command == "" {
+		return nil, ErrCommandEncoding
+	}
+	if len(command) > maxCommandLine {
+		return nil, fmt.Errorf("%w: the encoded script takes %d characters, over the %d of a cmd.exe command line",
+			ErrCommandEncoding, len(command), maxCommandLine)
+	}
+
+	return s.Run(ctx, command)
+}
+
+// Close removes the session state file if any, and closes the remote shell
+func (s *Session) Close() error {
+	var err error
+	if s.stateFile != "" {
+		cleanup := Powershell("Remove-Item -LiteralPath (Join-Path $env:TEMP " + psQuote(s.stateFile) +
+			") -ErrorAction SilentlyContinue")
+		_, err = s.shell.run(context.Background(), cleanup, nil, io.Discard, io.Discard, nil)
+	}
+
+	if closeErr := s.shell.Close(); closeErr != nil {
+		return closeErr
+	}
+
+	return err
+}
+
+// maxCommandLine is the length limit of a cmd.exe command line
+const maxCommandLine = 8191
+
+// persistentStateTemplate wraps a script between the restoration and the saving of the
+// session state. Variables existing before the restoration are PowerShell's own,
+// and are not saved; the automatic ones created later are excluded by name.
+// Saving happens in a finally block so it also runs when the script calls exit.
+const persistentStateTemplate = `$__winrmState = Join-Path $env:TEMP {{state}}
+$__winrmBaseline = @(Get-Variable | ForEach-Object Name) + @('_', 'PSItem', 'args', 'input', 'this', 'foreach', 'switch', 'Matches', 'LASTEXITCODE', 'Error', 'StackTrace', 'MyInvocation', 'PSBoundParameters', 'PSCmdlet')
+if (Test-Path -LiteralPath $__winrmState) { . $__winrmState }
+try {
+{{script}}
+} finally {
+$__winrmLines = @("Set-Location -LiteralPath '" + (Get-Location).Path.Replace("'", "''") + "'")
+foreach ($__winrmVar in Get-Variable) {
+if ($__winrmBaseline -contains $__winrmVar.Name -or $__winrmVar.Name -like '__winrm*') { continue }
+$__winrmXml = [System.Management.Automation.PSSerializer]::Serialize($__winrmVar.Value).Replace("'", "''")
+$__winrmLines += "Set-Variable -Name '" + $__winrmVar.Name.Replace("'", "''") + "' -Value ([System.Management.Automation.PSSerializer]::Deserialize('" + $__winrmXml + "')) -ErrorAction SilentlyContinue"
+}
+foreach ($__winrmEnv in Get-ChildItem env:) {
+$__winrmLines += "Set-Item -LiteralPath 'env:" + $__winrmEnv.Name.Replace("'", "''") + "' -Value '" + $__winrmEnv.Value.Replace("'", "''") + "'"
+}
+Set-Content -LiteralPath $__winrmState -Value $__winrmLines -Encoding UTF8
+}`
+
+// persistentStateScript fills persistentStateTemplate with the state file name and script
+func persistentStateScript(stateFile, script string) string {
+	return strings.NewReplacer("{{state}}", psQuote(stateFile), "{{script}}", script).Replace(persistentStateTemplate)
+}
@@ -0,0 +1,121 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/satendraraj/winrm/soap"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestSession(c *C) {
+	var creates, deletes, commands int
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		b, _ := io.ReadAll(r.Body)
+		body := string(b)
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			creates++
+			c.Check(body, Contains, `<rsp:Variable Name="STAGE"><![CDATA[deploy]]></rsp:Variable>`)
+			fmt.Fprintln(w, createShellResponse)
+		case strings.Contains(body, "transfer/Delete"):
+			deletes++
+			fmt.Fprintln(w, response)
+		case strings.Contains(body, "shell/Command<"):
+			commands++
+			fmt.Fprintln(w, executeCommandResponse)
+		case strings.Contains(body, "shell/Receive"):
+			fmt.Fprintln(w, doneCommandResponse)
+		default:
+			fmt.Fprintln(w, response)
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	client, err := NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test")
+	c.Assert(err, IsNil)
+
+	session, err := client.NewSession(context.Background(), WithPersistentState(),
+		WithShellOptions(ShellOptions{Env: map[string]string{"STAGE": "deploy"}}))
+	c.Assert(err, IsNil)
+	c.Assert(session.Shell().id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+
+	result, err := session.Run(context.Background(), "ipconfig")
+	c.Assert(err, IsNil)
+	c.Assert(result.ExitCode, Equals, 123)
+	_, err = session.RunPS(context.Background(), "$a = 1")
+	c.Assert(err, IsNil)
+	// a script over the cmd.exe command line limit isn't sent
+	_, err = session.RunPS(context.Background(), "$a = '"+strings.Repeat("x", 2000)+"'")
+	c.Assert(errors.Is(err, ErrCommandEncoding), Equals, true)
+	exitCode, err := session.RunWithInput(context.Background(), "sort", io.Discard, io.Discard, strings.NewReader("b\na\n"))
+	c.Assert(err, IsNil)
+	c.Assert(exitCode, Equals, 123)
+
+	c.Assert(session.Close(), IsNil)
+	c.Assert(creates, Equals, 1)
+	c.Assert(deletes, Equals, 1)
+	// the state file is removed before closing the shell
+	c.Assert(commands, Equals, 4)
+}
+
+func (s *WinRMSuite) TestPersistentStateScript(c *C) {
+	script := persistentStateScript("winrm-session-1.ps1", "$a = '{{state}}'")
+	c.Assert(script, Matches, `(?s)\$__winrmState = Join-Path \$env:TEMP 'winrm-session-1.ps1'\n.*`)
+	c.Assert(strings.Contains(script, "try {\n$a = '{{state}}'\n} finally {"), Equals, true)
+	c.Assert(strings.Contains(script, "{ . $__winrmState }"), Equals, true)
+}
+
+func (s *WinRMSuite) TestSessionAutoReconnect(c *C) {
+	params := NewParametersBuilder().AutoReconnect(true).Build()
+	client, err := NewClientWithParameters(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+
+	var creates int
+	r := &Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			creates++
+			if creates == 1 {
+				return createShellResponse, nil
+			}
+			return strings.Replace(createShellResponse, "67A74734-DD32-4F10-89DE-49A060483810", "B2D9A1C3-5E6F-4A7B-8C9D-0E1F2A3B4C5D", 1), nil
+		case strings.Contains(body, ActionCommand):
+			if strings.Contains(body, "67A74734-DD32-4F10-89DE-49A060483810") {
+				// the server rebooted since the session was opened
+				return "", &HTTPError{StatusCode: http.StatusInternalServerError, Body: shellNotFoundFault}
+			}
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return doneOutputResponse("rebooted", 0), nil
+		}
+		return "", nil
+	}
+	client.http = r
+
+	session, err := client.NewSession(context.Background())
+	c.Assert(err, IsNil)
+	result, err := session.Run(context.Background(), "systeminfo")
+	c.Assert(err, IsNil)
+	c.Assert(result.Stdout, Equals, "rebooted")
+	c.Assert(creates, Equals, 2)
+	c.Assert(session.Shell().ID(), Equals, "B2D9A1C3-5E6F-4A7B-8C9D-0E1F2A3B4C5D")
+	// the reaped shell isn't counted as open anymore
+	c.Assert(client.DebugSnapshot().OpenShells, Equals, int64(1))
+
+	// without AutoReconnect the fault is returned
+	client.AutoReconnect = false
+	creates = 0
+	session, err = client.NewSession(context.Background())
+	c.Assert(err, IsNil)
+	_, err = session.Run(context.Background(), "systeminfo")
+	c.Assert(err, NotNil)
+	c.Assert(creates, Equals, 1)
+}
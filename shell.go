@@ -1,11 +1,118 @@
 package winrm
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
 
-// Shell is the local view of a WinRM Shell of a given Client
+// Shell is the local view of a WinRM Shell of a given Client.
+// Several commands can run in a shell at the same time, each one receiving its own output:
+// its methods are safe for concurrent use. With the Encryption transport, the requests of
+// the commands are sent one at a time.
 type Shell struct {
 	client *Client
 	id     string
+	// counted is 1 while the shell is in the client open shells count
+	counted int32
+	// creation is the time taken by the server to create the shell,
+	// creationTaken is set to 1 once reported in the timing of a command
+	creation      time.Duration
+	creationTaken int32
+}
+
+// ExecuteOptions holds the per-command settings of ExecuteWithOptions
+type ExecuteOptions struct {
+	// Args are appended to the command line
+	Args []string
+	// Env sets environment variables for this command only, through cmd.exe.
+	// The values are taken literally, %VAR% isn't expanded.
+	Env map[string]string
+	// Dir is the working directory of the command, through cmd.exe
+	Dir string
+	// Timeout terminates the command if it's still running after this duration,
+	// its error being a *CommandTimeoutError
+	Timeout time.Duration
+	// ConsoleModeStdin sets WINRS_CONSOLEMODE_STDIN, which Execute always enables
+	ConsoleModeStdin bool
+	// SkipCmdShell runs the command without cmd.exe, Env and Dir can't be used then
+	SkipCmdShell bool
+	// OnOutput is called with each stdout and stderr chunk as it is received, in the order the
+	// server sent them, seq counting the chunks from 0, before it is written to Stdout or Stderr
+	// which must still be read. The chunks are decoded like Stdout and Stderr (OutputEncoding,
+	// StripANSI, NormalizeNewlines), and the calls stop at MaxOutput. They are made one at a
+	// time from the receive loop.
+	OnOutput func(stream string, seq int, chunk []byte)
+	// MaxOutput overrides Parameters.MaxOutput for this command
+	MaxOutput int
+	// raw passes the output as is, ignoring OutputEncoding, StripANSI, NormalizeNewlines,
+	// OutputLimit and MaxOutput, for the payloads the library downloads itself
+	raw bool
+}
+
+// CodepageUTF8 is the console codepage of the shells created by default
+const CodepageUTF8 = 65001
+
+// ShellOptions holds the settings of the shells created with CreateShellWithOptions
+type ShellOptions struct {
+	// Env sets environment variables for all the commands of the shell
+	Env map[string]string
+	// IdleTimeOut is the time the server keeps the shell without any request before deleting it,
+	// the server MaxIdleTimeoutms setting when zero. A long one keeps a shell alive between
+	// sparse commands, a short one lets the server clean up the shells of a crashed client.
+	IdleTimeOut time.Duration
+	// Lifetime is the time after which the server deletes the shell whatever its activity,
+	// no limit when zero
+	Lifetime time.Duration
+	// Codepage is the console codepage of the shell, in which the commands output is encoded.
+	// It is UTF-8 (65001) when zero, so that the output of localized systems isn't mangled;
+	// the OEM codepage of the server (437, 850, 932...) can be requested for old tools.
+	Codepage int
+	// NoProfile sets WINRS_NOPROFILE, the shell starting faster without loading the user profile.
+	// The commands then don't get the user environment variables and registry hive.
+	NoProfile bool
+}
+
+// commandLine prefixes command with the cmd.exe statements applying options.Env and options.Dir.
+// Their values are escaped so that cmd.exe takes them literally, whatever quotes, & or %VAR% they hold.
+func (o *ExecuteOptions) commandLine(command string) (string, error) {
+	if len(o.Env) == 0 && o.Dir == "" {
+		return command, nil
+	}
+	if o.SkipCmdShell {
+		return "", errors.New("Env and Dir need cmd.exe and can't be used with SkipCmdShell")
+	}
+
+	var prefix strings.Builder
+	if o.Dir != "" {
+		if strings.ContainsAny(o.Dir, "\"\r\n\x00") {
+			return "", fmt.Errorf("invalid working directory %q", o.Dir)
+		}
+		fmt.Fprintf(&prefix, `cd /d %s && `, cmdEscape(`"`+o.Dir+`"`))
+	}
+	names := make([]string, 0, len(o.Env))
+	for name := range o.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, "=\"\r\n\x00") {
+			return "", fmt.Errorf("invalid environment variable name %q", name)
+		}
+		value := o.Env[name]
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return "", fmt.Errorf("environment variable %s can't contain line breaks or NUL characters", name)
+		}
+		// set "NAME=value" keeps everything up to the last quote, quotes in value included
+		fmt.Fprintf(&prefix, `set %s && `, cmdEscape(`"`+name+"="+value+`"`))
+	}
+
+	return prefix.String() + command, nil
 }
 
 // Execute command on the given Shell, returning either an error or a Command
@@ -17,29 +124,200 @@ func (s *Shell) Execute(command string, arguments ...string) (*Command, error) {
 
 // ExecuteWithContext command on the given Shell, returning either an error or a Command
 func (s *Shell) ExecuteWithContext(ctx context.Context, command string, arguments ...string) (*Command, error) {
-	request := NewExecuteCommandRequest(s.client.url, s.id, command, arguments, &s.client.Parameters)
+	request := NewExecuteCommandRequest(s.client.url, s.id, command, arguments, s.client.requestParameters())
+	defer request.Free()
+
+	response, err := s.client.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	commandID, err := ParseExecuteCommandResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := newCommand(ctx, s, commandID, nil)
+	cmd.commandLine = strings.Join(append([]string{command}, arguments...), " ")
+
+	return cmd, nil
+}
+
+// ExecuteWithArgs runs the executable exe with args, each one quoted so that the process
+// gets it back as is in its argv, whatever spaces, quotes or cmd.exe special characters it holds.
+// The process is started directly, without cmd.exe (WINRS_SKIP_CMD_SHELL), so exe can't be
+// one of its builtins like dir or echo; exe is looked up in the PATH when it isn't a path.
+func (s *Shell) ExecuteWithArgs(ctx context.Context, exe string, args ...string) (*Command, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = argvQuote(arg)
+	}
+
+	return s.ExecuteWithOptions(ctx, argvQuote(exe), ExecuteOptions{
+		Args:             quoted,
+		ConsoleModeStdin: true,
+		SkipCmdShell:     true,
+	})
+}
+
+// ExecuteWithOptions runs command on the given Shell with per-command settings,
+// returning either an error or a Command
+func (s *Shell) ExecuteWithOptions(ctx context.Context, command string, options ExecuteOptions) (*Command, error) {
+	command, err := options.commandLine(command)
+	if err != nil {
+		return nil, err
+	}
+
+	cancel := func() {}
+	if options.Timeout > 0 {
+		ctx, cancel = withCommandTimeout(ctx, options.Timeout)
+	}
+
+	request := NewExecuteCommandRequestWithOptions(s.client.url, s.id, command, &options, s.client.requestParameters())
 	defer request.Free()
 
-	response, err := s.client.sendRequest(request)
+	response, err := s.client.sendRequestWithContext(ctx, request)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
 	commandID, err := ParseExecuteCommandResponse(response)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	cmd := newCommand(ctx, s, commandID)
+	cmd := newCommand(ctx, s, commandID, &options)
+	cmd.commandLine = strings.Join(append([]string{command}, options.Args...), " ")
+	go func() {
+		<-cmd.done
+		cancel()
+	}()
 
 	return cmd, nil
 }
 
+// AttachCommand resumes receiving the output of the command id, found by Command.ID, still
+// running in the shell, like a long install started before a restart of the process.
+// The output already received before isn't sent again.
+func (s *Shell) AttachCommand(ctx context.Context, id string) (*Command, error) {
+	if id == "" {
+		return nil, errors.New("attaching a command needs its id")
+	}
+	return newCommand(ctx, s, id, nil), nil
+}
+
+// Disconnect detaches the client from the shell, whose commands go on running, their output
+// being buffered by the server until a client reconnects with Reconnect or Client.ConnectShell.
+// The server keeps the disconnected shell idleTimeout, or its IdleTimeOut when zero.
+// Only the PowerShell (PSRP) shells, created with a Parameters.ResourceURI like
+// ResourceURIPowerShell, can be disconnected: ErrDisconnectUnsupported is returned for the
+// cmd shells. It needs WinRM 3.0, a *ProtocolVersionError being returned otherwise.
+func (s *Shell) Disconnect(ctx context.Context, idleTimeout time.Duration) error {
+	if err := s.client.requireDisconnect(ctx); err != nil {
+		return err
+	}
+
+	request := NewDisconnectRequest(s.client.url, s.id, idleTimeout, s.client.requestParameters())
+	defer request.Free()
+
+	_, err := s.client.sendRequestWithContext(ctx, request)
+	return err
+}
+
+// Reconnect reattaches the client to the shell it disconnected from with Disconnect,
+// the output of its commands being then received with Shell.AttachCommand.
+// Like Disconnect, it only supports the PowerShell (PSRP) shells.
+func (s *Shell) Reconnect(ctx context.Context) error {
+	if err := s.client.requireDisconnect(ctx); err != nil {
+		return err
+	}
+
+	request := NewReconnectRequest(s.client.url, s.id, s.client.requestParameters())
+	defer request.Free()
+
+	_, err := s.client.sendRequestWithContext(ctx, request)
+	return err
+}
+
+// requireDisconnect checks the shells of the client can be disconnected: the server runs
+// WinRM 3.0, and their resource URI isn't the one of the cmd shell, which WinRS can't disconnect
+func (c *Client) requireDisconnect(ctx context.Context) error {
+	if shellResourceURI(&c.Parameters) == ResourceURICmdShell {
+		return ErrDisconnectUnsupported
+	}
+	return c.RequireVersionWithContext(ctx, "3.0")
+}
+
+// ID returns the identifier of the shell on the server, as found in its WinRM operational logs
+func (s *Shell) ID() string {
+	return s.id
+}
+
 // Close will terminate this shell. No commands can be issued once the shell is closed.
 func (s *Shell) Close() error {
-	request := NewDeleteShellRequest(s.client.url, s.id, &s.client.Parameters)
+	return s.CloseWithContext(context.Background())
+}
+
+// CloseWithContext will terminate this shell. No commands can be issued once the shell is closed.
+func (s *Shell) CloseWithContext(ctx context.Context) error {
+	request := NewDeleteShellRequest(s.client.url, s.id, s.client.requestParameters())
 	defer request.Free()
 
-	_, err := s.client.sendRequest(request)
+	_, err := s.client.sendRequestWithContext(ctx, request)
+	if err == nil {
+		s.forget()
+	}
 	return err
 }
+
+// forget removes a shell the server deleted from the client open shells count,
+// closing it being impossible
+func (s *Shell) forget() {
+	if atomic.CompareAndSwapInt32(&s.counted, 1, 0) {
+		atomic.AddInt64(&s.client.stats.openShells, -1)
+	}
+}
+
+// takeCreation returns the creation time of the shell the first time only,
+// zero afterwards, so that it is reported by a single command
+func (s *Shell) takeCreation() time.Duration {
+	if !atomic.CompareAndSwapInt32(&s.creationTaken, 0, 1) {
+		return 0
+	}
+	return s.creation
+}
+
+// ping checks the shell still exists on the server
+func (s *Shell) ping(ctx context.Context) error {
+	request := NewGetShellRequest(s.client.url, s.id, s.client.requestParameters())
+	defer request.Free()
+
+	_, err := s.client.sendRequestWithContext(ctx, request)
+	return err
+}
+
+// run executes command on the shell, with options unless nil, copying its output to the
+// given writers and stdin to its input, then waits for its termination.
+// It returns the finished Command, or nil if it couldn't be started.
+func (s *Shell) run(ctx context.Context, command string, options *ExecuteOptions, stdout, stderr io.Writer, stdin io.Reader) (*Command, error) {
+	if _, ok := ctx.Deadline(); !ok && s.client.CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withCommandTimeout(ctx, s.client.CommandTimeout)
+		defer cancel()
+	}
+
+	var cmd *Command
+	var err error
+	if options != nil {
+		cmd, err = s.ExecuteWithOptions(ctx, command, *options)
+	} else {
+		cmd, err = s.ExecuteWithContext(ctx, command)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cmd, cmd.pipe(stdout, stderr, stdin)
+}
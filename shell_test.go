@@ -1,7 +1,16 @@
 package winrm
 
 import (
-	"github.com/masterzen/winrm/soap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
 	. "gopkg.in/check.v1"
 )
 
@@ -42,3 +51,336 @@ func (s *WinRMSuite) TestShellCloseResponse(c *C) {
 
 	shell.Close()
 }
+
+func (s *WinRMSuite) TestShellExecuteWithOptions(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), "/windows/shell/Command") {
+			c.Assert(message.String(), Contains, `<![CDATA[cd /d ^"C:\Temp^" && set ^"A=1^" && set ^"B=x y^" && dir]]>`)
+			c.Assert(message.String(), Contains, "<![CDATA[/s]]>")
+			return executeCommandResponse, nil
+		}
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	command, err := shell.ExecuteWithOptions(context.Background(), "dir", ExecuteOptions{
+		Args: []string{"/s"},
+		Env:  map[string]string{"B": "x y", "A": "1"},
+		Dir:  `C:\Temp`,
+	})
+	c.Assert(err, IsNil)
+	command.Wait()
+	c.Assert(command.ExitCode(), Equals, 123)
+
+	_, err = shell.ExecuteWithOptions(context.Background(), "dir", ExecuteOptions{Dir: `C:\Temp`, SkipCmdShell: true})
+	c.Assert(err, ErrorMatches, ".*SkipCmdShell.*")
+}
+
+func (s *WinRMSuite) TestExecuteOptionsCommandLineEscaping(c *C) {
+	options := ExecuteOptions{
+		Env: map[string]string{"NAME": `x" & calc & "%PATH%`},
+		Dir: `C:\a & b`,
+	}
+	command, err := options.commandLine("dir")
+	c.Assert(err, IsNil)
+	c.Assert(command, Equals, `cd /d ^"C:\a ^& b^" && set ^"NAME=x^" ^& calc ^& ^"^%PATH^%^" && dir`)
+
+	_, err = (&ExecuteOptions{Env: map[string]string{`A" & calc & "`: "1"}}).commandLine("dir")
+	c.Assert(err, ErrorMatches, "invalid environment variable name .*")
+	_, err = (&ExecuteOptions{Env: map[string]string{"A=B": "1"}}).commandLine("dir")
+	c.Assert(err, ErrorMatches, "invalid environment variable name .*")
+	_, err = (&ExecuteOptions{Env: map[string]string{"A": "1\r\ncalc"}}).commandLine("dir")
+	c.Assert(err, ErrorMatches, ".*line breaks.*")
+	_, err = (&ExecuteOptions{Dir: `C:\" & calc & "`}).commandLine("dir")
+	c.Assert(err, ErrorMatches, "invalid working directory .*")
+}
+
+func (s *WinRMSuite) TestShellExecuteWithArgs(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), "/windows/shell/Command") {
+			c.Assert(message.String(), Contains, `<![CDATA["C:\Program Files\tool.exe"]]>`)
+			c.Assert(message.String(), Contains, `<![CDATA[--name]]>`)
+			c.Assert(message.String(), Contains, `<![CDATA["a \"b\" & c"]]>`)
+			c.Assert(message.String(), Contains, `<![CDATA["C:\dir with space\\"]]>`)
+			c.Assert(message.String(), Contains, `<![CDATA[""]]>`)
+			c.Assert(message.String(), Matches, `(?s).*<w:Option Name="WINRS_SKIP_CMD_SHELL">TRUE</w:Option>.*`)
+			return executeCommandResponse, nil
+		}
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	command, err := shell.ExecuteWithArgs(context.Background(), `C:\Program Files\tool.exe`,
+		"--name", `a "b" & c`, `C:\dir with space\`, "")
+	c.Assert(err, IsNil)
+	command.Wait()
+	c.Assert(command.ExitCode(), Equals, 123)
+}
+
+func (s *WinRMSuite) TestShellExecuteWithOptionsTimeout(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch {
+		case strings.Contains(message.String(), "/windows/shell/Command"):
+			return executeCommandResponse, nil
+		case strings.Contains(message.String(), "/windows/shell/Receive"):
+			time.Sleep(10 * time.Millisecond)
+			return "", errors.New("OperationTimeout")
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	command, err := shell.ExecuteWithOptions(context.Background(), "ping -t localhost", ExecuteOptions{Timeout: 50 * time.Millisecond})
+	c.Assert(err, IsNil)
+	command.Wait()
+	c.Assert(errors.Is(command.err, ErrCommandTimeout), Equals, true)
+	c.Assert(errors.Is(command.err, context.DeadlineExceeded), Equals, true)
+}
+
+func (s *WinRMSuite) TestRequestAndCommandTimeouts(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		b, _ := io.ReadAll(r.Body)
+		body := string(b)
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			fmt.Fprintln(w, createShellResponse)
+		case strings.Contains(body, "shell/Command<"):
+			fmt.Fprintln(w, executeCommandResponse)
+		case strings.Contains(body, "shell/Receive"):
+			// a hung server
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		default:
+			fmt.Fprintln(w, response)
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+
+	params := NewParametersBuilder().CommandTimeout(100 * time.Millisecond).Build()
+	client, err := NewClientWithParameters(endpoint, "test", "test", params)
+	c.Assert(err, IsNil)
+	start := time.Now()
+	_, err = client.Exec(context.Background(), "ipconfig")
+	c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+	c.Assert(time.Since(start) < 2*time.Second, Equals, true)
+
+	params = NewParametersBuilder().RequestTimeout(100 * time.Millisecond).Build()
+	client, err = NewClientWithParameters(endpoint, "test", "test", params)
+	c.Assert(err, IsNil)
+	shell, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	cmd, err := shell.ExecuteWithContext(context.Background(), "ipconfig")
+	c.Assert(err, IsNil)
+	start = time.Now()
+	cmd.Wait()
+	c.Assert(errors.Is(cmd.err, context.DeadlineExceeded), Equals, true)
+	c.Assert(time.Since(start) < 2*time.Second, Equals, true)
+}
+
+func (s *WinRMSuite) TestRunWithTimeout(c *C) {
+	receives := 0
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body := string(b)
+		w.Header().Set("Content-Type", "application/soap+xml")
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			fmt.Fprintln(w, createShellResponse)
+		case strings.Contains(body, "shell/Command<"):
+			fmt.Fprintln(w, executeCommandResponse)
+		case strings.Contains(body, "shell/Receive"):
+			if receives++; receives == 1 {
+				fmt.Fprintln(w, outputResponse)
+				return
+			}
+			// the command doesn't output anything more
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		default:
+			fmt.Fprintln(w, response)
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	client, err := NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test")
+	c.Assert(err, IsNil)
+	stdout, stderr, _, err := client.RunWithTimeout(context.Background(), "ping -t localhost", 200*time.Millisecond)
+	c.Assert(errors.Is(err, ErrCommandTimeout), Equals, true)
+	var timeoutErr *CommandTimeoutError
+	c.Assert(errors.As(err, &timeoutErr), Equals, true)
+	c.Assert(timeoutErr.Timeout, Equals, 200*time.Millisecond)
+	c.Assert(timeoutErr.Stdout, Equals, "That's all folks!!!")
+	c.Assert(timeoutErr.Stderr, Equals, "This is stderr, I'm pretty sure!")
+	c.Assert(stdout, Equals, timeoutErr.Stdout)
+	c.Assert(stderr, Equals, timeoutErr.Stderr)
+	c.Assert(err, ErrorMatches, "command timed out after 200ms")
+
+	// the PowerShell helper keeps the partial output as well
+	receives = 0
+	client.CommandTimeout = 200 * time.Millisecond
+	_, _, _, err = client.RunPSWithContext(context.Background(), "Test-Connection localhost -Continuous")
+	c.Assert(errors.As(err, &timeoutErr), Equals, true)
+	c.Assert(timeoutErr.Stdout, Equals, "That's all folks!!!")
+}
+
+func (s *WinRMSuite) TestAttachShellAndCommand(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	const shellID = "67A74734-DD32-4F10-89DE-49A060483810"
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionGet):
+			if !strings.Contains(body, shellID) {
+				return "", &HTTPError{StatusCode: 500, Body: "shell not found"}
+			}
+			return "", nil
+		case strings.Contains(body, ActionReceive):
+			c.Check(body, Contains, `CommandId="1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4"`)
+			return doneOutputResponse("installed", 0), nil
+		}
+		c.Errorf("unexpected request %s", message.String())
+		return "", nil
+	}
+	client.http = &r
+
+	_, err = client.AttachShell(context.Background(), "reaped")
+	c.Assert(err, ErrorMatches, "attaching shell reaped: http error 500: shell not found")
+
+	shell, err := client.AttachShell(context.Background(), shellID)
+	c.Assert(err, IsNil)
+	_, err = shell.AttachCommand(context.Background(), "")
+	c.Assert(err, NotNil)
+	command, err := shell.AttachCommand(context.Background(), "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4")
+	c.Assert(err, IsNil)
+	output, err := io.ReadAll(command.Stdout)
+	c.Assert(err, IsNil)
+	c.Assert(string(output), Equals, "installed")
+	command.Wait()
+	c.Assert(command.ExitCode(), Equals, 0)
+}
+
+func (s *WinRMSuite) TestShellDisconnectReconnect(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	params := NewParametersBuilder().ResourceURI(ResourceURIPowerShell).Build()
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	stack := "2.0"
+	var actions []string
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, "Identify"):
+			return strings.Replace(identifyResponse, "Stack: 2.0", "Stack: "+stack, 1), nil
+		case strings.Contains(body, ActionDisconnect):
+			c.Check(body, Contains, "<rsp:IdleTimeOut>PT300S</rsp:IdleTimeOut>")
+			actions = append(actions, "disconnect")
+		case strings.Contains(body, ActionReconnect):
+			actions = append(actions, "reconnect")
+		case strings.Contains(body, ActionConnect):
+			c.Check(body, Contains, `<w:Selector Name="ShellId">67A74734-DD32-4F10-89DE-49A060483810</w:Selector>`)
+			c.Check(body, Not(Contains), `<rsp:Connect ShellId=`)
+			actions = append(actions, "connect")
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	shell := client.NewShell("67A74734-DD32-4F10-89DE-49A060483810")
+	var versionErr *ProtocolVersionError
+	c.Assert(errors.As(shell.Disconnect(context.Background(), 5*time.Minute), &versionErr), Equals, true)
+
+	stack = "3.0"
+	client.identity.set(nil)
+	c.Assert(shell.Disconnect(context.Background(), 5*time.Minute), IsNil)
+	c.Assert(shell.Reconnect(context.Background()), IsNil)
+	connected, err := client.ConnectShell(context.Background(), shell.ID())
+	c.Assert(err, IsNil)
+	c.Assert(connected.ID(), Equals, shell.ID())
+	c.Assert(actions, DeepEquals, []string{"disconnect", "reconnect", "connect"})
+
+	// the cmd shells can't be disconnected
+	client.ResourceURI = ""
+	c.Assert(shell.Disconnect(context.Background(), 0), Equals, ErrDisconnectUnsupported)
+	c.Assert(shell.Reconnect(context.Background()), Equals, ErrDisconnectUnsupported)
+	_, err = client.ConnectShell(context.Background(), shell.ID())
+	c.Assert(err, Equals, ErrDisconnectUnsupported)
+	c.Assert(actions, HasLen, 3)
+}
+
+func (s *WinRMSuite) TestShellConcurrentCommands(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/soap+xml")
+		switch request := string(body); {
+		case strings.Contains(request, ActionCommand):
+			// each command gets its own id, echoed by the output requests
+			id := "first"
+			if strings.Contains(request, "second") {
+				id = "second"
+			}
+			_, _ = io.WriteString(w, strings.Replace(executeCommandResponse, "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4", id, 1))
+		case strings.Contains(request, ActionReceive):
+			id := "first"
+			if strings.Contains(request, `CommandId="second"`) {
+				id = "second"
+			}
+			_, _ = io.WriteString(w, doneOutputResponse("output of "+id, 0))
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	client, err := NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "a", "b")
+	c.Assert(err, IsNil)
+	shell := client.NewShell("67A74734-DD32-4F10-89DE-49A060483810")
+
+	var wg sync.WaitGroup
+	outputs := make([]string, 2)
+	for i, name := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			cmd, err := shell.ExecuteWithContext(context.Background(), "echo "+name)
+			c.Check(err, IsNil)
+			if err != nil {
+				return
+			}
+			output, err := io.ReadAll(cmd.Stdout)
+			c.Check(err, IsNil)
+			cmd.Wait()
+			outputs[i] = string(output)
+		}(i, name)
+	}
+	wg.Wait()
+	c.Assert(outputs, DeepEquals, []string{"output of first", "output of second"})
+}
@@ -0,0 +1,179 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultShellPoolHealthCheckAfter is the idle time after which the shells of a ShellPool
+// are checked when ShellPoolOptions.HealthCheckAfter isn't set, as well as the ones of runPool
+const defaultShellPoolHealthCheckAfter = 30 * time.Second
+
+// ShellPoolOptions configures a ShellPool
+type ShellPoolOptions struct {
+	// Size is the number of shells of the pool, which are created upfront
+	Size int
+	// ShellOptions are the settings of the shells
+	ShellOptions ShellOptions
+	// HealthCheckAfter is the idle time after which a shell is checked before being handed out,
+	// a shell deleted by the server (idle timeout, service restart) being replaced.
+	// 30 seconds when zero, a negative value disables the checks.
+	HealthCheckAfter time.Duration
+}
+
+type idleShell struct {
+	shell *Shell
+	since time.Time
+}
+
+// healthy tells if the idle shell can be handed out, checking it still exists on the server
+// when it has been idle for checkAfter, never when negative. A dead shell is closed.
+func (i idleShell) healthy(ctx context.Context, checkAfter time.Duration) bool {
+	if checkAfter < 0 || time.Since(i.since) < checkAfter {
+		return true
+	}
+	if i.shell.ping(ctx) == nil {
+		return true
+	}
+	if i.shell.Close() != nil {
+		i.shell.forget()
+	}
+	return false
+}
+
+// ShellPool keeps warm shells handed out to concurrent callers with Get, and given back with
+// Put, or Discard when they failed. At most Size shells are checked out at once, Get waiting
+// for one to be given back otherwise. It is independent of the pool the Run helpers of the
+// client use when Parameters.ShellPoolSize is set, which doesn't limit the shells in use.
+type ShellPool struct {
+	client  *Client
+	options ShellPoolOptions
+	// slots holds a token per shell checked out
+	slots chan struct{}
+
+	mutex  sync.Mutex
+	idle   []idleShell
+	closed bool
+}
+
+// NewShellPool creates the options.Size shells of a new ShellPool
+func (c *Client) NewShellPool(ctx context.Context, options ShellPoolOptions) (*ShellPool, error) {
+	if options.Size <= 0 {
+		return nil, errors.New("the shell pool size must be positive")
+	}
+	if options.HealthCheckAfter == 0 {
+		options.HealthCheckAfter = defaultShellPoolHealthCheckAfter
+	}
+
+	pool := &ShellPool{client: c, options: options, slots: make(chan struct{}, options.Size)}
+	for i := 0; i < options.Size; i++ {
+		shell, err := c.CreateShellWithOptions(ctx, options.ShellOptions)
+		if err != nil {
+			_ = pool.Close()
+			return nil, err
+		}
+		pool.idle = append(pool.idle, idleShell{shell: shell, since: time.Now()})
+	}
+
+	return pool, nil
+}
+
+// Get checks out a shell, waiting for one to be given back when they are all in use
+func (p *ShellPool) Get(ctx context.Context) (*Shell, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	shell, err := p.take(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return shell, nil
+}
+
+// take returns an idle shell that is alive, or a new one
+func (p *ShellPool) take(ctx context.Context) (*Shell, error) {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, errors.New("the shell pool is closed")
+	}
+	var idle idleShell
+	if n := len(p.idle); n > 0 {
+		idle = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	}
+	p.mutex.Unlock()
+
+	if idle.shell != nil && idle.healthy(ctx, p.options.HealthCheckAfter) {
+		return idle.shell, nil
+	}
+
+	return p.client.CreateShellWithOptions(ctx, p.options.ShellOptions)
+}
+
+// Put gives back a shell checked out with Get
+func (p *ShellPool) Put(shell *Shell) {
+	p.mutex.Lock()
+	closed := p.closed
+	if !closed {
+		p.idle = append(p.idle, idleShell{shell: shell, since: time.Now()})
+	}
+	p.mutex.Unlock()
+
+	if closed {
+		_ = shell.Close()
+	}
+	<-p.slots
+}
+
+// Discard closes a shell checked out with Get which can't be used anymore,
+// a new shell being created in its place on the next Get
+func (p *ShellPool) Discard(shell *Shell) {
+	_ = shell.Close()
+	<-p.slots
+}
+
+// Run runs command in a shell of the pool and waits for its termination.
+// The shell is discarded if the command failed.
+func (p *ShellPool) Run(ctx context.Context, command string) (*CommandResult, error) {
+	shell, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var outWriter, errWriter bytes.Buffer
+	cmd, err := shell.run(ctx, command, nil, &outWriter, &errWriter, nil)
+	if err != nil {
+		p.Discard(shell)
+	} else {
+		p.Put(shell)
+	}
+	if cmd == nil {
+		return nil, err
+	}
+
+	return newCommandResult(cmd, &outWriter, &errWriter), err
+}
+
+// Close closes the idle shells, the ones checked out being closed when given back
+func (p *ShellPool) Close() error {
+	p.mutex.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mutex.Unlock()
+
+	var err error
+	for _, i := range idle {
+		if closeErr := i.shell.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package winrm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func poolRequester(created, deleted *int) *Requester {
+	r := &Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch {
+		case strings.Contains(message.String(), ActionCreate):
+			*created++
+			return createShellResponse, nil
+		case strings.Contains(message.String(), ActionDelete):
+			*deleted++
+			return "", nil
+		case strings.Contains(message.String(), ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(message.String(), ActionReceive):
+			return doneOutputResponse("ok", 0), nil
+		}
+		return "", nil
+	}
+	return r
+}
+
+func (s *WinRMSuite) TestShellPoolCheckout(c *C) {
+	var created, deleted, pings int
+	expired := false
+	client, err := NewClient(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0), "a", "b")
+	c.Assert(err, IsNil)
+	r := poolRequester(&created, &deleted)
+	run := r.http
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), ActionGet) {
+			pings++
+			if expired {
+				return "", &HTTPError{StatusCode: 500, Body: `<f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="2150858843"/>`}
+			}
+			return "", nil
+		}
+		return run(client, message)
+	}
+	client.http = r
+
+	pool, err := client.NewShellPool(context.Background(), ShellPoolOptions{Size: 2, HealthCheckAfter: 20 * time.Millisecond})
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 2)
+
+	first, err := pool.Get(context.Background())
+	c.Assert(err, IsNil)
+	second, err := pool.Get(context.Background())
+	c.Assert(err, IsNil)
+
+	// both shells are checked out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.Get(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	pool.Put(first)
+	pool.Discard(second)
+	c.Assert(deleted, Equals, 1)
+
+	result, err := pool.Run(context.Background(), "hostname")
+	c.Assert(err, IsNil)
+	c.Assert(result.Stdout, Equals, "ok")
+	c.Assert(created, Equals, 2)
+	c.Assert(pings, Equals, 0)
+
+	// a shell idle for long is checked, and replaced when the server deleted it
+	time.Sleep(30 * time.Millisecond)
+	expired = true
+	shell, err := pool.Get(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(pings, Equals, 1)
+	c.Assert(created, Equals, 3)
+	c.Assert(deleted, Equals, 2)
+	pool.Put(shell)
+
+	c.Assert(pool.Close(), IsNil)
+	c.Assert(deleted, Equals, 3)
+	_, err = pool.Get(context.Background())
+	c.Assert(err, ErrorMatches, "the shell pool is closed")
+}
@@ -7,14 +7,20 @@ import (
 )
 
 type HeaderOption struct {
-	key   string
-	value string
+	key        string
+	value      string
+	mustComply bool
 }
 
 func NewHeaderOption(name string, value string) *HeaderOption {
 	return &HeaderOption{key: name, value: value}
 }
 
+// NewMustComplyHeaderOption creates an option the server must fail the request on if it can't honor it
+func NewMustComplyHeaderOption(name string, value string) *HeaderOption {
+	return &HeaderOption{key: name, value: value, mustComply: true}
+}
+
 type SoapHeader struct {
 	to              string
 	replyTo         string
@@ -159,6 +165,9 @@ func (sh *SoapHeader) Build() *SoapMessage {
 		for _, option := range sh.options {
 			e := sh.createElement(set, "Option", DOM_NS_WSMAN_DMTF)
 			e.SetAttr("Name", option.key)
+			if option.mustComply {
+				e.SetAttr("MustComply", "true")
+			}
 			e.SetContent(option.value)
 		}
 	}
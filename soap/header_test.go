@@ -149,3 +149,10 @@ func (s *MySuite) TestOptionsHeaderBuild(c *C) {
 
 	c.Check(msg.String(), Equals, expected)
 }
+
+func (s *MySuite) TestMustComplyOptionHeaderBuild(c *C) {
+	h := initDocument()
+	msg := h.AddOption(NewMustComplyHeaderOption("protocolversion", "2.3")).Build()
+
+	c.Check(msg.String(), Matches, `(?s).*<w:Option Name="protocolversion" MustComply="true">2.3</w:Option>.*`)
+}
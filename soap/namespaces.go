@@ -19,6 +19,7 @@ const (
 	NS_SCHEMA_INST = "http://www.w3.org/2001/XMLSchema-instance"
 	NS_WIN_SHELL   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell"
 	NS_WSMAN_FAULT = "http://schemas.microsoft.com/wbem/wsman/1/wsmanfault"
+	NS_WSMAN_ID    = "http://schemas.dmtf.org/wbem/wsman/identity/1/wsmanidentity.xsd"
 )
 
 // Namespace Prefixes
@@ -35,6 +36,7 @@ const (
 	NSP_SCHEMA_INST = "xsi"
 	NSP_WIN_SHELL   = "rsp"
 	NSP_WSMAN_FAULT = "f"
+	NSP_WSMAN_ID    = "wsmid"
 )
 
 // DOM Namespaces
@@ -51,6 +53,7 @@ var (
 	DOM_NS_SCHEMA_INST = dom.Namespace{Prefix: NSP_SCHEMA_INST, Uri: NS_SCHEMA_INST}
 	DOM_NS_WIN_SHELL   = dom.Namespace{Prefix: NSP_WIN_SHELL, Uri: NS_WIN_SHELL}
 	DOM_NS_WSMAN_FAULT = dom.Namespace{Prefix: NSP_WSMAN_FAULT, Uri: NS_WSMAN_FAULT}
+	DOM_NS_WSMAN_ID    = dom.Namespace{Prefix: NSP_WSMAN_ID, Uri: NS_WSMAN_ID}
 )
 
 var MostUsed = [...]dom.Namespace{
@@ -79,6 +82,7 @@ func GetAllXPathNamespaces() func(o *goxpath.Opts) {
 		NSP_SCHEMA_INST: NS_SCHEMA_INST,
 		NSP_WIN_SHELL:   NS_WIN_SHELL,
 		NSP_WSMAN_FAULT: NS_WSMAN_FAULT,
+		NSP_WSMAN_ID:    NS_WSMAN_ID,
 	}
 
 	return func(o *goxpath.Opts) {
@@ -0,0 +1,108 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHTunnel opens the connections to the WinRM endpoints through an SSH bastion (jump host),
+// as direct-tcpip channels of a single SSH connection. The SSH connection is established on
+// the first dial, shared by the following ones, and established again once it is lost.
+type SSHTunnel struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mutex  sync.Mutex
+	client *ssh.Client
+}
+
+// NewSSHTunnel returns a tunnel through the SSH server at addr (host:port), authenticated with config
+func NewSSHTunnel(addr string, config *ssh.ClientConfig) *SSHTunnel {
+	return &SSHTunnel{
+		addr:   addr,
+		config: config,
+	}
+}
+
+// Dial opens a connection to addr from the bastion
+func (t *SSHTunnel) Dial(network, addr string) (net.Conn, error) {
+	return t.DialContext(context.Background(), network, addr)
+}
+
+// DialContext opens a connection to addr from the bastion, giving up when ctx is done
+func (t *SSHTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := t.sshClient()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s through ssh bastion %s: %w", addr, t.addr, err)
+	}
+
+	return conn, nil
+}
+
+// sshClient returns the SSH connection to the bastion, connecting it first if needed
+func (t *SSHTunnel) sshClient() (*ssh.Client, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	client, err := ssh.Dial("tcp", t.addr, t.config)
+	if err != nil {
+		return nil, fmt.Errorf("connect to ssh bastion %s: %w", t.addr, err)
+	}
+	t.client = client
+
+	// forget the connection once it is closed, so that the next dial connects again
+	go func() {
+		_ = client.Wait()
+		t.mutex.Lock()
+		if t.client == client {
+			t.client = nil
+		}
+		t.mutex.Unlock()
+	}()
+
+	return client, nil
+}
+
+// Close closes the SSH connection to the bastion, the tunneled connections with it
+func (t *SSHTunnel) Close() error {
+	t.mutex.Lock()
+	client := t.client
+	t.client = nil
+	t.mutex.Unlock()
+
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
+
+// NewClientWithSSHTunnel creates a basic auth transport reaching the WinRM server through tunnel
+func NewClientWithSSHTunnel(tunnel *SSHTunnel) *clientRequest {
+	return &clientRequest{
+		dialContext: tunnel.DialContext,
+		proxyfunc:   noProxy,
+	}
+}
+
+// NewClientNTLMWithSSHTunnel creates a NTLM transport reaching the WinRM server through tunnel
+func NewClientNTLMWithSSHTunnel(tunnel *SSHTunnel) *ClientNTLM {
+	return &ClientNTLM{
+		clientRequest: clientRequest{
+			dialContext: tunnel.DialContext,
+			proxyfunc:   noProxy,
+		},
+	}
+}
@@ -0,0 +1,133 @@
+package winrm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+	. "gopkg.in/check.v1"
+)
+
+// startSSHBastion starts an SSH server forwarding the direct-tcpip channels,
+// and returns its address and the number of SSH connections it accepted
+func startSSHBastion(c *C) (string, *int32) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+	signer, err := ssh.NewSignerFromKey(private)
+	c.Assert(err, IsNil)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "jump" && string(password) == "s3cr3t" {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	var connections int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				atomic.AddInt32(&connections, 1)
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					go forwardChannel(newChannel)
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), &connections
+}
+
+func forwardChannel(newChannel ssh.NewChannel) {
+	data := newChannel.ExtraData()
+	if newChannel.ChannelType() != "direct-tcpip" || len(data) < 4 {
+		_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		return
+	}
+	hostLength := binary.BigEndian.Uint32(data)
+	host := string(data[4 : 4+hostLength])
+	port := binary.BigEndian.Uint32(data[4+hostLength:])
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		_, _ = io.Copy(target, channel)
+		target.Close()
+	}()
+	_, _ = io.Copy(channel, target)
+	channel.Close()
+}
+
+func (s *WinRMSuite) TestSSHTunnel(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		_, _ = w.Write([]byte(createShellResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	bastion, connections := startSSHBastion(c)
+	tunnel := NewSSHTunnel(bastion, &ssh.ClientConfig{
+		User:            "jump",
+		Auth:            []ssh.AuthMethod{ssh.Password("s3cr3t")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	defer tunnel.Close()
+
+	params := NewParametersBuilder().TransportDecorator(func() Transporter { return NewClientWithSSHTunnel(tunnel) }).Build()
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test", params)
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 3; i++ {
+		shell, err := client.CreateShell()
+		c.Assert(err, IsNil)
+		c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	}
+	c.Assert(atomic.LoadInt32(connections), Equals, int32(1))
+
+	// a lost SSH connection is established again
+	c.Assert(tunnel.Close(), IsNil)
+	client.http.(*clientRequest).Reauthenticate()
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Assert(atomic.LoadInt32(connections), Equals, int32(2))
+
+	refused := NewSSHTunnel(bastion, &ssh.ClientConfig{
+		User:            "jump",
+		Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	_, err = refused.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	c.Assert(err, ErrorMatches, "connect to ssh bastion .*")
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package winrm
+
+import (
+	"context"
+	"errors"
+
+	"github.com/satendraraj/winrm/soap"
+)
+
+// ClientSSPI provides a transport authenticating with the Windows SSPI as the user running
+// the process. It is only available when the client runs on Windows, elsewhere its
+// Transport method fails.
+type ClientSSPI struct {
+	// SPN is the service principal name of the endpoint, HTTP/<host> by default
+	SPN string
+}
+
+var errSSPIUnavailable = errors.New("SSPI authentication is only available on Windows")
+
+// Transport fails outside of Windows
+func (c *ClientSSPI) Transport(endpoint *Endpoint) error {
+	return errSSPIUnavailable
+}
+
+// Post fails outside of Windows
+func (c *ClientSSPI) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	return "", errSSPIUnavailable
+}
@@ -0,0 +1,220 @@
+//go:build windows
+
+package winrm
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/satendraraj/winrm/soap"
+)
+
+var (
+	secur32                        = syscall.NewLazyDLL("secur32.dll")
+	procAcquireCredentialsHandleW  = secur32.NewProc("AcquireCredentialsHandleW")
+	procInitializeSecurityContextW = secur32.NewProc("InitializeSecurityContextW")
+	procCompleteAuthToken          = secur32.NewProc("CompleteAuthToken")
+	procFreeContextBuffer          = secur32.NewProc("FreeContextBuffer")
+	procDeleteSecurityContext      = secur32.NewProc("DeleteSecurityContext")
+	procFreeCredentialsHandle      = secur32.NewProc("FreeCredentialsHandle")
+)
+
+const (
+	secpkgCredOutbound       = 2
+	securityNativeDrep       = 0x10
+	secbufferVersion         = 0
+	secbufferToken           = 2
+	iscReqMutualAuth         = 0x2
+	iscReqAllocateMemory     = 0x100
+	iscReqConnection         = 0x800
+	secEOK                   = 0
+	secIContinueNeeded       = 0x00090312
+	secICompleteNeeded       = 0x00090313
+	secICompleteAndContinue  = 0x00090314
+	sspiMaxHandshakeRequests = 4
+)
+
+type secHandle struct {
+	lower, upper uintptr
+}
+
+type timeStamp struct {
+	lowPart  uint32
+	highPart int32
+}
+
+type secBuffer struct {
+	size       uint32
+	bufferType uint32
+	buffer     *byte
+}
+
+type secBufferDesc struct {
+	version uint32
+	count   uint32
+	buffers *secBuffer
+}
+
+// ClientSSPI provides a transport authenticating with the Negotiate package of the
+// Windows SSPI, as the user running the process: Kerberos or NTLM single sign-on
+// without any password handling. It is only available when the client runs on Windows.
+type ClientSSPI struct {
+	clientRequest
+	// SPN is the service principal name of the endpoint, HTTP/<host> by default
+	SPN string
+}
+
+// Transport prepares the HTTP transport and the SPN of the endpoint
+func (c *ClientSSPI) Transport(endpoint *Endpoint) error {
+	if c.SPN == "" {
+		c.SPN = endpoint.SPN
+	}
+	if c.SPN == "" {
+		c.SPN = "HTTP/" + endpoint.hostname()
+	}
+	return c.clientRequest.Transport(endpoint)
+}
+
+// Post authenticates request with an SSPI security context, the handshake
+// being run on the connection the request is sent on
+func (c *ClientSSPI) Post(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	httpClient := c.httpClient()
+
+	return post(ctx, client, request, func(req *http.Request) (*http.Response, error) {
+		sc, err := newSSPIContext(c.SPN)
+		if err != nil {
+			return nil, err
+		}
+		defer sc.release()
+
+		var input []byte
+		for i := 0; i < sspiMaxHandshakeRequests; i++ {
+			token, err := sc.step(input)
+			if err != nil {
+				return nil, err
+			}
+
+			attempt := req.Clone(req.Context())
+			if req.GetBody != nil {
+				if attempt.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			attempt.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+
+			resp, err := httpClient.Do(attempt)
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode != http.StatusUnauthorized {
+				return resp, nil
+			}
+			if input = negotiateToken(resp.Header); input == nil {
+				return resp, nil
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		return nil, fmt.Errorf("SSPI handshake not complete after %d requests", sspiMaxHandshakeRequests)
+	})
+}
+
+// sspiContext is a client security context of the Negotiate package
+type sspiContext struct {
+	target     *uint16
+	credential secHandle
+	context    secHandle
+	started    bool
+}
+
+func newSSPIContext(spn string) (*sspiContext, error) {
+	target, err := syscall.UTF16PtrFromString(spn)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := syscall.UTF16PtrFromString("Negotiate")
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &sspiContext{target: target}
+	var expiry timeStamp
+	status, _, _ := procAcquireCredentialsHandleW.Call(0, uintptr(unsafe.Pointer(pkg)), secpkgCredOutbound,
+		0, 0, 0, 0, uintptr(unsafe.Pointer(&sc.credential)), uintptr(unsafe.Pointer(&expiry)))
+	if status != secEOK {
+		return nil, fmt.Errorf("AcquireCredentialsHandle failed with status 0x%08x", uint32(status))
+	}
+
+	return sc, nil
+}
+
+// step feeds the server token to the security context and returns the next client token
+func (sc *sspiContext) step(input []byte) ([]byte, error) {
+	out := secBuffer{bufferType: secbufferToken}
+	outDesc := secBufferDesc{version: secbufferVersion, count: 1, buffers: &out}
+
+	var inDesc *secBufferDesc
+	var contextHandle *secHandle
+	if sc.started {
+		contextHandle = &sc.context
+		if len(input) > 0 {
+			in := secBuffer{size: uint32(len(input)), bufferType: secbufferToken, buffer: &input[0]}
+			inDesc = &secBufferDesc{version: secbufferVersion, count: 1, buffers: &in}
+		}
+	}
+
+	var attributes uint32
+	var expiry timeStamp
+	status, _, _ := procInitializeSecurityContextW.Call(uintptr(unsafe.Pointer(&sc.credential)),
+		uintptr(unsafe.Pointer(contextHandle)), uintptr(unsafe.Pointer(sc.target)),
+		iscReqAllocateMemory|iscReqConnection|iscReqMutualAuth, 0, securityNativeDrep,
+		uintptr(unsafe.Pointer(inDesc)), 0, uintptr(unsafe.Pointer(&sc.context)),
+		uintptr(unsafe.Pointer(&outDesc)), uintptr(unsafe.Pointer(&attributes)), uintptr(unsafe.Pointer(&expiry)))
+	sc.started = true
+
+	switch status {
+	case secEOK, secIContinueNeeded:
+	case secICompleteNeeded, secICompleteAndContinue:
+		if completed, _, _ := procCompleteAuthToken.Call(uintptr(unsafe.Pointer(&sc.context)),
+			uintptr(unsafe.Pointer(&outDesc))); completed != secEOK {
+			return nil, fmt.Errorf("CompleteAuthToken failed with status 0x%08x", uint32(completed))
+		}
+	default:
+		return nil, fmt.Errorf("InitializeSecurityContext failed with status 0x%08x", uint32(status))
+	}
+
+	if out.buffer == nil {
+		return nil, nil
+	}
+	defer procFreeContextBuffer.Call(uintptr(unsafe.Pointer(out.buffer)))
+
+	return append([]byte(nil), unsafe.Slice(out.buffer, out.size)...), nil
+}
+
+func (sc *sspiContext) release() {
+	if sc.started {
+		procDeleteSecurityContext.Call(uintptr(unsafe.Pointer(&sc.context)))
+	}
+	procFreeCredentialsHandle.Call(uintptr(unsafe.Pointer(&sc.credential)))
+}
+
+// negotiateToken returns the decoded token of the Negotiate challenge of header, nil if there is none
+func negotiateToken(header http.Header) []byte {
+	for _, value := range header.Values("WWW-Authenticate") {
+		fields := strings.Fields(value)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "Negotiate") {
+			continue
+		}
+		if token, err := base64.StdEncoding.DecodeString(fields[1]); err == nil {
+			return token
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package winrm
+
+import (
+	"net/http"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestNegotiateToken(c *C) {
+	header := http.Header{}
+	c.Assert(negotiateToken(header), IsNil)
+
+	header.Add("WWW-Authenticate", "Basic realm=\"WSMAN\"")
+	header.Add("WWW-Authenticate", "Negotiate")
+	c.Assert(negotiateToken(header), IsNil)
+
+	header.Add("WWW-Authenticate", "Negotiate dG9rZW4=")
+	c.Assert(string(negotiateToken(header)), Equals, "token")
+}
@@ -0,0 +1,86 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Step is one command of a batch run by RunSteps
+type Step struct {
+	// Name identifies the step in the results and errors, it defaults to the command
+	Name        string
+	Command     string
+	Interpreter Interpreter
+	// ContinueOnError runs the following steps even when this one fails
+	ContinueOnError bool
+}
+
+// StepResult is the outcome of a Step. Err holds the error which prevented the
+// step from completing, a non zero exit code isn't reported there.
+type StepResult struct {
+	Name string
+	CommandResult
+	Duration time.Duration
+	Err      error
+}
+
+// Failed tells if the step didn't complete or exited with a non zero code
+func (r *StepResult) Failed() bool {
+	return r.Err != nil || r.ExitCode != 0
+}
+
+// RunSteps runs steps in order in the session shell and returns their results.
+// It stops at the first failed step without ContinueOnError, returning the results
+// up to that step and an error wrapping ErrStepFailed.
+func (s *Session) RunSteps(ctx context.Context, steps []Step) ([]StepResult, error) {
+	results := make([]StepResult, 0, len(steps))
+	for i, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		result := StepResult{Name: step.Name}
+		if result.Name == "" {
+			result.Name = step.Command
+		}
+
+		start := time.Now()
+		var commandResult *CommandResult
+		var err error
+		switch step.Interpreter {
+		case InterpreterCmd:
+			commandResult, err = s.Run(ctx, step.Command)
+		case InterpreterPowerShell:
+			commandResult, err = s.RunPS(ctx, step.Command)
+		default:
+			err = fmt.Errorf("unknown interpreter %d", step.Interpreter)
+		}
+		result.Duration = time.Since(start)
+		result.Err = err
+		if commandResult != nil {
+			result.CommandResult = *commandResult
+		}
+		results = append(results, result)
+
+		if result.Failed() && !step.ContinueOnError {
+			if err != nil {
+				return results, fmt.Errorf("%w: step %d (%s): %w", ErrStepFailed, i+1, result.Name, err)
+			}
+			return results, fmt.Errorf("%w: step %d (%s) exited with code %d", ErrStepFailed, i+1, result.Name, result.ExitCode)
+		}
+	}
+
+	return results, nil
+}
+
+// RunSteps runs steps in order in a new Session, see Session.RunSteps
+func (c *Client) RunSteps(ctx context.Context, steps []Step, options ...SessionOption) ([]StepResult, error) {
+	session, err := c.NewSession(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	return session.RunSteps(ctx, steps)
+}
@@ -0,0 +1,57 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/satendraraj/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestRunSteps(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	var shells, commands []string
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			shells = append(shells, body)
+			return createShellResponse, nil
+		case strings.Contains(body, "shell/Command"):
+			commands = append(commands, body)
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Receive") && strings.Contains(commands[len(commands)-1], "exit 2"):
+			return doneOutputResponse("", 2), nil
+		case strings.Contains(body, "shell/Receive"):
+			return doneOutputResponse("ok", 0), nil
+		default:
+			return "", nil
+		}
+	}
+	client.http = &r
+
+	results, err := client.RunSteps(context.Background(), []Step{
+		{Name: "prepare", Command: "mkdir C:\\app"},
+		{Command: "exit 2", ContinueOnError: true},
+		{Command: "Get-Date", Interpreter: InterpreterPowerShell},
+		{Name: "install", Command: "exit 2"},
+		{Name: "never", Command: "echo never"},
+	})
+	c.Assert(errors.Is(err, ErrStepFailed), Equals, true)
+	c.Assert(err, ErrorMatches, `step failed: step 4 \(install\) exited with code 2`)
+	c.Assert(shells, HasLen, 1)
+	c.Assert(commands, HasLen, 4)
+	c.Assert(commands[2], Contains, "powershell.exe -EncodedCommand")
+	c.Assert(results, HasLen, 4)
+	c.Assert(results[0].Name, Equals, "prepare")
+	c.Assert(results[0].Stdout, Equals, "ok")
+	c.Assert(results[1].Name, Equals, "exit 2")
+	c.Assert(results[1].Failed(), Equals, true)
+	c.Assert(results[2].Failed(), Equals, false)
+	c.Assert(results[3].ExitCode, Equals, 2)
+}
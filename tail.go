@@ -0,0 +1,139 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tailReconnects is the number of successive failed attempts after which TailFile gives up
+const tailReconnects = 5
+
+// tailReconnectDelay is the pause between two attempts to resume a tail
+var tailReconnectDelay = time.Second
+
+// TailFile streams the lines of the file at path on the remote host.
+// Without follow, the lines channel is closed once the whole file is read; with follow,
+// lines appended to the file keep coming (Get-Content -Wait) until the context is
+// canceled, which is then not reported as an error.
+// When the connection to the host is lost, the tail is resumed after the lines already
+// sent. The error channel receives one value once the lines channel is closed.
+func (c *Client) TailFile(ctx context.Context, path string, follow bool) (<-chan string, <-chan error) {
+	lines := make(chan string)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		err := c.tailFile(ctx, path, follow, lines)
+		close(lines)
+		if follow && errors.Is(err, ctx.Err()) {
+			err = nil
+		}
+		errc <- err
+	}()
+
+	return lines, errc
+}
+
+func (c *Client) tailFile(ctx context.Context, path string, follow bool, lines chan<- string) error {
+	writer := &lineWriter{done: ctx.Done(), ctxErr: ctx.Err, lines: lines}
+	failures := 0
+	for {
+		command := Powershell(tailScript(path, follow, writer.count))
+		if command == "" {
+			return ErrCommandEncoding
+		}
+
+		var stderr bytes.Buffer
+		sent := writer.count
+		cmd, err := c.runWithContextWithInput(ctx, command, nil, writer, &stderr, nil)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err == nil {
+			if cmd.ExitCode() != 0 {
+				return fmt.Errorf("tail of %s failed with exit code %d: %s", path, cmd.ExitCode(), strings.TrimSpace(stderr.String()))
+			}
+			return writer.flush()
+		}
+
+		// the command was interrupted, resume it after the complete lines already sent
+		writer.partial = nil
+		if writer.count > sent {
+			failures = 0
+		}
+		failures++
+		if failures >= tailReconnects {
+			return fmt.Errorf("tail of %s interrupted: %w", path, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(tailReconnectDelay):
+		}
+	}
+}
+
+// tailScript returns the PowerShell script reading path, skipping its first skip lines
+func tailScript(path string, follow bool, skip int) string {
+	script := "[Console]::OutputEncoding = [Text.Encoding]::UTF8;Get-Content -LiteralPath " + psQuote(path) + " -ErrorAction Stop"
+	if follow {
+		script += " -Wait"
+	}
+	if skip > 0 {
+		script += " | Select-Object -Skip " + strconv.Itoa(skip)
+	}
+
+	return script
+}
+
+// lineWriter splits the output written to it in lines sent to a channel,
+// until done is closed, the error being then ctxErr
+type lineWriter struct {
+	done    <-chan struct{}
+	ctxErr  func() error
+	lines   chan<- string
+	partial []byte
+	count   int
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := strings.TrimSuffix(string(w.partial[:i]), "\r")
+		w.partial = w.partial[i+1:]
+		if err := w.send(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// flush sends the last line when it doesn't end with a newline
+func (w *lineWriter) flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	line := strings.TrimSuffix(string(w.partial), "\r")
+	w.partial = nil
+
+	return w.send(line)
+}
+
+func (w *lineWriter) send(line string) error {
+	select {
+	case w.lines <- line:
+		w.count++
+		return nil
+	case <-w.done:
+		return w.ctxErr()
+	}
+}
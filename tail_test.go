@@ -0,0 +1,60 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestTailFile(c *C) {
+	creates, receives := 0, 0
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		b, _ := io.ReadAll(r.Body)
+		body := string(b)
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			creates++
+			if creates == 1 {
+				// the first attempt is interrupted
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			fmt.Fprintln(w, createShellResponse)
+		case strings.Contains(body, "shell/Command<"):
+			fmt.Fprintln(w, executeCommandResponse)
+		case strings.Contains(body, "shell/Receive"):
+			receives++
+			if receives == 1 {
+				fmt.Fprintln(w, outputResponse)
+			} else {
+				fmt.Fprintln(w, doneCommandExitCode0Response)
+			}
+		default:
+			fmt.Fprintln(w, response)
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	client, err := NewClient(NewEndpoint(host, port, false, false, nil, nil, nil, 0), "test", "test")
+	c.Assert(err, IsNil)
+
+	lines, errc := client.TailFile(context.Background(), `C:\install.log`, false)
+	var got []string
+	for line := range lines {
+		got = append(got, line)
+	}
+	c.Assert(<-errc, IsNil)
+	c.Assert(got, DeepEquals, []string{"That's all folks!!!"})
+	c.Assert(creates, Equals, 2)
+}
+
+func (s *WinRMSuite) TestTailScript(c *C) {
+	c.Assert(tailScript(`C:\it's.log`, false, 0), Equals,
+		`[Console]::OutputEncoding = [Text.Encoding]::UTF8;Get-Content -LiteralPath 'C:\it''s.log' -ErrorAction Stop`)
+	c.Assert(tailScript(`C:\a.log`, true, 12), Equals,
+		`[Console]::OutputEncoding = [Text.Encoding]::UTF8;Get-Content -LiteralPath 'C:\a.log' -ErrorAction Stop -Wait | Select-Object -Skip 12`)
+}
@@ -0,0 +1,134 @@
+package winrm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Interpreter is the command interpreter a CommandTemplate renders for
+type Interpreter int
+
+const (
+	// InterpreterCmd renders values as cmd.exe arguments
+	InterpreterCmd Interpreter = iota
+	// InterpreterPowerShell renders values as PowerShell string literals
+	InterpreterPowerShell
+)
+
+var placeholder = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+// CommandTemplate is a command whose {{name}} placeholders are substituted
+// with values quoted for the target interpreter, so they are always seen as a
+// single literal argument whatever characters they contain
+type CommandTemplate struct {
+	interpreter Interpreter
+	text        string
+	names       []string
+}
+
+// NewCommandTemplate parses text, like `dir {{path}}` or `Get-Item -Path {{path}}`
+func NewCommandTemplate(interpreter Interpreter, text string) (*CommandTemplate, error) {
+	if interpreter != InterpreterCmd && interpreter != InterpreterPowerShell {
+		return nil, fmt.Errorf("unknown interpreter %d", interpreter)
+	}
+
+	remaining := placeholder.ReplaceAllString(text, "")
+	if strings.Contains(remaining, "{{") || strings.Contains(remaining, "}}") {
+		return nil, fmt.Errorf("invalid placeholder in %q", text)
+	}
+
+	template := &CommandTemplate{interpreter: interpreter, text: text}
+	for _, match := range placeholder.FindAllStringSubmatch(text, -1) {
+		template.names = append(template.names, match[1])
+	}
+	return template, nil
+}
+
+// Render substitutes the placeholders with the quoted values, all of them being required
+func (t *CommandTemplate) Render(values map[string]string) (string, error) {
+	for _, name := range t.names {
+		if _, ok := values[name]; !ok {
+			return "", fmt.Errorf("missing value for placeholder %q", name)
+		}
+	}
+
+	var err error
+	command := placeholder.ReplaceAllStringFunc(t.text, func(match string) string {
+		value := values[placeholder.FindStringSubmatch(match)[1]]
+		if t.interpreter == InterpreterPowerShell {
+			return QuotePowerShell(value)
+		}
+		quoted, quoteErr := QuoteCmd(value)
+		if quoteErr != nil && err == nil {
+			err = quoteErr
+		}
+		return quoted
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return command, nil
+}
+
+// QuotePowerShell returns s as a PowerShell string literal
+func QuotePowerShell(s string) string {
+	return psQuote(s)
+}
+
+// QuoteCmd returns s as a single argument of a command run through cmd.exe:
+// quoted following the rules of the Microsoft C runtime, then with the characters
+// cmd.exe interprets escaped with carets, so that neither expands nor splits it
+func QuoteCmd(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n\x00") {
+		return "", errors.New("cmd.exe arguments can't contain line breaks or NUL characters")
+	}
+
+	return cmdEscape(argvQuote(s)), nil
+}
+
+// cmdEscape escapes with carets the characters cmd.exe interprets in s, quotes included
+// so that it never enters its quoted mode in which carets are kept as is
+func cmdEscape(s string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`^&|<>()%!"`, r) {
+			escaped.WriteRune('^')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// argvQuote quotes s so that CommandLineToArgvW and the C runtime parse it back as one argument
+func argvQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	backslashes := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			backslashes++
+		case '"':
+			// backslashes preceding a quote are escaped, as well as the quote
+			quoted.WriteString(strings.Repeat(`\`, 2*backslashes+1))
+			quoted.WriteByte(c)
+			backslashes = 0
+		default:
+			quoted.WriteString(strings.Repeat(`\`, backslashes))
+			quoted.WriteByte(c)
+			backslashes = 0
+		}
+	}
+	// backslashes before the closing quote are escaped
+	quoted.WriteString(strings.Repeat(`\`, 2*backslashes))
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
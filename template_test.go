@@ -0,0 +1,46 @@
+package winrm
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestArgvQuote(c *C) {
+	for value, expected := range map[string]string{
+		"":                  `""`,
+		`C:\Temp`:           `C:\Temp`,
+		`C:\Program Files\`: `"C:\Program Files\\"`,
+		`say "hi"`:          `"say \"hi\""`,
+		`a\"b`:              `"a\\\"b"`,
+	} {
+		c.Assert(argvQuote(value), Equals, expected, Commentf("%q", value))
+	}
+}
+
+func (s *WinRMSuite) TestQuoteCmd(c *C) {
+	quoted, err := QuoteCmd(`C:\Program Files\100% & "more"`)
+	c.Assert(err, IsNil)
+	c.Assert(quoted, Equals, `^"C:\Program Files\100^% ^& \^"more\^"^"`)
+
+	_, err = QuoteCmd("two\nlines")
+	c.Assert(err, NotNil)
+}
+
+func (s *WinRMSuite) TestCommandTemplate(c *C) {
+	cmd, err := NewCommandTemplate(InterpreterCmd, `dir /s {{ path }} > {{out}}`)
+	c.Assert(err, IsNil)
+	command, err := cmd.Render(map[string]string{"path": `C:\a b`, "out": "list.txt"})
+	c.Assert(err, IsNil)
+	c.Assert(command, Equals, `dir /s ^"C:\a b^" > list.txt`)
+
+	_, err = cmd.Render(map[string]string{"path": `C:\`})
+	c.Assert(err, ErrorMatches, `missing value for placeholder "out"`)
+
+	ps, err := NewCommandTemplate(InterpreterPowerShell, `Get-Item -Path {{path}}`)
+	c.Assert(err, IsNil)
+	command, err = ps.Render(map[string]string{"path": `C:\it's $here`})
+	c.Assert(err, IsNil)
+	c.Assert(command, Equals, `Get-Item -Path 'C:\it''s $here'`)
+
+	_, err = NewCommandTemplate(InterpreterCmd, `echo {{not valid}}`)
+	c.Assert(err, ErrorMatches, "invalid placeholder.*")
+}
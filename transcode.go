@@ -0,0 +1,115 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// codepageEncodings maps the Windows OEM and ANSI codepages to their encoding
+var codepageEncodings = map[int]encoding.Encoding{
+	437:   charmap.CodePage437,
+	850:   charmap.CodePage850,
+	852:   charmap.CodePage852,
+	855:   charmap.CodePage855,
+	858:   charmap.CodePage858,
+	860:   charmap.CodePage860,
+	862:   charmap.CodePage862,
+	863:   charmap.CodePage863,
+	865:   charmap.CodePage865,
+	866:   charmap.CodePage866,
+	874:   charmap.Windows874,
+	932:   japanese.ShiftJIS,
+	936:   simplifiedchinese.GBK,
+	949:   korean.EUCKR,
+	950:   traditionalchinese.Big5,
+	1250:  charmap.Windows1250,
+	1251:  charmap.Windows1251,
+	1252:  charmap.Windows1252,
+	1253:  charmap.Windows1253,
+	1254:  charmap.Windows1254,
+	1255:  charmap.Windows1255,
+	1256:  charmap.Windows1256,
+	1257:  charmap.Windows1257,
+	1258:  charmap.Windows1258,
+	20866: charmap.KOI8R,
+	21866: charmap.KOI8U,
+	28591: charmap.ISO8859_1,
+	28592: charmap.ISO8859_2,
+	28595: charmap.ISO8859_5,
+	28597: charmap.ISO8859_7,
+	28605: charmap.ISO8859_15,
+	54936: simplifiedchinese.GB18030,
+	65001: unicode.UTF8,
+}
+
+// CodepageEncoding returns the encoding of the Windows codepage, like 850 or 1252,
+// to be set as Parameters.OutputEncoding
+func CodepageEncoding(codepage int) (encoding.Encoding, error) {
+	enc, ok := codepageEncodings[codepage]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codepage %d", codepage)
+	}
+	return enc, nil
+}
+
+// DetectCodepage returns the OEM codepage of the remote host, the one console programs
+// usually write their output in whatever the console codepage. chcp isn't used since it
+// reports the codepage the shell was created with (ShellOptions.Codepage).
+func (c *Client) DetectCodepage(ctx context.Context) (int, error) {
+	stdout, stderr, exitCode, err := c.RunCmdWithContext(ctx, `reg query HKLM\SYSTEM\CurrentControlSet\Control\Nls\CodePage /v OEMCP`)
+	if err != nil {
+		return 0, err
+	}
+	if exitCode != 0 {
+		return 0, fmt.Errorf("reading the OEM codepage failed with exit code %d: %s", exitCode, strings.TrimSpace(stderr))
+	}
+
+	// the value line is like "    OEMCP    REG_SZ    850"
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "OEMCP" {
+			return strconv.Atoi(fields[2])
+		}
+	}
+	return 0, errors.New("OEM codepage not found in the registry")
+}
+
+// transcoder decodes a byte stream to UTF-8. It keeps the bytes of a character
+// split across several Receive responses until the rest of it comes.
+type transcoder struct {
+	transformer transform.Transformer
+	pending     []byte
+}
+
+func newTranscoder(enc encoding.Encoding) *transcoder {
+	return &transcoder{transformer: enc.NewDecoder()}
+}
+
+// decode returns data decoded to UTF-8, atEOF telling there is no more data to come
+func (t *transcoder) decode(data []byte, atEOF bool) []byte {
+	src := append(t.pending, data...)
+	dst := make([]byte, 2*len(src)+8)
+	for {
+		nDst, nSrc, err := t.transformer.Transform(dst, src, atEOF)
+		if errors.Is(err, transform.ErrShortDst) {
+			// the decoders are stateless, the whole input can be decoded again
+			t.transformer.Reset()
+			dst = make([]byte, 2*len(dst))
+			continue
+		}
+		t.pending = append([]byte(nil), src[nSrc:]...)
+		return dst[:nDst]
+	}
+}
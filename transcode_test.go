@@ -0,0 +1,82 @@
+package winrm
+
+import (
+	"context"
+	"strings"
+
+	"github.com/satendraraj/winrm/soap"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestTranscoder(c *C) {
+	t := newTranscoder(charmap.CodePage850)
+	c.Assert(string(t.decode([]byte("R\x82pertoire de C:\\"), false)), Equals, "Répertoire de C:\\")
+}
+
+func (s *WinRMSuite) TestTranscoderSplitCharacter(c *C) {
+	// 日本 in Shift JIS, its first character split between two chunks
+	t := newTranscoder(japanese.ShiftJIS)
+	c.Assert(string(t.decode([]byte("\x93"), false)), Equals, "")
+	c.Assert(string(t.decode([]byte("\xfa\x96\x7b"), false)), Equals, "日本")
+	c.Assert(string(t.decode([]byte("\x93"), true)), Equals, "\ufffd")
+}
+
+func (s *WinRMSuite) TestCodepageEncoding(c *C) {
+	enc, err := CodepageEncoding(1252)
+	c.Assert(err, IsNil)
+	c.Assert(enc, Equals, charmap.Windows1252)
+	_, err = CodepageEncoding(42)
+	c.Assert(err, ErrorMatches, "unsupported codepage 42")
+}
+
+func (s *WinRMSuite) TestOutputEncoding(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	params := NewParametersBuilder().OutputEncoding(charmap.CodePage850).Build()
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", params)
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionCommand):
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return doneOutputResponse("Volume in drive C has no label.\r\n R\x82pertoire de C:\\\r\n", 0), nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	stdout, _, _, err := client.RunCmdWithContext(context.Background(), "dir")
+	c.Assert(err, IsNil)
+	c.Assert(stdout, Equals, "Volume in drive C has no label.\r\n Répertoire de C:\\\r\n")
+}
+
+func (s *WinRMSuite) TestDetectCodepage(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		switch body := message.String(); {
+		case strings.Contains(body, ActionCreate):
+			return createShellResponse, nil
+		case strings.Contains(body, ActionCommand):
+			c.Check(body, Contains, "OEMCP")
+			return executeCommandResponse, nil
+		case strings.Contains(body, ActionReceive):
+			return doneOutputResponse("\r\nHKEY_LOCAL_MACHINE\\SYSTEM\\CurrentControlSet\\Control\\Nls\\CodePage\r\n    OEMCP    REG_SZ    850\r\n\r\n", 0), nil
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	codepage, err := client.DetectCodepage(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(codepage, Equals, 850)
+}